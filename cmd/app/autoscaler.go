@@ -22,24 +22,40 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"time"
 
 	// import pprof for performance diagnosed
 	_ "net/http/pprof"
 
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/leaderelection"
 	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	"k8s.io/klog/v2"
 
 	"github.com/caoyingjunz/pixiu-autoscaler/cmd/app/config"
 	"github.com/caoyingjunz/pixiu-autoscaler/cmd/app/options"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/apis/pixiu/v1alpha1"
 	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller"
 	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller/autoscaler"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller/notifier"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/healthz"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/webhook"
 )
 
 const (
-	workers = 5
+	// leaderElectionTimeout 为租约过期后仍判定为健康的容忍时间
+	leaderElectionTimeout = 20 * time.Second
+	// workerTimeout 为单个 key 的最长处理时间，超过后判定 worker 卡住
+	workerTimeout = 5 * time.Minute
+	// apiServerTimeout 为检查 apiserver 可达性的超时时间
+	apiServerTimeout = 5 * time.Second
 )
 
 // NewAutoscalerCommand creates a *cobra.Command object with default parameters
@@ -54,12 +70,29 @@ func NewAutoscalerCommand() *cobra.Command {
 		Long: `The pixiu autoscaler controller is a daemon than embeds
 the core control loops shipped with advanced HPA.`,
 		Run: func(cmd *cobra.Command, args []string) {
+			if err := s.Complete(cmd.Flags()); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			if err := s.Validate(); err != nil {
+				fmt.Fprintf(os.Stderr, "%v\n", err)
+				os.Exit(1)
+			}
+			if len(s.WriteConfigTo) != 0 {
+				if err := s.WriteConfigFile(s.WriteConfigTo); err != nil {
+					fmt.Fprintf(os.Stderr, "%v\n", err)
+					os.Exit(1)
+				}
+				klog.Infof("Wrote configuration to: %s", s.WriteConfigTo)
+				return
+			}
+
 			c, err := s.Config()
 			if err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
 				os.Exit(1)
 			}
-			if err := Run(c); err != nil {
+			if err := Run(SetupSignalContext(), c); err != nil {
 				fmt.Fprintf(os.Stderr, "%v\n", err)
 				os.Exit(1)
 			}
@@ -80,57 +113,157 @@ the core control loops shipped with advanced HPA.`,
 	return cmd
 }
 
-// Run runs the pixiu-autoscaler process. This should never exit.
-func Run(c *config.PixiuConfiguration) error {
+// Run runs the pixiu-autoscaler process until ctx is cancelled, an error is
+// returned if the leadership is lost.
+func Run(ctx context.Context, c *config.PixiuConfiguration) error {
 	go func() {
-		if !c.KubezPprof.Start {
+		if !*c.KubezPprof.Start {
 			return
 		}
 		klog.Fatalf("pprof starting failed: %v", http.ListenAndServe(":"+c.KubezPprof.Port, nil))
 	}()
 
-	stopCh := make(chan struct{})
-	defer close(stopCh)
-
-	kubeConfig, err := config.BuildKubeConfig()
+	// webhook 与控制器使用相同的范围
+	scope, err := controller.NewScope(c.Scope.IncludeNamespaces, c.Scope.ExcludeNamespaces, c.Scope.NamespaceSelector, c.Scope.WorkloadSelector)
 	if err != nil {
-		return err
+		return fmt.Errorf("error new scope: %v", err)
 	}
 
+	// webhook 不依赖选主，所有副本均提供服务
+	if c.Webhook.Enable {
+		webhookServer, err := webhook.NewServer(webhook.Config{
+			BindAddress: c.Webhook.BindAddress,
+			Port:        c.Webhook.Port,
+			CertDir:     c.Webhook.CertDir,
+			CertHosts:   c.Webhook.CertHosts,
+			Namespaces:  controller.SimpleControllerClientBuilder{ClientConfig: c.Kubeconfig}.ClientOrDie("webhook").CoreV1().Namespaces(),
+			Scope:       scope,
+		})
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := webhookServer.Run(ctx.Done()); err != nil {
+				klog.Fatalf("webhook server failed: %v", err)
+			}
+		}()
+	}
+
+	// 健康检查在选主之前启动，备用副本同样提供服务
+	electionChecker := leaderelection.NewLeaderHealthzAdaptor(leaderElectionTimeout)
+	health := &controllerHealth{}
+	go StartHealthzServer(c.Healthz.HealthzHost, c.Healthz.HealthzPort,
+		[]healthz.HealthChecker{healthz.PingHealthz, electionChecker, health.workersCheck(workerTimeout)},
+		[]healthz.HealthChecker{healthz.PingHealthz, health.informerSyncCheck(), apiServerCheck(c.LeaderClient, apiServerTimeout)},
+	)
+
 	run := func(ctx context.Context) {
 		clientBuilder := controller.SimpleControllerClientBuilder{
-			ClientConfig: kubeConfig,
+			ClientConfig: c.Kubeconfig,
+		}
+		// informer 的 watch 为长连接，不受请求超时的限制
+		informerConfig := rest.CopyConfig(c.Kubeconfig)
+		informerConfig.Timeout = 0
+		informerClientBuilder := controller.SimpleControllerClientBuilder{
+			ClientConfig: informerConfig,
 		}
 
-		pixiuCtx, err := CreateControllerContext(clientBuilder, clientBuilder, ctx.Done())
+		pixiuCtx, err := CreateControllerContext(ctx, clientBuilder, informerClientBuilder, c.ResyncPeriod.Duration, scope)
 		if err != nil {
 			klog.Fatalf("create pixiu context failed: %v", err)
 		}
 
+		// 仅缓存 prometheus-adapter 的 configmap，避免 watch 集群中所有的 configmap
+		adapterInformers := informers.NewSharedInformerFactoryWithOptions(
+			informerClientBuilder.ClientOrDie("adapter-informers"),
+			c.ResyncPeriod.Duration,
+			informers.WithNamespace(c.PrometheusAdapter.Namespace),
+			informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.FieldSelector = fields.OneTermEqualSelector("metadata.name", c.PrometheusAdapter.ConfigMapName).String()
+			}),
+		)
+
+		adapter := controller.PrometheusAdapter{
+			Namespace:      c.PrometheusAdapter.Namespace,
+			ConfigMapName:  c.PrometheusAdapter.ConfigMapName,
+			ConfigMapKey:   c.PrometheusAdapter.ConfigMapKey,
+			DeploymentName: c.PrometheusAdapter.DeploymentName,
+		}
+		adapterNotifier, err := notifier.New(
+			c.PrometheusAdapter.NotifyStrategy,
+			clientBuilder.ClientOrDie("adapter-notifier"),
+			adapter,
+			c.PrometheusAdapter.NotifyWindow.Duration,
+			c.DryRun,
+		)
+		if err != nil {
+			klog.Fatalf("error new adapter notifier: %v", err)
+		}
+
+		dInformer, err := controller.DeploymentInformerFor(pixiuCtx.WorkloadInformerFactory)
+		if err != nil {
+			klog.Fatalf("error new deployment informer: %v", err)
+		}
+		sInformer, err := controller.StatefulSetInformerFor(pixiuCtx.WorkloadInformerFactory)
+		if err != nil {
+			klog.Fatalf("error new statefulset informer: %v", err)
+		}
+		hpaInformer, err := controller.HPAInformerFor(pixiuCtx.InformerFactory)
+		if err != nil {
+			klog.Fatalf("error new hpa informer: %v", err)
+		}
+		nsInformer, err := controller.NamespaceInformerFor(pixiuCtx.NamespaceInformerFactory)
+		if err != nil {
+			klog.Fatalf("error new namespace informer: %v", err)
+		}
+
 		ac, err := autoscaler.NewAutoscalerController(
-			pixiuCtx.InformerFactory.Apps().V1().Deployments(),
-			pixiuCtx.InformerFactory.Autoscaling().V2().HorizontalPodAutoscalers(),
-			pixiuCtx.InformerFactory.Core().V1().ConfigMaps(),
+			dInformer,
+			sInformer,
+			hpaInformer,
+			adapterInformers.Core().V1().ConfigMaps(),
+			nsInformer,
 			clientBuilder.ClientOrDie("shared-informers"),
+			adapter,
+			adapterNotifier,
 		)
 		if err != nil {
 			klog.Fatalf("error new autoscaler controller: %v", err)
 		}
-		go ac.Run(workers, stopCh)
-
-		pixiuCtx.InformerFactory.Start(stopCh)
-		pixiuCtx.ObjectOrMetadataInformerFactory.Start(stopCh)
+		if c.DryRun {
+			klog.Infof("Running in dry-run mode, no change will be persisted")
+			ac.EnableDryRun()
+		}
+		if err = ac.SetAdoptPolicy(c.AdoptPolicy); err != nil {
+			klog.Fatalf("error set adopt policy: %v", err)
+		}
+		if err = ac.SetForceOwnership(c.ForceOwnership); err != nil {
+			klog.Fatalf("error set force ownership: %v", err)
+		}
+		ac.SetScope(scope)
+		ac.SetDrainTimeout(c.ShutdownGracePeriod.Duration)
+		if err = addScaleTargets(pixiuCtx, ac, c.ScaleTargetResources); err != nil {
+			klog.Fatalf("error add scale targets: %v", err)
+		}
+		if err = addAutoscalingPolicy(pixiuCtx, ac); err != nil {
+			klog.Fatalf("error add autoscaling policy: %v", err)
+		}
+		health.set(ac)
 
-		// Heathz Check
-		go StartHealthzServer(c.Healthz.HealthzHost, c.Healthz.HealthzPort)
+		pixiuCtx.InformerFactory.Start(ctx.Done())
+		pixiuCtx.WorkloadInformerFactory.Start(ctx.Done())
+		pixiuCtx.NamespaceInformerFactory.Start(ctx.Done())
+		pixiuCtx.ObjectOrMetadataInformerFactory.Start(ctx.Done())
+		pixiuCtx.DynamicInformerFactory.Start(ctx.Done())
+		adapterInformers.Start(ctx.Done())
 
-		// always wait
-		select {}
+		// 阻塞至 ctx 取消且正在进行的同步排空
+		ac.Run(ctx, int(c.Workers))
 	}
 
-	if !c.LeaderElection.LeaderElect {
-		run(context.TODO())
-		panic("unreachable")
+	if !*c.LeaderElection.LeaderElect {
+		run(ctx)
+		return nil
 	}
 
 	id, err := os.Hostname()
@@ -155,21 +288,109 @@ func Run(c *config.PixiuConfiguration) error {
 		klog.Fatalf("error creating lock: %v", err)
 	}
 
-	leaderelection.RunOrDie(context.TODO(), leaderelection.LeaderElectionConfig{
-		Lock:          rl,
-		LeaseDuration: c.LeaderElection.LeaseDuration.Duration,
-		RenewDeadline: c.LeaderElection.RenewDeadline.Duration,
-		RetryPeriod:   c.LeaderElection.RetryPeriod.Duration,
+	// 选主使用独立的 context，控制器排空后才取消，取消时释放租约
+	leCtx, leCancel := context.WithCancel(context.Background())
+	defer leCancel()
+
+	started := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			// 未成为 leader 时直接退出选主
+			select {
+			case <-started:
+			default:
+				leCancel()
+			}
+		case <-leCtx.Done():
+		}
+	}()
+
+	leaderelection.RunOrDie(leCtx, leaderelection.LeaderElectionConfig{
+		Lock:            rl,
+		LeaseDuration:   c.LeaderElection.LeaseDuration.Duration,
+		RenewDeadline:   c.LeaderElection.RenewDeadline.Duration,
+		RetryPeriod:     c.LeaderElection.RetryPeriod.Duration,
+		ReleaseOnCancel: true,
 		Callbacks: leaderelection.LeaderCallbacks{
-			OnStartedLeading: run,
+			OnStartedLeading: func(leaderCtx context.Context) {
+				close(started)
+				defer leCancel()
+
+				// 收到退出信号或失去 leader 时均停止控制器
+				runCtx, cancel := context.WithCancel(leaderCtx)
+				defer cancel()
+				go func() {
+					select {
+					case <-ctx.Done():
+						cancel()
+					case <-runCtx.Done():
+					}
+				}()
+				run(runCtx)
+			},
 			OnStoppedLeading: func() {
-				klog.Fatalf("leaderelection lost")
+				klog.Infof("Stopped leading")
 			},
 		},
-		//WatchDog: electionChecker,
-		Name: "pixiu-autoscaler-controller",
+		WatchDog: electionChecker,
+		Name:     "pixiu-autoscaler-controller",
 	})
-	panic("unreachable")
 
+	if ctx.Err() == nil {
+		return fmt.Errorf("leaderelection lost")
+	}
+	return nil
+}
+
+// addScaleTargets verifies the given resources and registers them to the autoscaler controller.
+func addScaleTargets(ctx ControllerContext, ac *autoscaler.AutoscalerController, resources []string) error {
+	for _, resource := range resources {
+		gvr, gvk, err := controller.ResolveScaleTarget(ctx.DiscoveryClient, ctx.RESTMapper, resource)
+		if err != nil {
+			return err
+		}
+		informer, err := ctx.ObjectOrMetadataInformerFactory.ForResource(gvr)
+		if err != nil {
+			return err
+		}
+
+		klog.Infof("Autoscaling resource %s of kind %s", gvr.String(), gvk.String())
+		ac.AddScaleTarget(&controller.ScaleTarget{
+			Resource: gvr,
+			Kind:     gvk,
+			Informer: informer,
+		})
+	}
+
+	return nil
+}
+
+// addAutoscalingPolicy enables the AutoscalingPolicy support if the CRD is installed.
+func addAutoscalingPolicy(ctx ControllerContext, ac *autoscaler.AutoscalerController) error {
+	gvr := v1alpha1.AutoscalingPolicyResource
+	resources, err := ctx.DiscoveryClient.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		// 内存缓存的 discovery 客户端在 group version 不存在时返回 ErrCacheNotFound
+		if errors.IsNotFound(err) || err == memory.ErrCacheNotFound {
+			klog.Infof("Resource %s is not served, AutoscalingPolicy is disabled", gvr.String())
+			return nil
+		}
+		return err
+	}
+
+	for _, resource := range resources.APIResources {
+		if resource.Name == gvr.Resource {
+			klog.Infof("Autoscaling by resource %s", gvr.String())
+			informer, err := ctx.DynamicInformerFactory.ForResource(gvr)
+			if err != nil {
+				return err
+			}
+			ac.AddAutoscalingPolicy(informer, ctx.DynamicClient)
+			return nil
+		}
+	}
+
+	klog.Infof("Resource %s is not served, AutoscalingPolicy is disabled", gvr.String())
 	return nil
 }
@@ -17,69 +17,182 @@ limitations under the License.
 package config
 
 import (
-	"path/filepath"
-
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/informers"
 	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/client-go/tools/record"
-	"k8s.io/client-go/util/homedir"
-	componentbaseconfig "k8s.io/component-base/config"
+	componentbaseconfigv1alpha1 "k8s.io/component-base/config/v1alpha1"
 )
 
 const (
-	defaultConfig = ".kube/config"
+	// GroupName is the group name of the component config.
+	GroupName = "autoscaler.config.pixiu.io"
+	// Kind is the kind of the component config.
+	Kind = "PixiuConfiguration"
 )
 
+// SchemeGroupVersion is the group version of the component config.
+var SchemeGroupVersion = schema.GroupVersion{Group: GroupName, Version: "v1alpha1"}
+
 // PixiuLeaderElectionConfiguration expands LeaderElectionConfiguration
 // to include scheduler specific configuration.
 type PixiuLeaderElectionConfiguration struct {
-	componentbaseconfig.LeaderElectionConfiguration
+	componentbaseconfigv1alpha1.LeaderElectionConfiguration `json:",inline"`
 }
 
+// PixiuConfiguration is the versioned configuration of the autoscaler, it is
+// loaded from the file given by --config, and the flags override its values.
 type PixiuConfiguration struct {
-	metav1.TypeMeta
+	metav1.TypeMeta `json:",inline"`
 
-	LeaderClient    clientset.Interface
-	InformerFactory informers.SharedInformerFactory
+	// Kubeconfig is the resolved config of the clients talking to the API Server.
+	Kubeconfig *rest.Config `json:"-"`
 
-	// LeaderElection defines the configuration of leader election client.
-	LeaderElection PixiuLeaderElectionConfiguration
+	LeaderClient    clientset.Interface             `json:"-"`
+	InformerFactory informers.SharedInformerFactory `json:"-"`
 
 	// event sink
-	EventRecorder record.EventRecorder
+	EventRecorder record.EventRecorder `json:"-"`
+
+	// LeaderElection defines the configuration of leader election client.
+	LeaderElection PixiuLeaderElectionConfiguration `json:"leaderElection"`
+
+	// ClientConnection defines the configuration of the clients talking to the API Server.
+	ClientConnection ClientConnectionConfiguration `json:"clientConnection"`
+
+	// Workers is the number of workers which sync the workloads concurrently.
+	Workers int32 `json:"workers"`
+
+	// ResyncPeriod is the resync period of the informers.
+	ResyncPeriod metav1.Duration `json:"resyncPeriod"`
+
+	// ShutdownGracePeriod is the time to wait for the in-flight syncs when the
+	// controller is stopped.
+	ShutdownGracePeriod metav1.Duration `json:"shutdownGracePeriod"`
 
 	// Kubez pprof
-	KubezPprof KubezPprof
+	KubezPprof KubezPprof `json:"pprof"`
 
 	// Healthz Configuration
-	Healthz HealthzConfiguration
+	Healthz HealthzConfiguration `json:"healthz"`
+
+	// Scope limits the namespaces and workloads which are watched and reconciled.
+	Scope ScopeConfiguration `json:"scope"`
+
+	// ScaleTargetResources are the extra resources which expose the scale
+	// subresource, in the format of resource.version.group, such as
+	// rollouts.v1alpha1.argoproj.io
+	ScaleTargetResources []string `json:"scaleTargetResources,omitempty"`
+
+	// PrometheusAdapter defines the prometheus adapter which serves the custom metrics.
+	PrometheusAdapter PrometheusAdapterConfiguration `json:"prometheusAdapter"`
+
+	// Webhook defines the validating admission webhook of the hpa annotations.
+	Webhook WebhookConfiguration `json:"webhook"`
+
+	// DryRun makes the controller observe-only, the intended actions are logged,
+	// counted and recorded as events instead of being persisted.
+	DryRun bool `json:"dryRun"`
+
+	// AdoptPolicy is how to handle the unmanaged HPAs which target the annotated
+	// workloads, one of adopt, refuse and defer.
+	AdoptPolicy string `json:"adoptPolicy"`
+
+	// ForceOwnership is whether to force the server-side apply of HPAs when the
+	// fields are conflicted with the other managers, one of always, on-conflict and never.
+	ForceOwnership string `json:"forceOwnership"`
+}
+
+type ClientConnectionConfiguration struct {
+	// Kubeconfig is the path to the kubeconfig file, $KUBECONFIG and
+	// ~/.kube/config are used if not set, and then the in-cluster config
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	// Context is the kubeconfig context to use, the current context is used if not set
+	Context string `json:"context,omitempty"`
+	// QPS is the queries per second allowed to the API Server
+	QPS float32 `json:"qps"`
+	// Burst is the burst allowed to the API Server
+	Burst int32 `json:"burst"`
+	// Timeout is the timeout of the requests to the API Server, 0 means no timeout
+	Timeout metav1.Duration `json:"timeout"`
+}
+
+type ScopeConfiguration struct {
+	// IncludeNamespaces are the namespaces to watch, all namespaces are watched if empty
+	IncludeNamespaces []string `json:"includeNamespaces,omitempty"`
+	// ExcludeNamespaces are the namespaces not to watch, it takes precedence over IncludeNamespaces
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
+	// NamespaceSelector is the label selector of the namespaces to watch
+	NamespaceSelector string `json:"namespaceSelector,omitempty"`
+	// WorkloadSelector is the label selector of the workloads to watch
+	WorkloadSelector string `json:"workloadSelector,omitempty"`
+}
+
+type WebhookConfiguration struct {
+	// Enable starts the validating admission webhook server
+	Enable bool `json:"enable"`
+	// BindAddress is the IP address for the webhook server to serve on
+	BindAddress string `json:"bindAddress"`
+	// Port is the port for the webhook server to serve on
+	Port int `json:"port"`
+	// CertDir contains tls.crt and tls.key, a self-signed certificate is
+	// generated if not exist
+	CertDir string `json:"certDir"`
+	// CertHosts are the DNS names and IPs of the generated certificate
+	CertHosts []string `json:"certHosts"`
+}
+
+type PrometheusAdapterConfiguration struct {
+	// Namespace is the namespace of the prometheus adapter
+	Namespace string `json:"namespace"`
+	// ConfigMapName is the name of the configmap which holds the adapter config
+	ConfigMapName string `json:"configMapName"`
+	// ConfigMapKey is the data key of the adapter config in the configmap
+	ConfigMapKey string `json:"configMapKey"`
+	// DeploymentName is the name of the prometheus adapter deployment
+	DeploymentName string `json:"deploymentName"`
+	// NotifyStrategy is how the prometheus adapter is notified after its config
+	// changed, one of restart, debounce and none
+	NotifyStrategy string `json:"notifyStrategy"`
+	// NotifyWindow is the window within which the config changes are merged
+	// into one restart, only used by the debounce strategy
+	NotifyWindow metav1.Duration `json:"notifyWindow"`
 }
 
 type KubezPprof struct {
 	// Whether the ppof is started for main process
-	Start bool
+	Start *bool `json:"start"`
 	// The port used for pprof
-	Port string
+	Port string `json:"port"`
 }
 
 type HealthzConfiguration struct {
-	HealthzHost string
-	HealthzPort string
+	HealthzHost string `json:"host"`
+	HealthzPort string `json:"port"`
 }
 
-// Build the kubeconfig from inClusterConfig, falling back to default config if failed.
-func BuildKubeConfig() (*rest.Config, error) {
-	var config *rest.Config
-	var err error
-
-	config, err = rest.InClusterConfig()
-	if err == nil {
-		return config, nil
+// BuildKubeConfig resolves the kubeconfig with the standard clientcmd loading
+// rules, the explicit kubeconfig file, $KUBECONFIG and ~/.kube/config are used
+// in order, and the in-cluster config is used if none of them exists. The
+// master overrides the server of the kubeconfig.
+func BuildKubeConfig(master string, cc ClientConnectionConfiguration) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = cc.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{
+		ClusterInfo:    clientcmdapi.Cluster{Server: master},
+		CurrentContext: cc.Context,
 	}
 
-	//klog.Warning("error creating inClusterConfig, falling back to default config: ", err)
-	return clientcmd.BuildConfigFromFlags("", filepath.Join(homedir.HomeDir(), defaultConfig))
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	config.QPS = cc.QPS
+	config.Burst = int(cc.Burst)
+	config.Timeout = cc.Timeout.Duration
+	return config, nil
 }
@@ -0,0 +1,94 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: foo
+  cluster:
+    server: https://foo.example.com
+- name: bar
+  cluster:
+    server: https://bar.example.com
+contexts:
+- name: foo
+  context:
+    cluster: foo
+    user: admin
+- name: bar
+  context:
+    cluster: bar
+    user: admin
+current-context: foo
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+func TestBuildKubeConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := ioutil.WriteFile(file, []byte(testKubeconfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		master     string
+		context    string
+		expectHost string
+	}{
+		{"current context", "", "", "https://foo.example.com"},
+		{"explicit context", "", "bar", "https://bar.example.com"},
+		{"master overrides", "https://master.example.com", "bar", "https://master.example.com"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kubeConfig, err := BuildKubeConfig(test.master, ClientConnectionConfiguration{
+				Kubeconfig: file,
+				Context:    test.context,
+				QPS:        100,
+				Burst:      200,
+				Timeout:    metav1.Duration{Duration: time.Minute},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kubeConfig.Host != test.expectHost {
+				t.Errorf("expected host %s, got %s", test.expectHost, kubeConfig.Host)
+			}
+			if kubeConfig.QPS != 100 || kubeConfig.Burst != 200 || kubeConfig.Timeout != time.Minute {
+				t.Errorf("expected qps 100, burst 200 and timeout 1m, got %v, %v and %v", kubeConfig.QPS, kubeConfig.Burst, kubeConfig.Timeout)
+			}
+		})
+	}
+
+	if _, err := BuildKubeConfig("", ClientConnectionConfiguration{Kubeconfig: file, Context: "missing"}); err == nil {
+		t.Errorf("expected error for the missing context, got nil")
+	}
+}
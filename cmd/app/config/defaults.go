@@ -0,0 +1,155 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"time"
+
+	utilpointer "k8s.io/utils/pointer"
+
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller/notifier"
+)
+
+const (
+	LeaseDuration = 15 * time.Second
+	RenewDeadline = 10 * time.Second
+	RetryPeriod   = 2 * time.Second
+
+	ResourceLock      = "endpointsleases"
+	ResourceName      = "pixiu-autoscaler-controller"
+	ResourceNamespace = "kube-system"
+
+	QPS   = 30000
+	Burst = 30000
+
+	Workers             = 5
+	ResyncPeriod        = time.Minute
+	ShutdownGracePeriod = 20 * time.Second
+
+	HealthzHost = "127.0.0.1"
+	HealthzPort = "10256"
+	PPort       = "6060"
+
+	WebhookBindAddress = "0.0.0.0"
+	WebhookPort        = 9443
+	WebhookCertDir     = "/tmp/pixiu-autoscaler/serving-certs"
+	WebhookCertHost    = "pixiu-autoscaler-webhook.pixiu-system.svc"
+)
+
+// SetDefaults sets the default values of the unset fields.
+func SetDefaults(c *PixiuConfiguration) {
+	if len(c.APIVersion) == 0 {
+		c.APIVersion = SchemeGroupVersion.String()
+	}
+	if len(c.Kind) == 0 {
+		c.Kind = Kind
+	}
+
+	le := &c.LeaderElection
+	if le.LeaderElect == nil {
+		le.LeaderElect = utilpointer.BoolPtr(true)
+	}
+	if le.LeaseDuration.Duration == 0 {
+		le.LeaseDuration.Duration = LeaseDuration
+	}
+	if le.RenewDeadline.Duration == 0 {
+		le.RenewDeadline.Duration = RenewDeadline
+	}
+	if le.RetryPeriod.Duration == 0 {
+		le.RetryPeriod.Duration = RetryPeriod
+	}
+	if len(le.ResourceLock) == 0 {
+		le.ResourceLock = ResourceLock
+	}
+	if len(le.ResourceName) == 0 {
+		le.ResourceName = ResourceName
+	}
+	if len(le.ResourceNamespace) == 0 {
+		le.ResourceNamespace = ResourceNamespace
+	}
+
+	if c.ClientConnection.QPS == 0 {
+		c.ClientConnection.QPS = QPS
+	}
+	if c.ClientConnection.Burst == 0 {
+		c.ClientConnection.Burst = Burst
+	}
+	if c.Workers == 0 {
+		c.Workers = Workers
+	}
+	if c.ResyncPeriod.Duration == 0 {
+		c.ResyncPeriod.Duration = ResyncPeriod
+	}
+	if c.ShutdownGracePeriod.Duration == 0 {
+		c.ShutdownGracePeriod.Duration = ShutdownGracePeriod
+	}
+
+	if c.KubezPprof.Start == nil {
+		c.KubezPprof.Start = utilpointer.BoolPtr(true)
+	}
+	if len(c.KubezPprof.Port) == 0 {
+		c.KubezPprof.Port = PPort
+	}
+	if len(c.Healthz.HealthzHost) == 0 {
+		c.Healthz.HealthzHost = HealthzHost
+	}
+	if len(c.Healthz.HealthzPort) == 0 {
+		c.Healthz.HealthzPort = HealthzPort
+	}
+
+	pa := &c.PrometheusAdapter
+	if len(pa.Namespace) == 0 {
+		pa.Namespace = controller.DefaultAdapterNamespace
+	}
+	if len(pa.ConfigMapName) == 0 {
+		pa.ConfigMapName = controller.DesireConfigMapName
+	}
+	if len(pa.ConfigMapKey) == 0 {
+		pa.ConfigMapKey = controller.DefaultAdapterConfigKey
+	}
+	if len(pa.DeploymentName) == 0 {
+		pa.DeploymentName = controller.DesireConfigMapName
+	}
+	if len(pa.NotifyStrategy) == 0 {
+		pa.NotifyStrategy = notifier.Restart
+	}
+	if pa.NotifyWindow.Duration == 0 {
+		pa.NotifyWindow.Duration = notifier.DefaultDebounceWindow
+	}
+
+	wh := &c.Webhook
+	if len(wh.BindAddress) == 0 {
+		wh.BindAddress = WebhookBindAddress
+	}
+	if wh.Port == 0 {
+		wh.Port = WebhookPort
+	}
+	if len(wh.CertDir) == 0 {
+		wh.CertDir = WebhookCertDir
+	}
+	if len(wh.CertHosts) == 0 {
+		wh.CertHosts = []string{WebhookCertHost}
+	}
+
+	if len(c.AdoptPolicy) == 0 {
+		c.AdoptPolicy = controller.DefaultAdoptPolicy
+	}
+	if len(c.ForceOwnership) == 0 {
+		c.ForceOwnership = controller.DefaultForceOwnership
+	}
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"net"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	componentbaseconfig "k8s.io/component-base/config"
+	componentbaseconfigv1alpha1 "k8s.io/component-base/config/v1alpha1"
+	componentbasevalidation "k8s.io/component-base/config/validation"
+
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller/notifier"
+)
+
+// Validate validates the configuration, the defaults are expected to be set.
+func Validate(c *PixiuConfiguration) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if gvk := c.GroupVersionKind(); gvk != SchemeGroupVersion.WithKind(Kind) {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("apiVersion"), c.APIVersion+", Kind="+c.Kind, "must be "+SchemeGroupVersion.String()+", Kind="+Kind))
+	}
+
+	le := componentbaseconfig.LeaderElectionConfiguration{}
+	if err := componentbaseconfigv1alpha1.Convert_v1alpha1_LeaderElectionConfiguration_To_config_LeaderElectionConfiguration(&c.LeaderElection.LeaderElectionConfiguration, &le, nil); err != nil {
+		allErrs = append(allErrs, field.InternalError(field.NewPath("leaderElection"), err))
+	} else {
+		allErrs = append(allErrs, componentbasevalidation.ValidateLeaderElectionConfiguration(&le, field.NewPath("leaderElection"))...)
+	}
+
+	ccPath := field.NewPath("clientConnection")
+	if c.ClientConnection.QPS < 0 {
+		allErrs = append(allErrs, field.Invalid(ccPath.Child("qps"), c.ClientConnection.QPS, "must be greater than or equal to 0"))
+	}
+	if c.ClientConnection.Burst < 0 {
+		allErrs = append(allErrs, field.Invalid(ccPath.Child("burst"), c.ClientConnection.Burst, "must be greater than or equal to 0"))
+	}
+	if c.ClientConnection.Timeout.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(ccPath.Child("timeout"), c.ClientConnection.Timeout, "must be greater than or equal to 0"))
+	}
+	if c.Workers <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("workers"), c.Workers, "must be greater than 0"))
+	}
+	if c.ResyncPeriod.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("resyncPeriod"), c.ResyncPeriod, "must be greater than 0"))
+	}
+	if c.ShutdownGracePeriod.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("shutdownGracePeriod"), c.ShutdownGracePeriod, "must be greater than 0"))
+	}
+
+	allErrs = append(allErrs, validateScope(&c.Scope, field.NewPath("scope"))...)
+
+	if c.KubezPprof.Start != nil && *c.KubezPprof.Start {
+		allErrs = append(allErrs, validatePort(c.KubezPprof.Port, field.NewPath("pprof", "port"))...)
+	}
+	allErrs = append(allErrs, validatePort(c.Healthz.HealthzPort, field.NewPath("healthz", "port"))...)
+
+	paPath := field.NewPath("prometheusAdapter")
+	pa := c.PrometheusAdapter
+	for _, required := range []struct {
+		name  string
+		value string
+	}{
+		{"namespace", pa.Namespace},
+		{"configMapName", pa.ConfigMapName},
+		{"configMapKey", pa.ConfigMapKey},
+		{"deploymentName", pa.DeploymentName},
+	} {
+		if len(required.value) == 0 {
+			allErrs = append(allErrs, field.Required(paPath.Child(required.name), ""))
+		}
+	}
+	switch pa.NotifyStrategy {
+	case notifier.Restart, notifier.None:
+	case notifier.Debounce:
+		if pa.NotifyWindow.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(paPath.Child("notifyWindow"), pa.NotifyWindow, "must be greater than 0"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(paPath.Child("notifyStrategy"), pa.NotifyStrategy, []string{notifier.Restart, notifier.Debounce, notifier.None}))
+	}
+
+	if c.Webhook.Enable {
+		whPath := field.NewPath("webhook")
+		if net.ParseIP(c.Webhook.BindAddress) == nil {
+			allErrs = append(allErrs, field.Invalid(whPath.Child("bindAddress"), c.Webhook.BindAddress, "must be a valid IP address"))
+		}
+		for _, msg := range validation.IsValidPortNum(c.Webhook.Port) {
+			allErrs = append(allErrs, field.Invalid(whPath.Child("port"), c.Webhook.Port, msg))
+		}
+		if len(c.Webhook.CertDir) == 0 {
+			allErrs = append(allErrs, field.Required(whPath.Child("certDir"), ""))
+		}
+		if len(c.Webhook.CertHosts) == 0 {
+			allErrs = append(allErrs, field.Required(whPath.Child("certHosts"), ""))
+		}
+	}
+
+	if err := controller.ValidateAdoptPolicy(c.AdoptPolicy); err != nil {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("adoptPolicy"), c.AdoptPolicy, err.Error()))
+	}
+	if err := controller.ValidateForceOwnership(c.ForceOwnership); err != nil {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("forceOwnership"), c.ForceOwnership, err.Error()))
+	}
+
+	return allErrs
+}
+
+func validateScope(scope *ScopeConfiguration, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	excluded := sets.NewString(scope.ExcludeNamespaces...)
+	for i, namespace := range scope.IncludeNamespaces {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("includeNamespaces").Index(i), namespace, msg))
+		}
+		if excluded.Has(namespace) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("includeNamespaces").Index(i), namespace, "must not be excluded at the same time"))
+		}
+	}
+	for i, namespace := range scope.ExcludeNamespaces {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("excludeNamespaces").Index(i), namespace, msg))
+		}
+	}
+	if _, err := labels.Parse(scope.NamespaceSelector); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("namespaceSelector"), scope.NamespaceSelector, err.Error()))
+	}
+	if _, err := labels.Parse(scope.WorkloadSelector); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("workloadSelector"), scope.WorkloadSelector, err.Error()))
+	}
+	return allErrs
+}
+
+func validatePort(port string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return append(allErrs, field.Invalid(fldPath, port, "must be a number"))
+	}
+	for _, msg := range validation.IsValidPortNum(p) {
+		allErrs = append(allErrs, field.Invalid(fldPath, port, msg))
+	}
+	return allErrs
+}
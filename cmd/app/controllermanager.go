@@ -18,10 +18,14 @@ limitations under the License.
 package app
 
 import (
+	"context"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
 	cacheddiscovery "k8s.io/client-go/discovery/cached"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/metadata/metadatainformer"
@@ -36,11 +40,32 @@ type ControllerContext struct {
 	// ClientBuilder will provide a client for this controller to use
 	ClientBuilder controller.ControllerClientBuilder
 
-	// InformerFactory gives access to informers for the controller.
-	InformerFactory informers.SharedInformerFactory
+	// InformerFactory gives access to informers of the namespaced objects in scope.
+	InformerFactory controller.InformerFactory
 
+	// WorkloadInformerFactory gives access to informers of the workloads in scope,
+	// which are also limited by the workload selector.
+	WorkloadInformerFactory controller.InformerFactory
+
+	// NamespaceInformerFactory gives access to informers of the namespaces in scope.
+	NamespaceInformerFactory controller.InformerFactory
+
+	// ObjectOrMetadataInformerFactory gives access to informers for typed workloads
+	// and dynamic workloads by their metadata.
 	ObjectOrMetadataInformerFactory controller.InformerFactory
 
+	// DynamicClient is the dynamic client for the custom resources.
+	DynamicClient dynamic.Interface
+
+	// DynamicInformerFactory gives access to informers for the custom resources.
+	DynamicInformerFactory controller.InformerFactory
+
+	// DiscoveryClient is a cached discovery client for the apiserver.
+	DiscoveryClient discovery.CachedDiscoveryInterface
+
+	// RESTMapper is the deferred discovery based RESTMapper, it is reset periodically.
+	RESTMapper *restmapper.DeferredDiscoveryRESTMapper
+
 	// Stop is the stop channel
 	Stop <-chan struct{}
 
@@ -50,15 +75,42 @@ type ControllerContext struct {
 	ResyncPeriod func() time.Duration
 }
 
-func CreateControllerContext(rootClientBuilder, clientBuilder controller.ControllerClientBuilder, stop <-chan struct{}) (ControllerContext, error) {
+// CreateControllerContext creates the informer factories limited by the scope,
+// so that the objects out of scope are never cached. The background work of
+// the context stops when ctx is cancelled.
+func CreateControllerContext(ctx context.Context, rootClientBuilder, clientBuilder controller.ControllerClientBuilder, resyncPeriod time.Duration, scope *controller.Scope) (ControllerContext, error) {
 	versionedClient := clientBuilder.ClientOrDie("shared-informers")
-	sharedInformers := informers.NewSharedInformerFactory(versionedClient, time.Minute)
-
 	metadataClient := metadata.NewForConfigOrDie(clientBuilder.ConfigOrDie("metadata-informers"))
-	metadataInformers := metadatainformer.NewSharedInformerFactory(metadataClient, time.Minute)
+	dynamicClient := dynamic.NewForConfigOrDie(clientBuilder.ConfigOrDie("dynamic-informers"))
+
+	// 包含多个命名空间时，每个命名空间使用单独的 informer，仅需要这些命名空间的权限
+	sharedInformers := make(map[string]controller.InformerFactory)
+	workloadInformers := make(map[string]controller.InformerFactory)
+	namespaceInformers := make(map[string]controller.InformerFactory)
+	objectOrMetadataInformers := make(map[string]controller.InformerFactory)
+	dynamicInformers := make(map[string]controller.InformerFactory)
+	for _, namespace := range scope.Namespaces() {
+		s := scope.ForNamespace(namespace)
+		sharedInformers[namespace] = informers.NewSharedInformerFactoryWithOptions(versionedClient, resyncPeriod,
+			informers.WithNamespace(s.Namespace()),
+			informers.WithTweakListOptions(s.TweakListOptions),
+		)
+		workloadInformer := informers.NewSharedInformerFactoryWithOptions(versionedClient, resyncPeriod,
+			informers.WithNamespace(s.Namespace()),
+			informers.WithTweakListOptions(s.TweakWorkloadListOptions),
+		)
+		workloadInformers[namespace] = workloadInformer
+		namespaceInformers[namespace] = informers.NewSharedInformerFactoryWithOptions(versionedClient, resyncPeriod,
+			informers.WithTweakListOptions(s.TweakNamespaceListOptions),
+		)
+		metadataInformer := metadatainformer.NewFilteredSharedInformerFactory(metadataClient, resyncPeriod, s.Namespace(), s.TweakWorkloadListOptions)
+		objectOrMetadataInformers[namespace] = controller.NewInformerFactory(workloadInformer, metadataInformer)
+		dynamicInformers[namespace] = controller.NewDynamicInformerFactory(
+			dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynamicClient, resyncPeriod, s.Namespace(), s.TweakListOptions))
+	}
 
 	// If APIServer is not runnint we should wait for some time unless failed
-	if err := WaitForAPIServer(versionedClient, time.Second*8); err != nil {
+	if err := WaitForAPIServer(ctx, versionedClient, time.Second*8); err != nil {
 		return ControllerContext{}, err
 	}
 
@@ -67,13 +119,22 @@ func CreateControllerContext(rootClientBuilder, clientBuilder controller.Control
 	restMapper := restmapper.NewDeferredDiscoveryRESTMapper(cachedClient)
 	go wait.Until(func() {
 		restMapper.Reset()
-	}, 30*time.Second, stop)
+	}, 30*time.Second, ctx.Done())
 
-	ctx := ControllerContext{
+	controllerContext := ControllerContext{
 		ClientBuilder:                   clientBuilder,
-		InformerFactory:                 sharedInformers,
-		ObjectOrMetadataInformerFactory: controller.NewInformerFactory(sharedInformers, metadataInformers),
-		Stop:                            stop,
+		InformerFactory:                 controller.NewMultiNamespaceInformerFactory(sharedInformers),
+		WorkloadInformerFactory:         controller.NewMultiNamespaceInformerFactory(workloadInformers),
+		NamespaceInformerFactory:        controller.NewMultiNamespaceInformerFactory(namespaceInformers),
+		ObjectOrMetadataInformerFactory: controller.NewMultiNamespaceInformerFactory(objectOrMetadataInformers),
+		DynamicClient:                   dynamicClient,
+		DynamicInformerFactory:          controller.NewMultiNamespaceInformerFactory(dynamicInformers),
+		DiscoveryClient:                 cachedClient,
+		RESTMapper:                      restMapper,
+		Stop:                            ctx.Done(),
+		ResyncPeriod: func() time.Duration {
+			return resyncPeriod
+		},
 	}
-	return ctx, nil
+	return controllerContext, nil
 }
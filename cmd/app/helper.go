@@ -20,20 +20,28 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"k8s.io/apimachinery/pkg/util/wait"
 	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
+
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller/autoscaler"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/healthz"
 )
 
 // WaitForAPIServer waits for the API Server's /healthz endpoint to report "ok" with timeout.
-func WaitForAPIServer(client clientset.Interface, timeout time.Duration) error {
+func WaitForAPIServer(ctx context.Context, client clientset.Interface, timeout time.Duration) error {
 	var lastErr error
 
-	err := wait.PollImmediate(time.Second, timeout, func() (bool, error) {
+	err := wait.PollImmediateWithContext(ctx, time.Second, timeout, func(ctx context.Context) (bool, error) {
 		healthStatus := 0
-		result := client.Discovery().RESTClient().Get().AbsPath("/healthz").Do(context.TODO()).StatusCode(&healthStatus)
+		result := client.Discovery().RESTClient().Get().AbsPath("/healthz").Do(ctx).StatusCode(&healthStatus)
 		if result.Error() != nil {
 			lastErr = fmt.Errorf("failed to get apiserver /healthz status: %v", result.Error())
 			return false, nil
@@ -55,12 +63,97 @@ func WaitForAPIServer(client clientset.Interface, timeout time.Duration) error {
 	return nil
 }
 
-func StartHealthzServer(healthzHost string, healthzPort string) {
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
-		w.WriteHeader(200)
-		w.Write([]byte("ok"))
-	})
+// SetupSignalContext returns a context which is cancelled on SIGTERM or SIGINT,
+// the process exits directly on the second signal.
+func SetupSignalContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-c
+		klog.Infof("Received signal %v, shutting down", sig)
+		cancel()
+		<-c
+		// 第二次收到信号时不再等待
+		os.Exit(1)
+	}()
+
+	return ctx
+}
+
+// StartHealthzServer serves the health checks on /healthz, /readyz and /livez,
+// and the prometheus metrics on /metrics.
+func StartHealthzServer(healthzHost string, healthzPort string, livez []healthz.HealthChecker, readyz []healthz.HealthChecker) {
+	mux := http.NewServeMux()
+	// /healthz 聚合全部检查，readyz 的第一项同为 ping，不再重复
+	checks := append(append([]healthz.HealthChecker{}, livez...), readyz[1:]...)
+	healthz.InstallPathHandler(mux, "/healthz", checks...)
+	healthz.InstallPathHandler(mux, "/livez", livez...)
+	healthz.InstallPathHandler(mux, "/readyz", readyz...)
+	mux.Handle("/metrics", legacyregistry.Handler())
 
 	klog.Infof("Starting Healthz Server...")
-	klog.Fatal(http.ListenAndServe(healthzHost+":"+healthzPort, nil))
+	klog.Fatal(http.ListenAndServe(healthzHost+":"+healthzPort, mux))
+}
+
+// controllerHealth checks the autoscaler controller, which is created after
+// gaining the leadership.
+type controllerHealth struct {
+	ac atomic.Value
+}
+
+func (h *controllerHealth) set(ac *autoscaler.AutoscalerController) {
+	h.ac.Store(ac)
+}
+
+func (h *controllerHealth) get() *autoscaler.AutoscalerController {
+	ac, _ := h.ac.Load().(*autoscaler.AutoscalerController)
+	return ac
+}
+
+// informerSyncCheck fails until the caches of the controller are synced, it
+// always fails on the standby replicas.
+func (h *controllerHealth) informerSyncCheck() healthz.HealthChecker {
+	return healthz.NamedCheck("informer-sync", func(_ *http.Request) error {
+		ac := h.get()
+		if ac == nil {
+			return fmt.Errorf("controller is not started")
+		}
+		if !ac.HasSynced() {
+			return fmt.Errorf("caches are not synced")
+		}
+		return nil
+	})
+}
+
+// workersCheck fails if any worker of the controller has been processing one
+// item longer than timeout.
+func (h *controllerHealth) workersCheck(timeout time.Duration) healthz.HealthChecker {
+	return healthz.NamedCheck("workers", func(_ *http.Request) error {
+		ac := h.get()
+		if ac == nil {
+			return nil
+		}
+		return ac.CheckWorkers(timeout)
+	})
+}
+
+// apiServerCheck fails if the API Server is unreachable or unhealthy.
+func apiServerCheck(client clientset.Interface, timeout time.Duration) healthz.HealthChecker {
+	return healthz.NamedCheck("apiserver", func(r *http.Request) error {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		healthStatus := 0
+		result := client.Discovery().RESTClient().Get().AbsPath("/healthz").Do(ctx).StatusCode(&healthStatus)
+		if result.Error() != nil {
+			return fmt.Errorf("failed to get apiserver /healthz status: %v", result.Error())
+		}
+		if healthStatus != http.StatusOK {
+			content, _ := result.Raw()
+			return fmt.Errorf("APIServer isn't healthy: %v", string(content))
+		}
+		return nil
+	})
 }
@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/yaml"
+
+	"github.com/caoyingjunz/pixiu-autoscaler/cmd/app/config"
+)
+
+// loadConfigFromFile decodes the configuration file strictly and sets the
+// defaults of the unset fields.
+func loadConfigFromFile(file string) (*config.PixiuConfiguration, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &config.PixiuConfiguration{}
+	if err = yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to decode config file %s: %v", file, err)
+	}
+	// 仅支持当前版本的配置
+	if gvk := cfg.GroupVersionKind(); gvk != config.SchemeGroupVersion.WithKind(config.Kind) {
+		return nil, fmt.Errorf("unsupported config %s in %s, must be apiVersion: %s, kind: %s", gvk, file, config.SchemeGroupVersion, config.Kind)
+	}
+	config.SetDefaults(cfg)
+
+	return cfg, nil
+}
+
+// WriteConfigFile writes the effective configuration to the file.
+func (o *Options) WriteConfigFile(file string) error {
+	data, err := yaml.Marshal(&o.ComponentConfig)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// applyFlagOverrides sets the flags changed on the command line to the
+// configuration loaded from file.
+func applyFlagOverrides(fs *pflag.FlagSet, cfg *config.PixiuConfiguration) error {
+	overrides := pflag.NewFlagSet("overrides", pflag.ContinueOnError)
+	addConfigFlags(overrides, cfg)
+
+	var errs []error
+	fs.Visit(func(f *pflag.Flag) {
+		target := overrides.Lookup(f.Name)
+		if target == nil {
+			// 不属于配置文件的 flag，例如 --config
+			return
+		}
+		var err error
+		if sv, ok := f.Value.(pflag.SliceValue); ok {
+			err = target.Value.(pflag.SliceValue).Replace(sv.GetSlice())
+		} else {
+			err = target.Value.Set(f.Value.String())
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to override --%s: %v", f.Name, err))
+		}
+	})
+	return utilerrors.NewAggregate(errs)
+}
+
+// secondsValue is a duration flag which also accepts the number of seconds
+// without unit, such as --leader-elect-lease-duration=15.
+type secondsValue struct {
+	d *metav1.Duration
+}
+
+func newSecondsValue(d *metav1.Duration) *secondsValue {
+	return &secondsValue{d: d}
+}
+
+func (s *secondsValue) String() string {
+	return s.d.Duration.String()
+}
+
+func (s *secondsValue) Set(val string) error {
+	if seconds, err := strconv.Atoi(val); err == nil {
+		s.d.Duration = time.Duration(seconds) * time.Second
+		return nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return err
+	}
+	s.d.Duration = d
+	return nil
+}
+
+func (s *secondsValue) Type() string {
+	return "duration"
+}
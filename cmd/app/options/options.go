@@ -17,16 +17,13 @@ limitations under the License.
 package options
 
 import (
-	"time"
-
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	clientset "k8s.io/client-go/kubernetes"
 	clientgokubescheme "k8s.io/client-go/kubernetes/scheme"
 	v1core "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/record"
-	componentbaseconfig "k8s.io/component-base/config"
 	"k8s.io/klog/v2"
 
 	"github.com/caoyingjunz/pixiu-autoscaler/cmd/app/config"
@@ -45,12 +42,17 @@ type Options struct {
 	// ConfigFile is the location of the autoscaler's configuration file.
 	ConfigFile string
 
+	// WriteConfigTo is the path where the effective configuration is written to.
+	WriteConfigTo string
+
+	// Master is the address of the API Server, it overrides the server in the kubeconfig.
 	Master string
 }
 
 func NewOptions() (*Options, error) {
 
 	cfg := config.PixiuConfiguration{}
+	config.SetDefaults(&cfg)
 	o := &Options{
 		ComponentConfig: cfg,
 	}
@@ -58,82 +60,162 @@ func NewOptions() (*Options, error) {
 	return o, nil
 }
 
-var (
-	leaderElect       bool
-	leaseDuration     int
-	renewDeadline     int
-	retryPeriod       int
-	resourceLock      string
-	resourceName      string
-	resourceNamespace string
-
-	// pprof vars
-	startPprof bool
-	pprofPort  string
-
-	// log parameter
-	verbosity string
-
-	// healthz vars
-	healthzHost string
-	healthzPort string
-)
-
-const (
-	LeaseDuration = 15
-	RenewDeadline = 10
-	RetryPeriod   = 2
-
-	ResourceLock      = "endpointsleases"
-	ResourceName      = "pixiu-autoscaler-controller"
-	ResourceNamespace = "kube-system"
-
-	HealthzHost = "127.0.0.1"
-	HealthzPort = "10256"
-	PPort       = "6060"
-)
-
 // BindFlags binds the KubezConfiguration struct fields
 func (o *Options) BindFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.ConfigFile, "config", "", o.ConfigFile, ""+
+		"The path to the configuration file, the flags set on the command line override the values in this file.")
+	cmd.Flags().StringVarP(&o.WriteConfigTo, "write-config-to", "", o.WriteConfigTo, ""+
+		"If set, write the effective configuration to this file and exit.")
+	cmd.Flags().StringVarP(&o.Master, "master", "", o.Master, ""+
+		"The address of the Kubernetes API server (overrides any value in kubeconfig).")
+
+	addConfigFlags(cmd.Flags(), &o.ComponentConfig)
+}
+
+// addConfigFlags binds the flags to the fields of the configuration, the
+// defaults of the flags are the current values of the fields.
+func addConfigFlags(fs *pflag.FlagSet, cfg *config.PixiuConfiguration) {
 	// LeaderElection configuration
-	cmd.Flags().BoolVarP(&leaderElect, "leader-elect", "l", true, ""+
+	le := &cfg.LeaderElection
+	fs.BoolVarP(le.LeaderElect, "leader-elect", "l", *le.LeaderElect, ""+
 		"Start a leader election client and gain leadership before "+
 		"executing the main loop. Enable this when running replicated "+
 		"components for high availability.")
-	cmd.Flags().IntVarP(&leaseDuration, "leader-elect-lease-duration", "", LeaseDuration, ""+
+	fs.VarP(newSecondsValue(&le.LeaseDuration), "leader-elect-lease-duration", "", ""+
 		"The duration that non-leader candidates will wait after observing a leadership "+
 		"renewal until attempting to acquire leadership of a led but unrenewed leader "+
 		"slot. This is effectively the maximum duration that a leader can be stopped "+
 		"before it is replaced by another candidate. This is only applicable if leader "+
 		"election is enabled.")
-	cmd.Flags().IntVarP(&renewDeadline, "leader-elect-renew-deadline", "", RenewDeadline, ""+
+	fs.VarP(newSecondsValue(&le.RenewDeadline), "leader-elect-renew-deadline", "", ""+
 		"The interval between attempts by the acting master to renew a leadership slot "+
 		"before it stops leading. This must be less than or equal to the lease duration. "+
 		"This is only applicable if leader election is enabled.")
-	cmd.Flags().IntVarP(&retryPeriod, "leader-elect-retry-period", "", RetryPeriod, ""+
+	fs.VarP(newSecondsValue(&le.RetryPeriod), "leader-elect-retry-period", "", ""+
 		"The duration the clients should wait between attempting acquisition and renewal "+
 		"of a leadership. This is only applicable if leader election is enabled.")
-	cmd.Flags().StringVarP(&resourceLock, "leader-elect-resource-lock", "", ResourceLock, ""+
+	fs.StringVarP(&le.ResourceLock, "leader-elect-resource-lock", "", le.ResourceLock, ""+
 		"The type of resource object that is used for locking during "+
 		"leader election. Supported options are `endpoints` (default) and `configmaps`.")
-	cmd.Flags().StringVarP(&resourceName, "leader-elect-resource-name", "", ResourceName, ""+
+	fs.StringVarP(&le.ResourceName, "leader-elect-resource-name", "", le.ResourceName, ""+
 		"The name of resource object that is used for locking during "+
 		"leader election.")
-	cmd.Flags().StringVarP(&resourceNamespace, "leader-elect-resource-namespace", "", ResourceNamespace, ""+
+	fs.StringVarP(&le.ResourceNamespace, "leader-elect-resource-namespace", "", le.ResourceNamespace, ""+
 		"The namespace of resource object that is used for locking during "+
 		"leader election.")
 
-	// Log configuration
-	cmd.Flags().StringVarP(&verbosity, "verbosity", "v", "0", "number for the log level verbosity")
+	// Client connection configuration
+	cc := &cfg.ClientConnection
+	fs.StringVarP(&cc.Kubeconfig, "kubeconfig", "", cc.Kubeconfig, ""+
+		"Path to kubeconfig file with authorization and master location information. "+
+		"$KUBECONFIG and ~/.kube/config are used if not set, and then the in-cluster config.")
+	fs.StringVarP(&cc.Context, "context", "", cc.Context, "The name of the kubeconfig context to use")
+	fs.Float32VarP(&cc.QPS, "kube-api-qps", "", cc.QPS, "QPS to use while talking with kubernetes apiserver")
+	fs.Int32VarP(&cc.Burst, "kube-api-burst", "", cc.Burst, "Burst to use while talking with kubernetes apiserver")
+	fs.DurationVarP(&cc.Timeout.Duration, "kube-api-timeout", "", cc.Timeout.Duration, ""+
+		"The timeout of the requests to kubernetes apiserver, 0 means no timeout. "+
+		"The watch requests of the informers are not limited by it.")
+
+	// Controller configuration
+	fs.Int32VarP(&cfg.Workers, "workers", "", cfg.Workers, "The number of workers which sync the workloads concurrently")
+	fs.DurationVarP(&cfg.ResyncPeriod.Duration, "resync-period", "", cfg.ResyncPeriod.Duration, "The resync period of the informers")
+	fs.DurationVarP(&cfg.ShutdownGracePeriod.Duration, "shutdown-grace-period", "", cfg.ShutdownGracePeriod.Duration, ""+
+		"The time to wait for the in-flight syncs when the controller is stopped, "+
+		"the unfinished syncs are cancelled after it.")
+
+	// Scope configuration
+	scope := &cfg.Scope
+	fs.StringSliceVarP(&scope.IncludeNamespaces, "include-namespaces", "", scope.IncludeNamespaces, ""+
+		"The namespaces to watch, all namespaces are watched if empty. Each included namespace is "+
+		"watched by its own informers, only the objects in the included namespaces are cached.")
+	fs.StringSliceVarP(&scope.ExcludeNamespaces, "exclude-namespaces", "", scope.ExcludeNamespaces, ""+
+		"The namespaces not to watch, it takes precedence over --include-namespaces.")
+	fs.StringVarP(&scope.NamespaceSelector, "namespace-selector", "", scope.NamespaceSelector, ""+
+		"The label selector of the namespaces to watch, for example tenant=team-a.")
+	fs.StringVarP(&scope.WorkloadSelector, "workload-selector", "", scope.WorkloadSelector, ""+
+		"The label selector of the workloads to watch, the workloads not selected are not cached.")
 
 	// ppof configuration
-	cmd.Flags().BoolVarP(&startPprof, "start-pprof", "", true, ""+
+	fs.BoolVarP(cfg.KubezPprof.Start, "start-pprof", "", *cfg.KubezPprof.Start, ""+
 		"Start pprof and gain leadership before executing the main loop")
-	cmd.Flags().StringVarP(&pprofPort, "pprof-port", "", PPort, "The port of pprof to listen on")
+	fs.StringVarP(&cfg.KubezPprof.Port, "pprof-port", "", cfg.KubezPprof.Port, "The port of pprof to listen on")
 
 	// Healthz configuration
-	cmd.Flags().StringVarP(&healthzHost, "healthz-host", "", HealthzHost, "The host of Healthz")
-	cmd.Flags().StringVarP(&healthzPort, "healthz-port", "", HealthzPort, "The port of Healthz to listen on")
+	fs.StringVarP(&cfg.Healthz.HealthzHost, "healthz-host", "", cfg.Healthz.HealthzHost, "The host of Healthz")
+	fs.StringVarP(&cfg.Healthz.HealthzPort, "healthz-port", "", cfg.Healthz.HealthzPort, "The port of Healthz to listen on")
+
+	// Scale target configuration
+	fs.StringSliceVarP(&cfg.ScaleTargetResources, "scale-target-resources", "", cfg.ScaleTargetResources, ""+
+		"The extra resources which expose the scale subresource and are autoscaled by annotations, "+
+		"in the format of resource.version.group, for example rollouts.v1alpha1.argoproj.io")
+
+	// Prometheus adapter configuration
+	pa := &cfg.PrometheusAdapter
+	fs.StringVarP(&pa.Namespace, "adapter-namespace", "", pa.Namespace, "The namespace of the prometheus adapter")
+	fs.StringVarP(&pa.ConfigMapName, "adapter-configmap-name", "", pa.ConfigMapName, "The name of the configmap which holds the prometheus adapter config")
+	fs.StringVarP(&pa.ConfigMapKey, "adapter-configmap-key", "", pa.ConfigMapKey, "The data key of the prometheus adapter config in the configmap")
+	fs.StringVarP(&pa.DeploymentName, "adapter-deployment-name", "", pa.DeploymentName, "The name of the prometheus adapter deployment")
+	fs.StringVarP(&pa.NotifyStrategy, "adapter-notify-strategy", "", pa.NotifyStrategy, ""+
+		"How to notify the prometheus adapter after its config changed. Supported options are "+
+		"`restart` (restart the adapter at once), `debounce` (merge the changes within "+
+		"--adapter-notify-window into one restart) and `none` (the adapter reloads its config by itself).")
+	fs.DurationVarP(&pa.NotifyWindow.Duration, "adapter-notify-window", "", pa.NotifyWindow.Duration, ""+
+		"The window within which the adapter config changes are merged into one restart. "+
+		"This is only applicable if the notify strategy is debounce.")
+
+	// Webhook configuration
+	wh := &cfg.Webhook
+	fs.BoolVarP(&wh.Enable, "webhook-enable", "", wh.Enable, ""+
+		"Start the validating admission webhook server which rejects the workloads with invalid hpa annotations.")
+	fs.StringVarP(&wh.BindAddress, "webhook-bind-address", "", wh.BindAddress, "The IP address for the webhook server to serve on")
+	fs.IntVarP(&wh.Port, "webhook-port", "", wh.Port, "The port for the webhook server to serve on")
+	fs.StringVarP(&wh.CertDir, "webhook-cert-dir", "", wh.CertDir, ""+
+		"The directory which contains tls.crt and tls.key of the webhook server. "+
+		"A self-signed certificate is generated into it if not exist.")
+	fs.StringSliceVarP(&wh.CertHosts, "webhook-cert-hosts", "", wh.CertHosts, ""+
+		"The DNS names and IPs of the generated self-signed certificate, the first one is used as the common name.")
+
+	// Dry run configuration
+	fs.BoolVarP(&cfg.DryRun, "dry-run", "", cfg.DryRun, ""+
+		"Run the controller in observe-only mode. The intended create, update, delete and patch actions "+
+		"are validated by the apiserver with server-side dry-run, then logged and recorded as metrics "+
+		"and events instead of being persisted.")
+
+	// Adopt policy configuration
+	fs.StringVarP(&cfg.AdoptPolicy, "adopt-policy", "", cfg.AdoptPolicy, ""+
+		"How to handle the existing HPAs which target an annotated workload but are not controlled by it. "+
+		"Supported options are `adopt` (add the owner reference and reconcile the HPA with the annotations), "+
+		"`refuse` (record a Warning event, leave the existing HPA unchanged and remove the HPA created by the "+
+		"controller) and `defer` (leave the workload to the existing HPA and remove the HPA created by the controller).")
+
+	// Server-side apply configuration
+	fs.StringVarP(&cfg.ForceOwnership, "force-ownership", "", cfg.ForceOwnership, ""+
+		"Whether to take over the conflicting fields when the HPAs are applied with server-side apply and "+
+		"the fields are modified by other managers. Supported options are `always` (force silently), "+
+		"`on-conflict` (record a Warning event and then force) and `never` (record a Warning event and retry later).")
+}
+
+// Complete loads the configuration file if given, the flags set on the command
+// line take precedence over the values in the file.
+func (o *Options) Complete(fs *pflag.FlagSet) error {
+	if len(o.ConfigFile) == 0 {
+		return nil
+	}
+
+	cfg, err := loadConfigFromFile(o.ConfigFile)
+	if err != nil {
+		return err
+	}
+	if err = applyFlagOverrides(fs, cfg); err != nil {
+		return err
+	}
+	o.ComponentConfig = *cfg
+	return nil
+}
+
+// Validate validates the completed configuration.
+func (o *Options) Validate() error {
+	return config.Validate(&o.ComponentConfig).ToAggregate()
 }
 
 func createRecorder(kubeClient clientset.Interface, userAgent string) record.EventRecorder {
@@ -145,12 +227,10 @@ func createRecorder(kubeClient clientset.Interface, userAgent string) record.Eve
 
 // Config return a kubez controller manager config objective
 func (o *Options) Config() (*config.PixiuConfiguration, error) {
-	kubeConfig, err := config.BuildKubeConfig()
+	kubeConfig, err := config.BuildKubeConfig(o.Master, o.ComponentConfig.ClientConnection)
 	if err != nil {
 		return nil, err
 	}
-	kubeConfig.QPS = 30000
-	kubeConfig.Burst = 30000
 
 	clientBuilder := controller.SimpleControllerClientBuilder{
 		ClientConfig: kubeConfig,
@@ -159,31 +239,9 @@ func (o *Options) Config() (*config.PixiuConfiguration, error) {
 	client := clientBuilder.ClientOrDie("leader-client")
 	eventRecorder := createRecorder(client, PixiuControllerManagerUserAgent)
 
-	le := config.PixiuLeaderElectionConfiguration{
-		componentbaseconfig.LeaderElectionConfiguration{
-			LeaderElect:       leaderElect,
-			LeaseDuration:     metav1.Duration{time.Duration(leaseDuration) * time.Second},
-			RenewDeadline:     metav1.Duration{time.Duration(renewDeadline) * time.Second},
-			RetryPeriod:       metav1.Duration{time.Duration(retryPeriod) * time.Second},
-			ResourceLock:      resourceLock,
-			ResourceName:      resourceName,
-			ResourceNamespace: resourceNamespace,
-		},
-	}
-
-	pp := config.KubezPprof{
-		Start: startPprof,
-		Port:  pprofPort,
-	}
-
-	return &config.PixiuConfiguration{
-		LeaderClient:   client,
-		EventRecorder:  eventRecorder,
-		LeaderElection: le,
-		KubezPprof:     pp,
-		Healthz: config.HealthzConfiguration{
-			HealthzHost: healthzHost,
-			HealthzPort: healthzPort,
-		},
-	}, nil
+	c := o.ComponentConfig
+	c.Kubeconfig = kubeConfig
+	c.LeaderClient = client
+	c.EventRecorder = eventRecorder
+	return &c, nil
 }
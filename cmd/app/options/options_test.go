@@ -0,0 +1,112 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package options
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func TestCompleteWithConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(file, []byte(`apiVersion: autoscaler.config.pixiu.io/v1alpha1
+kind: PixiuConfiguration
+workers: 10
+leaderElection:
+  leaseDuration: 30s
+adoptPolicy: adopt
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	o, _ := NewOptions()
+	cmd := &cobra.Command{}
+	o.BindFlags(cmd)
+	if err := cmd.Flags().Parse([]string{"--config", file, "--workers=3", "--leader-elect-renew-deadline=12", "--webhook-cert-hosts=a,b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Complete(cmd.Flags()); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	cfg := o.ComponentConfig
+	// flag 优先于配置文件
+	if cfg.Workers != 3 {
+		t.Errorf("expected workers 3, got %d", cfg.Workers)
+	}
+	if cfg.LeaderElection.RenewDeadline.Duration != 12*time.Second {
+		t.Errorf("expected renew deadline 12s, got %v", cfg.LeaderElection.RenewDeadline.Duration)
+	}
+	if len(cfg.Webhook.CertHosts) != 2 || cfg.Webhook.CertHosts[1] != "b" {
+		t.Errorf("expected cert hosts [a b], got %v", cfg.Webhook.CertHosts)
+	}
+	// 未通过 flag 设置的字段取配置文件中的值
+	if cfg.LeaderElection.LeaseDuration.Duration != 30*time.Second {
+		t.Errorf("expected lease duration 30s, got %v", cfg.LeaderElection.LeaseDuration.Duration)
+	}
+	if cfg.AdoptPolicy != "adopt" {
+		t.Errorf("expected adopt policy adopt, got %s", cfg.AdoptPolicy)
+	}
+	// 其余字段取默认值
+	if cfg.ClientConnection.QPS != 30000 || !*cfg.LeaderElection.LeaderElect {
+		t.Errorf("expected the defaults to be set, got %+v", cfg)
+	}
+
+	// 写出的配置可以被重新加载
+	out := filepath.Join(dir, "out.yaml")
+	if err := o.WriteConfigFile(out); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadConfigFromFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Workers != 3 || loaded.LeaderElection.LeaseDuration.Duration != 30*time.Second {
+		t.Errorf("unexpected config loaded from the written file: %+v", loaded)
+	}
+}
+
+func TestLoadConfigFromFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"unsupported kind", "apiVersion: autoscaler.config.pixiu.io/v1alpha1\nkind: Foo\n"},
+		{"unsupported version", "apiVersion: autoscaler.config.pixiu.io/v1\nkind: PixiuConfiguration\n"},
+		{"unknown field", "apiVersion: autoscaler.config.pixiu.io/v1alpha1\nkind: PixiuConfiguration\nwrkers: 1\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "config.yaml")
+			if err := ioutil.WriteFile(file, []byte(test.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := loadConfigFromFile(file); err == nil {
+				t.Errorf("expected error, got nil")
+			}
+		})
+	}
+}
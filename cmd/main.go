@@ -21,6 +21,8 @@ import (
 	"math/rand"
 	"os"
 	"time"
+	// 内置时区数据，镜像中可能不包含 zoneinfo，定时副本数依赖时区
+	_ "time/tzdata"
 
 	"k8s.io/klog/v2"
 
@@ -31,9 +33,10 @@ func main() {
 	rand.Seed(time.Now().UnixNano())
 
 	klog.InitFlags(nil)
-	flag.Parse()
 
 	command := app.NewAutoscalerCommand()
+	// klog 的 flag 与命令的 flag 一起由 cobra 解析
+	command.Flags().AddGoFlagSet(flag.CommandLine)
 
 	if err := command.Execute(); err != nil {
 		os.Exit(1)
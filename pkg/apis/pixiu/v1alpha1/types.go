@@ -0,0 +1,102 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// AutoscalingPolicy describes the HPA of a workload in a typed way, it is an
+// alternative to the hpa.caoyingjunz.io annotations and takes precedence over
+// them when both exist.
+type AutoscalingPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// Spec is the desired HPA of the target workload.
+	Spec AutoscalingPolicySpec `json:"spec"`
+
+	// Status is the most recently observed status of the policy.
+	// +optional
+	Status AutoscalingPolicyStatus `json:"status,omitempty"`
+}
+
+// AutoscalingPolicySpec is the specification of an AutoscalingPolicy.
+type AutoscalingPolicySpec struct {
+	// TargetRef points to the workload to scale, it must be in the same
+	// namespace as the policy.
+	TargetRef autoscalingv2.CrossVersionObjectReference `json:"targetRef"`
+
+	// MinReplicas is the lower limit for the number of replicas, defaults to 1.
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+
+	// MaxReplicas is the upper limit for the number of replicas.
+	MaxReplicas int32 `json:"maxReplicas"`
+
+	// Metrics contains the specifications used to calculate the desired
+	// replica count, it is the same as the metrics of HPA.
+	Metrics []autoscalingv2.MetricSpec `json:"metrics"`
+
+	// Behavior configures the scaling behavior in both up and down directions.
+	// +optional
+	Behavior *autoscalingv2.HorizontalPodAutoscalerBehavior `json:"behavior,omitempty"`
+}
+
+// AutoscalingPolicyStatus is the status of an AutoscalingPolicy.
+type AutoscalingPolicyStatus struct {
+	// ObservedGeneration is the most recent generation observed by the controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// HPAName is the name of the HPA created for the policy.
+	// +optional
+	HPAName string `json:"hpaName,omitempty"`
+
+	// Conditions are the latest observations of the policy's state.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+const (
+	// PolicyReady means the HPA of the target workload is in sync with the policy.
+	PolicyReady string = "Ready"
+)
+
+// The reasons of the PolicyReady condition.
+const (
+	ReasonHPASynced       string = "HPASynced"
+	ReasonTargetNotFound  string = "TargetNotFound"
+	ReasonInvalidPolicy   string = "InvalidPolicy"
+	ReasonFailedSyncHPA   string = "FailedSyncHPA"
+	ReasonPolicyConflicts string = "PolicyConflicts"
+	ReasonUnmanagedHPA    string = "UnmanagedHPA"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// AutoscalingPolicyList is a list of AutoscalingPolicy.
+type AutoscalingPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []AutoscalingPolicy `json:"items"`
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	RulesKey         string = "rules"
+	ExternalRulesKey string = "externalRules"
+
+	// OwnedRulesAnnotation 记录由控制器生成的规则，格式为 {"rules": [hash], "externalRules": [hash]}
+	// 不在其中的规则视为手动维护的规则，控制器不会修改
+	OwnedRulesAnnotation = PixiuRootPrefix + PixiuSeparator + "ownedRules"
+)
+
+// OwnedRules records the hashes of the rules generated by controller, keyed
+// by the top-level key of the adapter config.
+type OwnedRules map[string][]string
+
+// ParseOwnedRules parses the owned rules from the configmap annotations.
+func ParseOwnedRules(annotations map[string]string) (OwnedRules, bool, error) {
+	data, ok := annotations[OwnedRulesAnnotation]
+	if !ok {
+		return OwnedRules{}, false, nil
+	}
+
+	owned := OwnedRules{}
+	if err := json.Unmarshal([]byte(data), &owned); err != nil {
+		return nil, false, fmt.Errorf("failed to unmarshal %s: %v", OwnedRulesAnnotation, err)
+	}
+	return owned, true, nil
+}
+
+// String returns the annotation value of the owned rules.
+func (o OwnedRules) String() string {
+	data, _ := json.Marshal(o)
+	return string(data)
+}
+
+// AdapterConfig is the yaml document of the prometheus adapter config. Only
+// the rules owned by controller are changed, the other rules, the unknown
+// top-level keys and their ordering are preserved.
+type AdapterConfig struct {
+	document *yaml.Node
+}
+
+// ParseAdapterConfig parses the prometheus adapter config.
+func ParseAdapterConfig(data string) (*AdapterConfig, error) {
+	document := &yaml.Node{}
+	if err := yaml.Unmarshal([]byte(data), document); err != nil {
+		return nil, err
+	}
+	if document.Kind == 0 {
+		// 空配置
+		document = &yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("the adapter config should be a yaml mapping")
+	}
+
+	return &AdapterConfig{document: document}, nil
+}
+
+// String encodes the adapter config.
+func (c *AdapterConfig) String() (string, error) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(c.document); err != nil {
+		return "", err
+	}
+	if err := encoder.Close(); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// SetRules replaces the rules owned by controller under the given top-level key
+// with the desired rules, and returns the hashes of the new owned rules. The
+// desired rules take the place of the first owned rule, or are appended to the
+// end if there is none; the identical foreign rules are left to their owners.
+func (c *AdapterConfig) SetRules(key string, owned []string, desired interface{}) ([]string, error) {
+	desiredNode := &yaml.Node{}
+	if err := desiredNode.Encode(desired); err != nil {
+		return nil, err
+	}
+	if desiredNode.Kind != yaml.SequenceNode {
+		// desired 为空时编码为 null
+		desiredNode = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	}
+
+	ownedSet := make(map[string]Empty)
+	for _, hash := range owned {
+		ownedSet[hash] = Empty{}
+	}
+
+	mapping := c.document.Content[0]
+	sequence := lookupMappingValue(mapping, key)
+	if sequence == nil {
+		if len(desiredNode.Content) == 0 {
+			return nil, nil
+		}
+		sequence = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, sequence)
+	}
+	if sequence.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("%s of the adapter config should be a sequence", key)
+	}
+
+	var (
+		content  []*yaml.Node
+		foreign  = make(map[string]Empty)
+		position = -1
+	)
+	for _, item := range sequence.Content {
+		hash, err := hashRuleNode(item)
+		if err != nil {
+			return nil, err
+		}
+		if _, found := ownedSet[hash]; found {
+			if position < 0 {
+				position = len(content)
+			}
+			continue
+		}
+		foreign[hash] = Empty{}
+		content = append(content, item)
+	}
+	if position < 0 {
+		position = len(content)
+	}
+
+	var (
+		added    []*yaml.Node
+		newOwned []string
+	)
+	for _, item := range desiredNode.Content {
+		hash, err := hashRuleNode(item)
+		if err != nil {
+			return nil, err
+		}
+		if _, found := foreign[hash]; found {
+			continue
+		}
+		added = append(added, item)
+		newOwned = append(newOwned, hash)
+	}
+
+	sequence.Content = append(content[:position:position], append(added, content[position:]...)...)
+	sequence.Style = 0
+	return newOwned, nil
+}
+
+// LegacyOwnedExternalRules returns the hashes of the external rules generated by the
+// older versions of controller, which owned the externalRules wholesale and kept no
+// ownership annotation.
+func (c *AdapterConfig) LegacyOwnedExternalRules() ([]string, error) {
+	sequence := lookupMappingValue(c.document.Content[0], ExternalRulesKey)
+	if sequence == nil || sequence.Kind != yaml.SequenceNode {
+		return nil, nil
+	}
+
+	var owned []string
+	for _, item := range sequence.Content {
+		var rule ExternalRule
+		if err := item.Decode(&rule); err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(rule, NewExternalRule(rule.SeriesQuery)) {
+			continue
+		}
+		hash, err := hashRuleNode(item)
+		if err != nil {
+			return nil, err
+		}
+		owned = append(owned, hash)
+	}
+	return owned, nil
+}
+
+func lookupMappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// hashRuleNode computes the hash of a rule by its canonical json, so that the
+// formatting and ordering of the yaml fields are ignored.
+func hashRuleNode(node *yaml.Node) (string, error) {
+	var rule interface{}
+	if err := node.Decode(&rule); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(convertYAMLMap(rule))
+	if err != nil {
+		return "", err
+	}
+	return computeHash(string(data)), nil
+}
+
+// convertYAMLMap converts the map[interface{}]interface{} decoded by yaml to
+// map[string]interface{} which could be encoded by json.
+func convertYAMLMap(in interface{}) interface{} {
+	switch v := in.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			out[key] = convertYAMLMap(value)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			out[fmt.Sprint(key)] = convertYAMLMap(value)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = convertYAMLMap(value)
+		}
+		return out
+	}
+	return in
+}
@@ -0,0 +1,280 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+)
+
+// 手动维护的规则
+const (
+	foreignRule = `  - seriesQuery: 'container_memory_usage_bytes{namespace!="",pod!=""}'
+    resources:
+      overrides:
+        namespace:
+          resource: namespace
+        pod:
+          resource: pod
+    metricsQuery: sum(<<.Series>>{<<.LabelMatchers>>}) by (<<.GroupBy>>)
+`
+	foreignExternalRule = `  - seriesQuery: 'queue_messages_ready{namespace!=""}'
+    name:
+      matches: ^(.*)_ready$
+      as: ${1}
+    resources:
+      overrides:
+        namespace:
+          resource: namespace
+    metricsQuery: sum(<<.Series>>{<<.LabelMatchers>>})
+`
+	resourceRules = `resourceRules:
+  cpu:
+    containerQuery: sum(rate(container_cpu_usage_seconds_total{<<.LabelMatchers>>}[3m])) by (<<.GroupBy>>)
+    resources:
+      overrides:
+        namespace:
+          resource: namespace
+    containerLabel: container
+  window: 3m
+`
+)
+
+// podsRuleYAML 为控制器生成的 pods 规则
+const podsRuleYAML = `  - metricsQuery: sum(<<.Series>>{<<.LabelMatchers>>}) by (<<.GroupBy>>)
+    name:
+      as: ""
+      matches: ""
+    resources:
+      overrides:
+        namespace:
+          resource: namespace
+        pod:
+          resource: pod
+    seriesQuery: http_requests_per_second{namespace!="",pod!=""}
+`
+
+func newPodsMetricSource(name string) *autoscalingv2.PodsMetricSource {
+	return &autoscalingv2.PodsMetricSource{Metric: autoscalingv2.MetricIdentifier{Name: name}}
+}
+
+// externalRuleYAML returns the yaml of the external rule generated by controller.
+func externalRuleYAML(seriesQuery string) string {
+	return `  - metricsQuery: <<.Series>>
+    name:
+      as: ""
+      matches: ""
+    resources:
+      overrides:
+        namespace:
+          resource: namespace
+    seriesQuery: ` + seriesQuery + "\n"
+}
+
+// ruleHash returns the hash of the rule recorded in the owned rules annotation.
+func ruleHash(t *testing.T, rule interface{}) string {
+	node := &yaml.Node{}
+	if err := node.Encode(rule); err != nil {
+		t.Fatalf("failed to encode rule: %v", err)
+	}
+	hash, err := hashRuleNode(node)
+	if err != nil {
+		t.Fatalf("failed to hash rule: %v", err)
+	}
+	return hash
+}
+
+func TestAdapterConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		annotations map[string]string
+		// owned 为 annotations 中记录的规则，未设置时使用 annotations 的原值
+		owned         OwnedRules
+		rules         []Rule
+		externalRules []ExternalRule
+		expected      string
+		expectedOwned OwnedRules
+		expectErr     bool
+	}{
+		{
+			name:          "foreign rules and unknown keys are preserved",
+			data:          "rules:\n" + foreignRule + "externalRules:\n" + foreignExternalRule + resourceRules,
+			owned:         OwnedRules{},
+			expected:      "rules:\n" + foreignRule + "externalRules:\n" + foreignExternalRule + resourceRules,
+			expectedOwned: OwnedRules{},
+		},
+		{
+			name:          "owned rules are replaced in place",
+			data:          "rules:\n" + foreignRule + "externalRules:\n" + foreignExternalRule + externalRuleYAML("rps") + foreignExternalRule + resourceRules,
+			owned:         OwnedRules{ExternalRulesKey: {"rps"}},
+			externalRules: []ExternalRule{NewExternalRule("qps"), NewExternalRule("tps")},
+			expected:      "rules:\n" + foreignRule + "externalRules:\n" + foreignExternalRule + externalRuleYAML("qps") + externalRuleYAML("tps") + foreignExternalRule + resourceRules,
+			expectedOwned: OwnedRules{ExternalRulesKey: {"qps", "tps"}},
+		},
+		{
+			name: "owned rules are recognized regardless of formatting",
+			data: "externalRules:\n" + foreignExternalRule +
+				"  - {seriesQuery: rps, metricsQuery: '<<.Series>>', resources: {overrides: {namespace: {resource: namespace}}}, name: {matches: '', as: ''}}\n",
+			owned:         OwnedRules{ExternalRulesKey: {"rps"}},
+			externalRules: []ExternalRule{NewExternalRule("qps")},
+			expected:      "externalRules:\n" + foreignExternalRule + externalRuleYAML("qps"),
+			expectedOwned: OwnedRules{ExternalRulesKey: {"qps"}},
+		},
+		{
+			name:          "owned rules are appended without previous ones",
+			data:          "rules:\n" + foreignRule,
+			owned:         OwnedRules{},
+			rules:         []Rule{NewPodsRule(newPodsMetricSource("http_requests_per_second"))},
+			expected:      "rules:\n" + foreignRule + podsRuleYAML,
+			expectedOwned: OwnedRules{RulesKey: {"pods"}},
+		},
+		{
+			name:          "rules identical to foreign ones are left to their owners",
+			data:          "externalRules:\n" + externalRuleYAML("rps"),
+			owned:         OwnedRules{},
+			externalRules: []ExternalRule{NewExternalRule("rps")},
+			expected:      "externalRules:\n" + externalRuleYAML("rps"),
+			expectedOwned: OwnedRules{},
+		},
+		{
+			name:          "owned rules are removed",
+			data:          "externalRules:\n" + externalRuleYAML("rps") + foreignExternalRule,
+			owned:         OwnedRules{ExternalRulesKey: {"rps"}},
+			expected:      "externalRules:\n" + foreignExternalRule,
+			expectedOwned: OwnedRules{},
+		},
+		{
+			name:          "missing annotation claims legacy rules",
+			data:          "rules:\n" + podsRuleYAML + "externalRules:\n" + externalRuleYAML("rps") + foreignExternalRule,
+			externalRules: []ExternalRule{NewExternalRule("qps")},
+			// 旧版本的控制器仅生成 externalRules，rules 中的规则均视为手动维护
+			expected:      "rules:\n" + podsRuleYAML + "externalRules:\n" + externalRuleYAML("qps") + foreignExternalRule,
+			expectedOwned: OwnedRules{ExternalRulesKey: {"qps"}},
+		},
+		{
+			name:        "corrupt annotation",
+			data:        "externalRules:\n" + externalRuleYAML("rps"),
+			annotations: map[string]string{OwnedRulesAnnotation: `{"externalRules": "rps"`},
+			expectErr:   true,
+		},
+		{
+			name:          "empty data",
+			data:          "",
+			externalRules: []ExternalRule{NewExternalRule("rps")},
+			expected:      "externalRules:\n" + externalRuleYAML("rps"),
+			expectedOwned: OwnedRules{ExternalRulesKey: {"rps"}},
+		},
+		{
+			name:          "empty data without rules",
+			data:          "",
+			expected:      "{}\n",
+			expectedOwned: OwnedRules{},
+		},
+		{
+			name:      "rules is not a sequence",
+			data:      "rules: {}\n",
+			owned:     OwnedRules{},
+			rules:     []Rule{NewPodsRule(newPodsMetricSource("http_requests_per_second"))},
+			expectErr: true,
+		},
+		{
+			name:      "config is not a mapping",
+			data:      "- rules\n",
+			expectErr: true,
+		},
+	}
+
+	// 测试用例中以规则的 seriesQuery 或 pods 指代其 hash
+	hashes := map[string]string{
+		"rps":  ruleHash(t, NewExternalRule("rps")),
+		"qps":  ruleHash(t, NewExternalRule("qps")),
+		"tps":  ruleHash(t, NewExternalRule("tps")),
+		"pods": ruleHash(t, NewPodsRule(newPodsMetricSource("http_requests_per_second"))),
+	}
+	toHashes := func(owned OwnedRules) OwnedRules {
+		if owned == nil {
+			return nil
+		}
+		out := OwnedRules{}
+		for key, names := range owned {
+			for _, name := range names {
+				out[key] = append(out[key], hashes[name])
+			}
+		}
+		return out
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			annotations := test.annotations
+			if test.owned != nil {
+				annotations = map[string]string{OwnedRulesAnnotation: toHashes(test.owned).String()}
+			}
+
+			// 与 syncConfigMaps 的处理流程一致
+			err := func() error {
+				adapterConfig, err := ParseAdapterConfig(test.data)
+				if err != nil {
+					return err
+				}
+				owned, found, err := ParseOwnedRules(annotations)
+				if err != nil {
+					return err
+				}
+				if !found {
+					if owned[ExternalRulesKey], err = adapterConfig.LegacyOwnedExternalRules(); err != nil {
+						return err
+					}
+				}
+
+				newOwned := OwnedRules{}
+				for _, key := range []string{RulesKey, ExternalRulesKey} {
+					var desired interface{} = test.externalRules
+					if key == RulesKey {
+						desired = UniqueRules(test.rules)
+					}
+					ownedRules, err := adapterConfig.SetRules(key, owned[key], desired)
+					if err != nil {
+						return err
+					}
+					if len(ownedRules) != 0 {
+						newOwned[key] = ownedRules
+					}
+				}
+				data, err := adapterConfig.String()
+				if err != nil {
+					return err
+				}
+
+				if data != test.expected {
+					t.Errorf("expected adapter config:\n%s\ngot:\n%s", test.expected, data)
+				}
+				if expectedOwned := toHashes(test.expectedOwned); !reflect.DeepEqual(newOwned, expectedOwned) {
+					t.Errorf("expected owned rules %v, got %v", expectedOwned, newOwned)
+				}
+				return nil
+			}()
+			if (err != nil) != test.expectErr {
+				t.Errorf("expected error %v, got %v", test.expectErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const (
+	// AdoptPolicyAdopt adopts the unmanaged HPA by adding the owner reference
+	// of the workload, its spec is reconciled with the annotations afterwards.
+	AdoptPolicyAdopt string = "adopt"
+	// AdoptPolicyRefuse leaves the unmanaged HPA as it is and records a Warning
+	// event, the HPA created by controller for the workload is removed and no HPA
+	// is created until the conflict is resolved.
+	AdoptPolicyRefuse string = "refuse"
+	// AdoptPolicyDefer leaves the workload to the unmanaged HPA, the HPA created
+	// by controller for the workload is removed.
+	AdoptPolicyDefer string = "defer"
+
+	// DefaultAdoptPolicy is the default policy for the unmanaged HPAs.
+	DefaultAdoptPolicy = AdoptPolicyRefuse
+)
+
+// ValidateAdoptPolicy returns an error if the adopt policy is unsupported.
+func ValidateAdoptPolicy(policy string) error {
+	switch policy {
+	case AdoptPolicyAdopt, AdoptPolicyRefuse, AdoptPolicyDefer:
+		return nil
+	}
+	return fmt.Errorf("unsupported adopt policy %q, must be one of %s, %s and %s", policy, AdoptPolicyAdopt, AdoptPolicyRefuse, AdoptPolicyDefer)
+}
+
+// IsHPATargetWorkload returns true if the scale target of the HPA is the workload.
+func IsHPATargetWorkload(hpa *autoscalingv2.HorizontalPodAutoscaler, w *Workload) bool {
+	ref := hpa.Spec.ScaleTargetRef
+	if ref.Name != w.Name {
+		return false
+	}
+	// 仅比较 group 和 kind，HPA 可以通过任意版本引用工作负载
+	return HPATargetGroupKind(hpa) == w.GroupKind()
+}
+
+// HPATargetGroupKind returns the group kind of the HPA's scale target.
+func HPATargetGroupKind(hpa *autoscalingv2.HorizontalPodAutoscaler) schema.GroupKind {
+	ref := hpa.Spec.ScaleTargetRef
+	return schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind).GroupKind()
+}
+
+// IsAdoptable returns true if the HPA could be adopted by the workload, that is
+// it is not controlled by any other owner.
+func IsAdoptable(hpa *autoscalingv2.HorizontalPodAutoscaler) bool {
+	return metav1.GetControllerOf(hpa) == nil && hpa.DeletionTimestamp == nil
+}
+
+// IsOrphanedHPA returns true if the adoptable HPA belongs to the workload
+// already, that is it is owned by the workload but not as the controller, or
+// it was written by controller and lost its owner reference. The orphaned HPA
+// is always adopted whatever the adopt policy is.
+func IsOrphanedHPA(hpa *autoscalingv2.HorizontalPodAutoscaler, w *Workload) bool {
+	if !IsAdoptable(hpa) {
+		return false
+	}
+	return IsOwnerReference(w.UID, hpa.OwnerReferences) || ManageByPixiuController(hpa)
+}
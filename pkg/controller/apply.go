@@ -0,0 +1,66 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	autoscalingv2apply "k8s.io/client-go/applyconfigurations/autoscaling/v2"
+)
+
+const (
+	// ForceOwnershipAlways always forces the apply, the conflicting fields are
+	// taken over from the other managers silently.
+	ForceOwnershipAlways string = "always"
+	// ForceOwnershipOnConflict records a Warning event on conflicts and then
+	// forces the apply.
+	ForceOwnershipOnConflict string = "on-conflict"
+	// ForceOwnershipNever records a Warning event on conflicts and leaves the
+	// conflicting fields to the other managers, the sync is retried later.
+	ForceOwnershipNever string = "never"
+
+	// DefaultForceOwnership is the default force-ownership policy.
+	DefaultForceOwnership = ForceOwnershipOnConflict
+)
+
+// ValidateForceOwnership returns an error if the force-ownership policy is unsupported.
+func ValidateForceOwnership(policy string) error {
+	switch policy {
+	case ForceOwnershipAlways, ForceOwnershipOnConflict, ForceOwnershipNever:
+		return nil
+	}
+	return fmt.Errorf("unsupported force ownership policy %q, must be one of %s, %s and %s", policy, ForceOwnershipAlways, ForceOwnershipOnConflict, ForceOwnershipNever)
+}
+
+// NewHPAApplyConfiguration converts the desired HPA to the apply configuration
+// which holds all the fields managed by controller.
+func NewHPAApplyConfiguration(hpa *autoscalingv2.HorizontalPodAutoscaler) (*autoscalingv2apply.HorizontalPodAutoscalerApplyConfiguration, error) {
+	data, err := json.Marshal(hpa)
+	if err != nil {
+		return nil, err
+	}
+	hpaApply := &autoscalingv2apply.HorizontalPodAutoscalerApplyConfiguration{}
+	if err = json.Unmarshal(data, hpaApply); err != nil {
+		return nil, err
+	}
+
+	// status 由 kube-controller-manager 维护
+	hpaApply.Status = nil
+	return hpaApply.WithKind(HorizontalPodAutoscaler).WithAPIVersion(AutoscalingAPIVersion), nil
+}
@@ -21,19 +21,30 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"gopkg.in/yaml.v2"
 	appsv1 "k8s.io/api/apps/v1"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	"k8s.io/api/core/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/diff"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	autoscalingv2apply "k8s.io/client-go/applyconfigurations/autoscaling/v2"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/informers"
 	appsinformers "k8s.io/client-go/informers/apps/v1"
 	autoscalinginformers "k8s.io/client-go/informers/autoscaling/v2"
 	coreinformers "k8s.io/client-go/informers/core/v1"
@@ -48,12 +59,37 @@ import (
 	"k8s.io/client-go/util/workqueue"
 	"k8s.io/component-base/metrics/prometheus/ratelimiter"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/apis/pixiu/v1alpha1"
 	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller/metrics"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller/notifier"
 )
 
 const (
 	maxRetries = 15
+
+	// managedWorkloadsPeriod 为统计受管理工作负载数量的周期
+	managedWorkloadsPeriod = 30 * time.Second
+
+	// DefaultDrainTimeout is the default time to wait for the in-flight syncs
+	// when the controller is stopped.
+	DefaultDrainTimeout = 20 * time.Second
+
+	// dry-run 模式下记录的资源和操作
+	hpaResource          = "horizontalpodautoscalers"
+	configMapResource    = "configmaps"
+	policyStatusResource = "autoscalingpolicies/status"
+
+	// 同步耗时指标的 handler
+	autoscalerHandler = "autoscaler"
+	configMapHandler  = "configmap"
+
+	createAction = "create"
+	updateAction = "update"
+	deleteAction = "delete"
+	patchAction  = "patch"
 )
 
 // AutoscalerController is responsible for synchronizing HPA objects stored
@@ -62,41 +98,92 @@ type AutoscalerController struct {
 	client        clientset.Interface
 	eventRecorder record.EventRecorder
 
-	syncHandler       func(dKey string) error
-	enqueueDeployment func(deployment *appsv1.Deployment)
+	syncHandler          func(ctx context.Context, wKey string) error
+	enqueueWorkload      func(gk schema.GroupKind, obj metav1.Object)
+	enqueueWorkloadAfter func(gk schema.GroupKind, obj metav1.Object, duration time.Duration)
 
-	syncConfigMapHandler func(dKey string) error
+	syncConfigMapHandler func(ctx context.Context, dKey string) error
 	enqueueConfigMap     func(cm *corev1.ConfigMap)
 
 	// dLister can list/get deployments from the shared informer's store
 	dLister appslisters.DeploymentLister
+	// sLister can list/get statefulsets from the shared informer's store
+	sLister appslisters.StatefulSetLister
 	// hpaLister is able to list/get HPAs from the shared informer's cache
 	hpaLister autoscalinglisters.HorizontalPodAutoscalerLister
 	// cmLister is able to list/get Configmaps from the shared informer's cache
 	cmLister corelisters.ConfigMapLister
+	// nsLister is able to list/get Namespaces from the shared informer's cache
+	nsLister corelisters.NamespaceLister
 
 	// dListerSynced returns true if the Deployment store has been synced at least once.
 	dListerSynced cache.InformerSynced
+	// sListerSynced returns true if the StatefulSet store has been synced at least once.
+	sListerSynced cache.InformerSynced
 	// hpaListerSynced returns true if the HPA store has been synced at least once.
 	hpaListerSynced cache.InformerSynced
 	// cmListerSynced returns true if the configmap store has been synced at least once.
 	cmListerSynced cache.InformerSynced
+	// nsListerSynced returns true if the namespace store has been synced at least once.
+	nsListerSynced cache.InformerSynced
+
+	// scaleTargets 为额外管理的，通过 scale 子资源伸缩的工作负载
+	scaleTargets map[schema.GroupKind]*controller.ScaleTarget
+	// scaleTargetsSynced returns true if the scale target stores have been synced at least once.
+	scaleTargetsSynced []cache.InformerSynced
+
+	// policyLister is able to list/get AutoscalingPolicies from the shared informer's cache,
+	// it is nil if the AutoscalingPolicy CRD is not installed.
+	policyLister cache.GenericLister
+	// policyClient is used to update the status of AutoscalingPolicies
+	policyClient dynamic.NamespaceableResourceInterface
+	// policyListerSynced returns true if the AutoscalingPolicy store has been synced at least once.
+	policyListerSynced cache.InformerSynced
 
 	// AutoscalerController that need to be synced
 	queue workqueue.RateLimitingInterface
 
 	cmQueue workqueue.RateLimitingInterface
 
-	// Store and returns a reference to an empty store.
-	items map[string]controller.Empty
+	// adapter 为 prometheus-adapter 的部署信息
+	adapter controller.PrometheusAdapter
+	// notifier 在 prometheus-adapter 配置变化后通知其重新加载
+	notifier notifier.Notifier
+
+	// clock 用于计算定时副本数范围，测试时可替换
+	clock clock.Clock
+
+	// dryRun 为 true 时仅记录将要执行的操作，写请求通过 apiserver 的 dry-run 校验但不会持久化
+	dryRun bool
+
+	// forceOwnership 决定 server-side apply 与其他管理者冲突时是否强制接管字段
+	forceOwnership string
+
+	// synced 在缓存同步完成且 workers 启动后置为 1
+	synced int32
+	// heartbeats 记录 workers 正在处理的 key，用于存活检查
+	heartbeats *heartbeats
+
+	// adoptPolicy 决定如何处理指向工作负载但不受其控制的 HPA，取值为 adopt，refuse 或 defer
+	adoptPolicy string
+
+	// scope 限定控制器同步的命名空间和工作负载
+	scope *controller.Scope
+
+	// drainTimeout 为停止时等待正在进行的同步完成的最长时间
+	drainTimeout time.Duration
 }
 
 // NewAutoscalerController creates a new AutoscalerController.
 func NewAutoscalerController(
 	dInformer appsinformers.DeploymentInformer,
+	sInformer appsinformers.StatefulSetInformer,
 	hpaInformer autoscalinginformers.HorizontalPodAutoscalerInformer,
 	cmInformer coreinformers.ConfigMapInformer,
-	client clientset.Interface) (*AutoscalerController, error) {
+	nsInformer coreinformers.NamespaceInformer,
+	client clientset.Interface,
+	adapter controller.PrometheusAdapter,
+	adapterNotifier notifier.Notifier) (*AutoscalerController, error) {
 	eventBroadcaster := record.NewBroadcaster()
 	eventBroadcaster.StartLogging(klog.Infof)
 	eventBroadcaster.StartRecordingToSink(&v1core.EventSinkImpl{Interface: client.CoreV1().Events("")})
@@ -107,12 +194,22 @@ func NewAutoscalerController(
 		}
 	}
 
+	metrics.Register()
+
 	ac := &AutoscalerController{
-		client:        client,
-		eventRecorder: eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "pixiu-autoscaler"}),
-		queue:         workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "pixiu"),
-		cmQueue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "pixiu"),
-		items:         controller.NewItems(),
+		client:         client,
+		eventRecorder:  eventBroadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "pixiu-autoscaler"}),
+		queue:          workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "pixiu-autoscaler"),
+		cmQueue:        workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "pixiu-adapter-configmap"),
+		scaleTargets:   make(map[schema.GroupKind]*controller.ScaleTarget),
+		adapter:        adapter,
+		notifier:       adapterNotifier,
+		clock:          clock.RealClock{},
+		heartbeats:     newHeartbeats(clock.RealClock{}),
+		adoptPolicy:    controller.DefaultAdoptPolicy,
+		forceOwnership: controller.DefaultForceOwnership,
+		scope:          &controller.Scope{},
+		drainTimeout:   DefaultDrainTimeout,
 	}
 
 	// Deployment
@@ -122,6 +219,13 @@ func NewAutoscalerController(
 		DeleteFunc: ac.deleteDeployment,
 	})
 
+	// StatefulSet
+	sInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ac.addStatefulSet,
+		UpdateFunc: ac.updateStatefulSet,
+		DeleteFunc: ac.deleteStatefulSet,
+	})
+
 	// HorizontalPodAutoscaler
 	hpaInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
 		AddFunc:    ac.addHPA,
@@ -129,90 +233,273 @@ func NewAutoscalerController(
 		DeleteFunc: ac.deleteHPA,
 	})
 
-	// ConfigMap
-	cmInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    ac.addConfigMap,
-		UpdateFunc: ac.updateConfigMap,
-		DeleteFunc: ac.deleteConfigMap,
+	// Namespace, 命名空间的默认 hpa 注释变化或进入 namespaceSelector 的范围时同步其中的工作负载
+	nsInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ac.addNamespace,
+		UpdateFunc: ac.updateNamespace,
+	})
+
+	// ConfigMap, 仅关注 prometheus-adapter 的配置
+	cmInformer.Informer().AddEventHandler(cache.FilteringResourceEventHandler{
+		FilterFunc: ac.isAdapterConfigMap,
+		Handler: cache.ResourceEventHandlerFuncs{
+			AddFunc:    ac.addConfigMap,
+			UpdateFunc: ac.updateConfigMap,
+			DeleteFunc: ac.deleteConfigMap,
+		},
 	})
 
 	ac.dLister = dInformer.Lister()
+	ac.sLister = sInformer.Lister()
 	ac.hpaLister = hpaInformer.Lister()
 	ac.cmLister = cmInformer.Lister()
+	ac.nsLister = nsInformer.Lister()
 
 	// syncAutoscalers
 	ac.syncHandler = ac.syncAutoscalers
-	ac.enqueueDeployment = ac.enqueue
+	ac.enqueueWorkload = ac.enqueue
+	ac.enqueueWorkloadAfter = ac.enqueueAfter
 
 	// syncConfigMaps
 	ac.syncConfigMapHandler = ac.syncConfigMaps
 	ac.enqueueConfigMap = ac.enqueueCM
 
 	ac.dListerSynced = dInformer.Informer().HasSynced
+	ac.sListerSynced = sInformer.Informer().HasSynced
 	ac.hpaListerSynced = hpaInformer.Informer().HasSynced
 	ac.cmListerSynced = cmInformer.Informer().HasSynced
+	ac.nsListerSynced = nsInformer.Informer().HasSynced
 
 	return ac, nil
 }
 
-// Run begins watching and syncing.
-func (ac *AutoscalerController) Run(workers int, stopCh <-chan struct{}) {
+// AddScaleTarget registers an extra workload resource which exposes the scale
+// subresource. It must be called before the informers and controller are started.
+func (ac *AutoscalerController) AddScaleTarget(target *controller.ScaleTarget) {
+	gk := target.Kind.GroupKind()
+	ac.scaleTargets[gk] = target
+
+	target.Informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			ac.addScaleTarget(gk, obj)
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			ac.updateScaleTarget(gk, old, cur)
+		},
+		DeleteFunc: func(obj interface{}) {
+			ac.deleteScaleTarget(gk, obj)
+		},
+	})
+	ac.scaleTargetsSynced = append(ac.scaleTargetsSynced, target.Informer.Informer().HasSynced)
+}
+
+// EnableDryRun makes the controller observe-only, the intended actions are
+// logged, counted and recorded as events instead. It must be called before
+// the controller is started.
+func (ac *AutoscalerController) EnableDryRun() {
+	ac.dryRun = true
+}
+
+// SetAdoptPolicy sets how to handle the unmanaged HPAs which target the
+// annotated workloads. It must be called before the controller is started.
+func (ac *AutoscalerController) SetAdoptPolicy(policy string) error {
+	if err := controller.ValidateAdoptPolicy(policy); err != nil {
+		return err
+	}
+	ac.adoptPolicy = policy
+	return nil
+}
+
+// SetDrainTimeout sets how long to wait for the in-flight syncs when the
+// controller is stopped. It must be called before the controller is started.
+func (ac *AutoscalerController) SetDrainTimeout(timeout time.Duration) {
+	ac.drainTimeout = timeout
+}
+
+// SetScope limits the namespaces and workloads which are reconciled by the
+// controller, the informers are expected to be limited by the same scope. It
+// must be called before the controller is started.
+func (ac *AutoscalerController) SetScope(scope *controller.Scope) {
+	ac.scope = scope
+}
+
+// inScope returns true if the workloads in the namespace are reconciled by the controller.
+func (ac *AutoscalerController) inScope(namespace string) bool {
+	if !ac.scope.ContainsNamespace(namespace) {
+		return false
+	}
+	if !ac.scope.SelectsNamespaces() {
+		return true
+	}
+	// namespace informer 仅缓存 namespaceSelector 选中的命名空间
+	_, err := ac.nsLister.Get(namespace)
+	return err == nil
+}
+
+// SetForceOwnership sets whether to force the server-side apply of HPAs on
+// conflicts. It must be called before the controller is started.
+func (ac *AutoscalerController) SetForceOwnership(policy string) error {
+	if err := controller.ValidateForceOwnership(policy); err != nil {
+		return err
+	}
+	ac.forceOwnership = policy
+	return nil
+}
+
+// AddAutoscalingPolicy enables the AutoscalingPolicy support. It must be called
+// before the informers and controller are started.
+func (ac *AutoscalerController) AddAutoscalingPolicy(informer informers.GenericInformer, client dynamic.Interface) {
+	informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    ac.addPolicy,
+		UpdateFunc: ac.updatePolicy,
+		DeleteFunc: ac.deletePolicy,
+	})
+
+	ac.policyLister = informer.Lister()
+	ac.policyClient = client.Resource(v1alpha1.AutoscalingPolicyResource)
+	ac.policyListerSynced = informer.Informer().HasSynced
+}
+
+// Run begins watching and syncing until ctx is cancelled, and then drains the
+// in-flight syncs before returning.
+func (ac *AutoscalerController) Run(ctx context.Context, workers int) {
 	defer utilruntime.HandleCrash()
 	defer ac.queue.ShutDown()
+	defer ac.cmQueue.ShutDown()
 
 	klog.Infof("Starting Pixiu Autoscaler Controller")
 	defer klog.Infof("Shutting down Pixiu Autoscaler Controller")
 
+	stopCh := ctx.Done()
+
 	// Wait for all involved caches to be synced, before processing items from the queue is started
-	if !cache.WaitForNamedCacheSync("pixiu-autoscaler-controller", stopCh, ac.dListerSynced, ac.hpaListerSynced, ac.cmListerSynced) {
+	cacheSyncs := append([]cache.InformerSynced{ac.dListerSynced, ac.sListerSynced, ac.hpaListerSynced, ac.cmListerSynced, ac.nsListerSynced}, ac.scaleTargetsSynced...)
+	if ac.policyListerSynced != nil {
+		cacheSyncs = append(cacheSyncs, ac.policyListerSynced)
+	}
+	if !cache.WaitForNamedCacheSync("pixiu-autoscaler-controller", stopCh, cacheSyncs...) {
 		return
 	}
 
+	// workers 使用独立的 context，停止后正在进行的同步仍可以完成，排空超时后才取消其请求
+	workerCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
 	for i := 0; i < workers; i++ {
-		go wait.Until(ac.worker, time.Second, stopCh)
-	}
-	for i := 0; i < workers; i++ {
-		go wait.Until(ac.configMapWorker, time.Second, stopCh)
-	}
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			wait.UntilWithContext(workerCtx, ac.worker, time.Second)
+		}()
+		go func() {
+			defer wg.Done()
+			wait.UntilWithContext(workerCtx, ac.configMapWorker, time.Second)
+		}()
+	}
+	go ac.notifier.Run(ctx)
+	ac.flushPendingNotify(ctx)
+	go wait.Until(ac.updateManagedWorkloads, managedWorkloadsPeriod, stopCh)
+	atomic.StoreInt32(&ac.synced, 1)
 
 	<-stopCh
+	ac.drain(cancel, &wg)
 }
 
-// IsCustomMetricHPA 判断 deployment 是否维护自定位指标的 HPA
-func (ac *AutoscalerController) IsCustomMetricHPA(d *appsv1.Deployment) bool {
-	if !ac.IsDeploymentControlHPA(d) {
-		return false
+// drain shuts down both queues and waits for the in-flight syncs to finish,
+// their requests are cancelled after the drain timeout.
+func (ac *AutoscalerController) drain(cancel context.CancelFunc, wg *sync.WaitGroup) {
+	klog.Infof("Draining Pixiu Autoscaler Controller, timeout %v", ac.drainTimeout)
+
+	drained := make(chan struct{})
+	go func() {
+		var queues sync.WaitGroup
+		queues.Add(2)
+		go func() {
+			defer queues.Done()
+			ac.queue.ShutDownWithDrain()
+		}()
+		go func() {
+			defer queues.Done()
+			ac.cmQueue.ShutDownWithDrain()
+		}()
+		queues.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-time.After(ac.drainTimeout):
+		klog.Warningf("Timed out draining Pixiu Autoscaler Controller after %v, cancelling the in-flight syncs", ac.drainTimeout)
 	}
 
-	annotations := d.GetAnnotations()
-	_, ok := annotations[controller.PrometheusCustomMetric]
-	return ok
+	// 取消未完成的请求，并等待 workers 退出
+	cancel()
+	wg.Wait()
 }
 
-// IsDeploymentControlHPA 判断 deployment 是否维护 HPA
-func (ac *AutoscalerController) IsDeploymentControlHPA(d *appsv1.Deployment) bool {
-	annotations := d.GetAnnotations()
-	if annotations == nil {
+// updateManagedWorkloads counts the workloads which are autoscaled by the HPAs
+// applied by controller.
+func (ac *AutoscalerController) updateManagedWorkloads() {
+	hpaList, err := ac.hpaLister.List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	counts := make(map[schema.GroupKind]int)
+	for gk := range ac.scaleTargets {
+		counts[gk] = 0
+	}
+	for _, kind := range []string{controller.Deployment, controller.StatefulSet} {
+		counts[appsv1.SchemeGroupVersion.WithKind(kind).GroupKind()] = 0
+	}
+	for _, hpa := range hpaList {
+		controllerRef := metav1.GetControllerOf(hpa)
+		if controllerRef == nil || !controller.ManageByPixiuController(hpa) || !ac.inScope(hpa.Namespace) {
+			continue
+		}
+		if gk := groupKindForRef(controllerRef); ac.isSupportedGroupKind(gk) {
+			counts[gk]++
+		}
+	}
+	for gk, count := range counts {
+		metrics.ManagedWorkloads.WithLabelValues(gk.String()).Set(float64(count))
+	}
+}
+
+// IsCustomMetricHPA 判断工作负载是否维护自定位指标的 HPA
+func (ac *AutoscalerController) IsCustomMetricHPA(w *controller.Workload) bool {
+	if !ac.IsWorkloadControlHPA(w) {
 		return false
 	}
 
+	annotations := w.GetAnnotations()
+	for _, customMetric := range []string{controller.PrometheusCustomMetric, controller.PodsCustomMetric, controller.ObjectCustomMetric} {
+		if _, ok := annotations[customMetric]; ok {
+			return true
+		}
+	}
 	for annotation := range annotations {
-		_, found := ac.items[annotation]
-		if found {
+		if controller.IsPrometheusMetric(annotation) {
 			return true
 		}
 	}
-
 	return false
 }
 
-func (ac *AutoscalerController) syncConfigMaps(key string) error {
+// IsWorkloadControlHPA 判断工作负载是否维护 HPA
+func (ac *AutoscalerController) IsWorkloadControlHPA(w *controller.Workload) bool {
+	return controller.IsWorkloadControlHPA(w.GetAnnotations())
+}
+
+func (ac *AutoscalerController) syncConfigMaps(ctx context.Context, key string) error {
 	namespace, name, err := cache.SplitMetaNamespaceKey(key)
 	if err != nil {
 		klog.ErrorS(err, "Failed to split meta namespace cache key", "cacheKey", key)
 		return err
 	}
-	if name != controller.DesireConfigMapName {
+	if namespace != ac.adapter.Namespace || name != ac.adapter.ConfigMapName {
 		return nil
 	}
 
@@ -220,32 +507,26 @@ func (ac *AutoscalerController) syncConfigMaps(key string) error {
 	if err != nil {
 		return err
 	}
-	var externalRules []controller.ExternalRule
+	var (
+		rules         []controller.Rule
+		externalRules []controller.ExternalRule
+	)
 	for _, h := range hpaList {
-		// 无需检查，hpa API已做个检验，此处为遵守coding规范
-		metrics := h.Spec.Metrics
-		if len(metrics) == 0 {
-			return fmt.Errorf("no metric found in hpa(%s)", h.Name)
+		if !ac.inScope(h.Namespace) {
+			continue
 		}
-		metric := metrics[0]
-		if metric.External == nil {
-			return fmt.Errorf("no external metric found in hpa(%s)", h.Name)
+		hpaRules, hpaExternalRules, err := rulesForHPA(h)
+		if err != nil {
+			// 单个 HPA 不满足条件时跳过，不影响其他 HPA 的规则生成
+			klog.Warningf("Skip generating adapter rules for HPA %s/%s: %v", h.Namespace, h.Name, err)
+			ac.eventRecorder.Eventf(h, v1.EventTypeWarning, "SkipAdapterRules", fmt.Sprintf("Skip generating adapter rules for HPA %s/%s: %v", h.Namespace, h.Name, err))
+			continue
 		}
-
-		externalRules = append(externalRules, controller.ExternalRule{
-			MetricsQuery: "<<.Series>>",
-			Name: controller.RuleName{
-				As:      "",
-				Matches: "",
-			},
-			Resources: controller.ResourceMap{
-				Overrides: map[string]controller.ResourceOverride{
-					"namespace": {Resource: "namespace"},
-				},
-			},
-			SeriesQuery: metric.External.Metric.Name,
-		})
+		rules = append(rules, hpaRules...)
+		externalRules = append(externalRules, hpaExternalRules...)
 	}
+	// 多个 HPA 可能使用相同的指标，每个指标只生成一条规则
+	externalRules = controller.UniqueExternalRules(externalRules)
 
 	configMap, err := ac.cmLister.ConfigMaps(namespace).Get(name)
 	if errors.IsNotFound(err) {
@@ -261,199 +542,718 @@ func (ac *AutoscalerController) syncConfigMaps(key string) error {
 		return nil
 	}
 
-	var cfg controller.PrometheusAdapterConfig
-	if err = yaml.Unmarshal([]byte(cm.Data["config.yaml"]), &cfg); err != nil {
+	adapterConfig, err := controller.ParseAdapterConfig(cm.Data[ac.adapter.ConfigMapKey])
+	if err != nil {
 		klog.Errorf("Failed to unmarshal adapter configmap: %v", err)
 		return err
 	}
+	// 重新编码原配置，忽略格式上的差异
+	oldConfig, err := adapterConfig.String()
+	if err != nil {
+		return err
+	}
 
-	// 退出，如果 externalRules 配置未发生变化则直接退出
-	if reflect.DeepEqual(cfg.ExternalRules, externalRules) {
-		return nil
+	// 仅修改控制器生成的规则，手动维护的规则及其他配置保持不变
+	owned, found, err := controller.ParseOwnedRules(cm.Annotations)
+	if err != nil {
+		return err
+	}
+	if !found {
+		// 旧版本的控制器未记录生成的规则，且会覆盖全部的 externalRules
+		if owned[controller.ExternalRulesKey], err = adapterConfig.LegacyOwnedExternalRules(); err != nil {
+			return err
+		}
 	}
 
-	cfg.ExternalRules = externalRules
-	newConfig, err := yaml.Marshal(&cfg)
+	newOwned := controller.OwnedRules{}
+	for ruleKey, desired := range map[string]interface{}{
+		controller.RulesKey:         controller.UniqueRules(rules),
+		controller.ExternalRulesKey: externalRules,
+	} {
+		ownedRules, err := adapterConfig.SetRules(ruleKey, owned[ruleKey], desired)
+		if err != nil {
+			klog.Errorf("Failed to set %s of adapter config: %v", ruleKey, err)
+			return err
+		}
+		if len(ownedRules) != 0 {
+			newOwned[ruleKey] = ownedRules
+		}
+	}
+	newConfig, err := adapterConfig.String()
 	if err != nil {
 		klog.Errorf("Failed to marshal adapter config: %v", err)
 		return err
 	}
 
-	cm.Data["config.yaml"] = string(newConfig)
-	_, err = ac.client.CoreV1().ConfigMaps(cm.Namespace).Update(context.TODO(), cm, metav1.UpdateOptions{})
+	// 退出，如果规则未发生变化则直接退出；上次的通知未完成时（例如重启失败）重新通知
+	if newConfig == oldConfig && cm.Annotations[controller.OwnedRulesAnnotation] == newOwned.String() {
+		if _, pending := cm.Annotations[controller.NotifyPending]; pending {
+			return ac.notifier.Notify(ctx)
+		}
+		return nil
+	}
+
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	if cm.Annotations == nil {
+		cm.Annotations = map[string]string{}
+	}
+	cm.Data[ac.adapter.ConfigMapKey] = newConfig
+	cm.Annotations[controller.OwnedRulesAnnotation] = newOwned.String()
+	// 与规则一同写入待通知的记录，通知完成后由 notifier 清除，通知失败或控制器退出时不会丢失
+	cm.Annotations[controller.NotifyPending] = ac.clock.Now().UTC().Format(time.RFC3339)
+	_, err = ac.client.CoreV1().ConfigMaps(cm.Namespace).Update(ctx, cm, metav1.UpdateOptions{DryRun: ac.dryRunOption()})
 	if err != nil {
 		return err
 	}
+	if ac.dryRun {
+		ac.recordDryRun(cm, configMapResource, updateAction, cm.Namespace, cm.Name, configMap, cm)
+	}
 
-	return ac.notifyAdapter()
+	// 通知的结果由 notifier 统计，debounce 策略在窗口结束后才真正通知
+	return ac.notifier.Notify(ctx)
 }
 
-func (ac *AutoscalerController) notifyAdapter() error {
-	// TODO: 抽成变量
-	ns := "pixiu-system"
-
-	deployment, err := ac.client.AppsV1().Deployments(ns).Get(context.TODO(), controller.DesireConfigMapName, metav1.GetOptions{})
+// flushPendingNotify notifies the prometheus adapter again if the previous
+// notification is still pending, for example the controller lost its leadership
+// within the debounce window.
+func (ac *AutoscalerController) flushPendingNotify(ctx context.Context) {
+	cm, err := ac.cmLister.ConfigMaps(ac.adapter.Namespace).Get(ac.adapter.ConfigMapName)
 	if err != nil {
-		return fmt.Errorf("failed to get prometheus-adapter: %v", err)
+		if !errors.IsNotFound(err) {
+			utilruntime.HandleError(err)
+		}
+		return
 	}
-	patchPayloadTemplate :=
-		`[{
-        "op": "%s",
-        "path": "/spec/template/metadata/annotations",
-        "value": %s
-    }]`
-	op := "replace"
-	tplAnnotations := deployment.Spec.Template.Annotations
-	if len(tplAnnotations) == 0 {
-		tplAnnotations = map[string]string{}
-		op = "add"
+	pending, ok := cm.Annotations[controller.NotifyPending]
+	if !ok {
+		return
 	}
 
-	tplAnnotations["deployment.pixiu.io/restartAt"] = metav1.Now().Format("2006-01-02T15:04:05Z")
-	raw, err := json.Marshal(tplAnnotations)
+	klog.Infof("Flushing the pending notification of prometheus-adapter since %s", pending)
+	if err = ac.notifier.Notify(ctx); err != nil {
+		utilruntime.HandleError(fmt.Errorf("failed to flush the pending notification of prometheus-adapter: %v", err))
+	}
+}
+
+// rulesForHPA returns the prometheus adapter rules and external rules required by the HPA.
+func rulesForHPA(h *autoscalingv2.HorizontalPodAutoscaler) ([]controller.Rule, []controller.ExternalRule, error) {
+	// 无需检查，hpa API已做个检验，此处为遵守coding规范
+	if len(h.Spec.Metrics) == 0 {
+		return nil, nil, fmt.Errorf("no metric found in hpa(%s)", h.Name)
+	}
+
+	var rules []controller.Rule
+	for _, metric := range h.Spec.Metrics {
+		switch metric.Type {
+		case autoscalingv2.PodsMetricSourceType:
+			rules = append(rules, controller.NewPodsRule(metric.Pods))
+		case autoscalingv2.ObjectMetricSourceType:
+			rules = append(rules, controller.NewObjectRule(metric.Object))
+		}
+	}
+	externalRules, err := controller.ExternalRulesForHPA(h)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	patchPayload := fmt.Sprintf(patchPayloadTemplate, op, raw)
-	if _, err = ac.client.AppsV1().Deployments(ns).Patch(context.Background(), controller.DesireConfigMapName, types.JSONPatchType, []byte(patchPayload), metav1.PatchOptions{}); err != nil {
-		klog.Errorf("failed to patch deployment: %v", err)
-		return err
+	// 写入 configmap 前校验规则，避免错误的模板导致 prometheus-adapter 无法启动
+	for _, externalRule := range externalRules {
+		if err = controller.ValidateExternalRule(externalRule); err != nil {
+			return nil, nil, fmt.Errorf("invalid external rule %q: %v", externalRule.SeriesQuery, err)
+		}
 	}
 
-	return err
+	if len(rules) == 0 && len(externalRules) == 0 {
+		return nil, nil, fmt.Errorf("no custom metric found in hpa(%s)", h.Name)
+	}
+	return rules, externalRules, nil
 }
 
 // syncAutoscaler will sync the autoscaler with the given key.
 // This function is not meant to be invoked concurrently with the same key.
-func (ac *AutoscalerController) syncAutoscalers(key string) error {
-	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+func (ac *AutoscalerController) syncAutoscalers(ctx context.Context, key string) error {
+	gk, namespace, name, err := controller.SplitWorkloadKey(key)
 	if err != nil {
-		klog.ErrorS(err, "Failed to split meta namespace cache key", "cacheKey", key)
+		klog.ErrorS(err, "Failed to split workload cache key", "cacheKey", key)
 		return err
 	}
 
+	if !ac.inScope(namespace) {
+		klog.V(4).InfoS("Skip syncing the workload out of scope", "kind", gk.String(), "workload", klog.KRef(namespace, name))
+		return nil
+	}
+
 	startTime := time.Now()
 	klog.V(4).InfoS("Started syncing pixiu autoscaler", "pixiu-autoscaler", "startTime", startTime)
 	defer func() {
 		klog.V(4).InfoS("Finished syncing pixiu autoscaler", "pixiu-autoscaler", "duration", time.Since(startTime))
 	}()
 
-	deployment, err := ac.dLister.Deployments(namespace).Get(name)
+	policies, err := ac.getPoliciesForWorkload(gk, namespace, name)
+	if err != nil {
+		return err
+	}
+	if !ac.isSupportedGroupKind(gk) {
+		// 仅 AutoscalingPolicy 可能指向不支持的工作负载类型
+		return ac.updatePoliciesStatus(ctx, policies, "", metav1.ConditionFalse, v1alpha1.ReasonInvalidPolicy,
+			fmt.Sprintf("unsupported target kind %s", gk.String()))
+	}
+
+	w, err := ac.getWorkload(gk, namespace, name)
 	if errors.IsNotFound(err) {
-		klog.V(2).InfoS("Deployment has been deleted", "deployment", klog.KRef(namespace, name))
-		return nil
+		klog.V(2).InfoS("Workload has been deleted", "kind", gk.String(), "workload", klog.KRef(namespace, name))
+		return ac.updatePoliciesStatus(ctx, policies, "", metav1.ConditionFalse, v1alpha1.ReasonTargetNotFound,
+			fmt.Sprintf("%s %s/%s not found", gk.String(), namespace, name))
 	}
 	if err != nil {
 		return err
 	}
-
-	// 深拷贝，避免缓存被修改
-	d := deployment.DeepCopy()
-	if d.DeletionTimestamp != nil {
+	if w.DeletionTimestamp != nil {
 		return nil
 	}
 
-	hpaList, err := ac.getHPAsForDeployment(d)
+	hpaList, err := ac.getHPAsForWorkload(w)
 	if err != nil {
 		return err
 	}
-	return ac.sync(d, hpaList)
+	return ac.sync(ctx, w, hpaList, policies)
 }
 
-func (ac *AutoscalerController) sync(d *appsv1.Deployment, hpaList []*autoscalingv2.HorizontalPodAutoscaler) error {
-	// 1. deployment 存在，但是 hpa 注释不存在 => 移除已存在的 hpa
-	if !ac.IsDeploymentControlHPA(d) {
-		return ac.deleteHPAsInBatch(hpaList)
+// isSupportedGroupKind returns true if the workloads of the group kind are managed by controller.
+func (ac *AutoscalerController) isSupportedGroupKind(gk schema.GroupKind) bool {
+	switch gk {
+	case appsv1.SchemeGroupVersion.WithKind(controller.Deployment).GroupKind(),
+		appsv1.SchemeGroupVersion.WithKind(controller.StatefulSet).GroupKind():
+		return true
 	}
+	_, ok := ac.scaleTargets[gk]
+	return ok
+}
 
-	newHPA, err := controller.CreateHPAFromDeployment(d)
+// getPoliciesForWorkload returns the AutoscalingPolicies which target the workload,
+// sorted by creation time.
+func (ac *AutoscalerController) getPoliciesForWorkload(gk schema.GroupKind, namespace, name string) ([]*v1alpha1.AutoscalingPolicy, error) {
+	if ac.policyLister == nil {
+		return nil, nil
+	}
+	objs, err := ac.policyLister.ByNamespace(namespace).List(labels.Everything())
 	if err != nil {
-		ac.eventRecorder.Eventf(d, v1.EventTypeWarning, "FailedNewestHPA", fmt.Sprintf("Failed extract newest HPA %s/%s", d.GetNamespace(), d.GetName()))
+		return nil, err
+	}
+
+	var policies []*v1alpha1.AutoscalingPolicy
+	for _, obj := range objs {
+		policy, err := controller.ConvertToPolicy(obj)
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		if policy.DeletionTimestamp != nil {
+			continue
+		}
+		if policy.Spec.TargetRef.Name == name && controller.PolicyTargetGroupKind(policy) == gk {
+			policies = append(policies, policy)
+		}
+	}
+	controller.SortPolicies(policies)
+
+	return policies, nil
+}
+
+// getWorkload 从缓存中获取指定类型的工作负载
+func (ac *AutoscalerController) getWorkload(gk schema.GroupKind, namespace, name string) (*controller.Workload, error) {
+	switch gk {
+	case appsv1.SchemeGroupVersion.WithKind(controller.Deployment).GroupKind():
+		deployment, err := ac.dLister.Deployments(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		// 深拷贝，避免缓存被修改
+		return controller.NewWorkloadFromDeployment(deployment.DeepCopy()), nil
+	case appsv1.SchemeGroupVersion.WithKind(controller.StatefulSet).GroupKind():
+		statefulSet, err := ac.sLister.StatefulSets(namespace).Get(name)
+		if err != nil {
+			return nil, err
+		}
+		return controller.NewWorkloadFromStatefulSet(statefulSet.DeepCopy()), nil
+	}
+
+	target, ok := ac.scaleTargets[gk]
+	if !ok {
+		return nil, fmt.Errorf("unsupported workload kind %s", gk.String())
+	}
+	obj, err := target.Informer.Lister().ByNamespace(namespace).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return controller.NewWorkloadFromObject(target.Kind, obj.DeepCopyObject())
+}
+
+func (ac *AutoscalerController) sync(ctx context.Context, w *controller.Workload, hpaList []*autoscalingv2.HorizontalPodAutoscaler, policies []*v1alpha1.AutoscalingPolicy) error {
+	// AutoscalingPolicy 优先于注释，存在 policy 时忽略工作负载的 hpa 注释
+	if len(policies) != 0 {
+		return ac.syncPolicy(ctx, w, hpaList, policies)
+	}
+
+	// 命名空间的默认注释视为工作负载自身的注释
+	if err := ac.mergeNamespaceDefaults(w); err != nil {
+		return err
+	}
+
+	// 1. 工作负载存在，但是 hpa 注释不存在 => 移除已存在的 hpa
+	if !ac.IsWorkloadControlHPA(w) {
+		return ac.deleteHPAsInBatch(ctx, hpaList)
+	}
+
+	now := ac.clock.Now()
+	newHPA, err := controller.CreateHPAFromWorkload(w, now)
+	for _, reason := range controller.ParseFailureReasons(err) {
+		metrics.ParseFailures.WithLabelValues(reason).Inc()
+	}
+	if controller.IsContainerNotFound(err) {
+		ac.eventRecorder.Eventf(w.Object, v1.EventTypeWarning, "ContainerNotFound", fmt.Sprintf("Failed extract newest HPA %s/%s: %v", w.GetNamespace(), w.GetName(), err))
 		return err
 	}
-	if ac.IsCustomMetricHPA(d) {
+	if err != nil {
+		// 注释校验失败时 err 中包含所有字段的错误，同时由 handleErr 记录日志
+		ac.eventRecorder.Eventf(w.Object, v1.EventTypeWarning, "FailedNewestHPA", fmt.Sprintf("Failed extract newest HPA %s/%s: %v", w.GetNamespace(), w.GetName(), err))
+		return err
+	}
+	if ac.IsCustomMetricHPA(w) {
 		newHPA.Labels[controller.PrometheusCustomMetric] = "true"
 	}
 
-	if len(hpaList) == 0 {
-		// 新建
-		_, err = ac.client.AutoscalingV2().HorizontalPodAutoscalers(newHPA.Namespace).Create(context.TODO(), newHPA, metav1.CreateOptions{})
-		if err != nil {
-			ac.eventRecorder.Eventf(newHPA, v1.EventTypeWarning, "FailedCreateHPA", fmt.Sprintf("Failed to create HPA %s/%s: %v", newHPA.Namespace, newHPA.Name, err))
+	hpaList, ok, err := ac.resolveUnmanagedHPAs(ctx, w, hpaList)
+	if err != nil || !ok {
+		return err
+	}
+
+	resumeAt, err := ac.pauseHPA(w, newHPA, hpaList, now)
+	if err != nil {
+		return err
+	}
+
+	var oldSchedule string
+	if len(hpaList) != 0 {
+		oldSchedule = hpaList[0].Annotations[controller.ActiveScheduleAnnotation]
+	}
+	if err = ac.syncHPA(ctx, newHPA, hpaList); err != nil {
+		return err
+	}
+	ac.recordScheduleChange(w, oldSchedule, newHPA.Annotations[controller.ActiveScheduleAnnotation])
+	ac.recordPauseChange(w, hpaList, newHPA, resumeAt)
+
+	// 在下一次定时开始或结束，或者暂停到期时重新同步
+	schedules, err := controller.ParseSchedules(w.GetAnnotations())
+	if err != nil {
+		return err
+	}
+	next, _ := controller.NextScheduleBoundary(schedules, now)
+	ac.requeueAt(w, now, next, resumeAt)
+	return nil
+}
+
+// resolveUnmanagedHPAs resolves the conflicts with the HPAs which target the
+// workload but are not controlled by it according to the adopt policy. It
+// returns the HPAs to sync, the adopted ones come first, and false if the
+// workload should be left to the unmanaged HPAs.
+func (ac *AutoscalerController) resolveUnmanagedHPAs(ctx context.Context, w *controller.Workload, hpaList []*autoscalingv2.HorizontalPodAutoscaler) ([]*autoscalingv2.HorizontalPodAutoscaler, bool, error) {
+	unmanaged, err := ac.getUnmanagedHPAsForWorkload(w)
+	if err != nil || len(unmanaged) == 0 {
+		return hpaList, true, err
+	}
+
+	var orphaned, adoptable, conflicts []*autoscalingv2.HorizontalPodAutoscaler
+	for _, hpa := range unmanaged {
+		switch {
+		case controller.IsOrphanedHPA(hpa, w):
+			orphaned = append(orphaned, hpa)
+		case controller.IsAdoptable(hpa):
+			adoptable = append(adoptable, hpa)
+		default:
+			conflicts = append(conflicts, hpa)
+		}
+	}
+
+	// 控制器创建但丢失 ownerReference 的 HPA 总是被接管
+	adopted := orphaned
+	if ac.adoptPolicy == controller.AdoptPolicyAdopt && len(conflicts) == 0 {
+		adopted = append(adopted, adoptable...)
+		adoptable = nil
+	}
+	conflicts = append(adoptable, conflicts...)
+
+	if len(conflicts) != 0 {
+		names := hpaNames(conflicts)
+		if ac.adoptPolicy == controller.AdoptPolicyDefer {
+			ac.eventRecorder.Eventf(w.Object, v1.EventTypeNormal, "DeferToUnmanagedHPA", fmt.Sprintf("%s %s/%s is left to the unmanaged HPA %s", w.Kind, w.GetNamespace(), w.GetName(), names))
+		} else {
+			// 其他控制器管理的 HPA 无法接管，adopt 策略下同样拒绝
+			ac.eventRecorder.Eventf(w.Object, v1.EventTypeWarning, "UnmanagedHPAConflict", fmt.Sprintf("%s %s/%s is already autoscaled by the unmanaged HPA %s, remove it or set the adopt policy to adopt", w.Kind, w.GetNamespace(), w.GetName(), names))
+		}
+		// 不修改已存在的 HPA，移除控制器创建的 HPA，避免两个 HPA 同时伸缩工作负载
+		return nil, false, ac.deleteHPAsInBatch(ctx, append(hpaList, orphaned...))
+	}
+
+	for _, hpa := range adopted {
+		klog.V(2).InfoS("Adopting HPA", "hpa", klog.KObj(hpa), "kind", w.Kind, "workload", klog.KRef(w.GetNamespace(), w.GetName()))
+		ac.eventRecorder.Eventf(w.Object, v1.EventTypeNormal, "AdoptHPA", fmt.Sprintf("Adopt HPA %s/%s for %s %s/%s", hpa.Namespace, hpa.Name, w.Kind, w.GetNamespace(), w.GetName()))
+	}
+	// 接管的 HPA 排在前面，由 syncHPA 更新为期望的状态，多余的 HPA 会被删除
+	return append(adopted, hpaList...), true, nil
+}
+
+// pauseHPA freezes the desired HPA at the current replicas if the autoscaling
+// of the workload is paused, it returns the time to resume automatically.
+func (ac *AutoscalerController) pauseHPA(w *controller.Workload, newHPA *autoscalingv2.HorizontalPodAutoscaler, hpaList []*autoscalingv2.HorizontalPodAutoscaler, now time.Time) (time.Time, error) {
+	paused, resumeAt, err := controller.IsPaused(w.GetAnnotations(), now)
+	if err != nil || !paused {
+		return time.Time{}, err
+	}
+
+	// 优先使用工作负载期望的副本数，通过 scale 子资源管理的工作负载使用 HPA 观测到的副本数
+	var replicas int32
+	switch {
+	case w.Replicas != nil:
+		replicas = *w.Replicas
+	case len(hpaList) != 0 && hpaList[0].Status.CurrentReplicas != 0:
+		replicas = hpaList[0].Status.CurrentReplicas
+	default:
+		// 副本数未知时不能随意固定，否则可能导致工作负载被缩容
+		err = fmt.Errorf("current replicas of %s %s/%s is unknown", w.Kind, w.GetNamespace(), w.GetName())
+		ac.eventRecorder.Eventf(w.Object, v1.EventTypeWarning, "FailedPauseHPA", fmt.Sprintf("Failed to pause autoscaling of %s/%s: %v", w.GetNamespace(), w.GetName(), err))
+		return time.Time{}, err
+	}
+
+	controller.FreezeHPA(newHPA, replicas)
+	return resumeAt, nil
+}
+
+// recordPauseChange records an event if the autoscaling is paused or resumed.
+func (ac *AutoscalerController) recordPauseChange(w *controller.Workload, hpaList []*autoscalingv2.HorizontalPodAutoscaler, newHPA *autoscalingv2.HorizontalPodAutoscaler, resumeAt time.Time) {
+	wasPaused := len(hpaList) != 0 && controller.IsHPAPaused(hpaList[0])
+	switch isPaused := controller.IsHPAPaused(newHPA); {
+	case !wasPaused && isPaused:
+		message := fmt.Sprintf("Autoscaling of %s/%s is paused at %d replicas", w.GetNamespace(), w.GetName(), newHPA.Spec.MaxReplicas)
+		if !resumeAt.IsZero() {
+			message += fmt.Sprintf(" until %s", resumeAt.Format(time.RFC3339))
+		}
+		ac.eventRecorder.Eventf(w.Object, v1.EventTypeNormal, "AutoscalingPaused", message)
+	case wasPaused && !isPaused:
+		ac.eventRecorder.Eventf(w.Object, v1.EventTypeNormal, "AutoscalingResumed", fmt.Sprintf("Autoscaling of %s/%s is resumed", w.GetNamespace(), w.GetName()))
+	}
+}
+
+// requeueAt requeues the workload at the earliest of the given times, the zero
+// times are ignored.
+func (ac *AutoscalerController) requeueAt(w *controller.Workload, now time.Time, times ...time.Time) {
+	var next time.Time
+	for _, t := range times {
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if next.IsZero() {
+		return
+	}
+
+	klog.V(4).InfoS("Requeue workload", "workload", klog.KRef(w.GetNamespace(), w.GetName()), "time", next)
+	ac.enqueueWorkloadAfter(w.GroupKind(), w, next.Sub(now))
+}
+
+// recordScheduleChange records an event if the active schedule is changed.
+func (ac *AutoscalerController) recordScheduleChange(w *controller.Workload, oldSchedule, newSchedule string) {
+	if oldSchedule == newSchedule {
+		return
+	}
+	if len(newSchedule) != 0 {
+		ac.eventRecorder.Eventf(w.Object, v1.EventTypeNormal, "ScheduleActivated", fmt.Sprintf("Schedule %s of %s/%s is active", newSchedule, w.GetNamespace(), w.GetName()))
+		return
+	}
+	ac.eventRecorder.Eventf(w.Object, v1.EventTypeNormal, "ScheduleDeactivated", fmt.Sprintf("Schedule %s of %s/%s is no longer active", oldSchedule, w.GetNamespace(), w.GetName()))
+}
+
+// mergeNamespaceDefaults merges the default hpa annotations of the workload's namespace.
+func (ac *AutoscalerController) mergeNamespaceDefaults(w *controller.Workload) error {
+	ns, err := ac.nsLister.Get(w.Namespace)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	w.MergeNamespaceDefaults(ns)
+	return nil
+}
+
+// syncPolicy syncs the HPA of the workload with the oldest policy, and records
+// the result in the status of the policies.
+func (ac *AutoscalerController) syncPolicy(ctx context.Context, w *controller.Workload, hpaList []*autoscalingv2.HorizontalPodAutoscaler, policies []*v1alpha1.AutoscalingPolicy) error {
+	// 同一工作负载存在多个 policy 时，仅最早创建的生效
+	policy := policies[0]
+	if err := ac.updatePoliciesStatus(ctx, policies[1:], "", metav1.ConditionFalse, v1alpha1.ReasonPolicyConflicts,
+		fmt.Sprintf("%s %s/%s is already targeted by policy %s", w.Kind, w.Namespace, w.Name, policy.Name)); err != nil {
+		return err
+	}
+	if ac.IsWorkloadControlHPA(w) {
+		ac.eventRecorder.Eventf(w.Object, v1.EventTypeNormal, "PolicyOverridesAnnotations", fmt.Sprintf("HPA annotations of %s/%s are ignored in favor of policy %s", w.GetNamespace(), w.GetName(), policy.Name))
+	}
+
+	newHPA, err := controller.CreateHPAFromPolicy(w, policy)
+	if err != nil {
+		ac.eventRecorder.Eventf(w.Object, v1.EventTypeWarning, "FailedNewestHPA", fmt.Sprintf("Failed extract newest HPA %s/%s from policy %s: %v", w.GetNamespace(), w.GetName(), policy.Name, err))
+		if statusErr := ac.updatePolicyStatus(ctx, policy, "", metav1.ConditionFalse, v1alpha1.ReasonInvalidPolicy, err.Error()); statusErr != nil {
+			return statusErr
+		}
+		if controller.IsContainerNotFound(err) {
 			return err
 		}
-		ac.eventRecorder.Eventf(newHPA, v1.EventTypeNormal, "CreateHPA", fmt.Sprintf("Create HPA %s/%s success", newHPA.Namespace, newHPA.Name))
-	} else {
-		// 更新 if necessary
-		oldHPA := hpaList[0]
-		if err := ac.deleteHPAsInBatch(hpaList[1:]); err != nil {
+		// policy 本身有误，重试无意义，等待 policy 更新
+		return nil
+	}
+
+	hpaList, ok, err := ac.resolveUnmanagedHPAs(ctx, w, hpaList)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ac.updatePolicyStatus(ctx, policy, "", metav1.ConditionFalse, v1alpha1.ReasonUnmanagedHPA,
+			fmt.Sprintf("%s %s/%s is already autoscaled by an unmanaged HPA", w.Kind, w.Namespace, w.Name))
+	}
+
+	// 工作负载的暂停注释对 policy 同样生效
+	now := ac.clock.Now()
+	resumeAt, err := ac.pauseHPA(w, newHPA, hpaList, now)
+	if err != nil {
+		return err
+	}
+
+	if err = ac.syncHPA(ctx, newHPA, hpaList); err != nil {
+		if statusErr := ac.updatePolicyStatus(ctx, policy, newHPA.Name, metav1.ConditionFalse, v1alpha1.ReasonFailedSyncHPA, err.Error()); statusErr != nil {
+			klog.Errorf("Failed to update status of policy %s/%s: %v", policy.Namespace, policy.Name, statusErr)
+		}
+		return err
+	}
+	ac.recordPauseChange(w, hpaList, newHPA, resumeAt)
+	ac.requeueAt(w, now, resumeAt)
+
+	return ac.updatePolicyStatus(ctx, policy, newHPA.Name, metav1.ConditionTrue, v1alpha1.ReasonHPASynced,
+		fmt.Sprintf("HPA %s is in sync with the policy", newHPA.Name))
+}
+
+// syncHPA applies the HPA of the workload with server-side apply, the redundant
+// HPAs are removed.
+func (ac *AutoscalerController) syncHPA(ctx context.Context, newHPA *autoscalingv2.HorizontalPodAutoscaler, hpaList []*autoscalingv2.HorizontalPodAutoscaler) error {
+	var oldHPA *autoscalingv2.HorizontalPodAutoscaler
+	if len(hpaList) != 0 {
+		oldHPA = hpaList[0]
+		if err := ac.deleteHPAsInBatch(ctx, hpaList[1:]); err != nil {
 			return err
 		}
+		// 沿用已存在的 HPA 名称，接管的 HPA 名称与控制器生成的不同
+		newHPA.Name = oldHPA.Name
+	}
 
-		if reflect.DeepEqual(oldHPA.Spec, newHPA.Spec) {
+	hpaApply, err := controller.NewHPAApplyConfiguration(newHPA)
+	if err != nil {
+		return err
+	}
+	if oldHPA != nil {
+		// 仅比较控制器管理的字段，包括 labels 和 annotations，其他管理者的字段不影响
+		current, err := autoscalingv2apply.ExtractHorizontalPodAutoscaler(oldHPA, controller.PixiuManager)
+		if err != nil {
+			return err
+		}
+		if equality.Semantic.DeepEqual(current, hpaApply) {
 			klog.V(2).Infof("HPA: %s/%s is not changed", newHPA.Namespace, newHPA.Name)
 			return nil
 		}
-		if _, err = ac.client.AutoscalingV2().HorizontalPodAutoscalers(newHPA.Namespace).Update(context.TODO(), newHPA, metav1.UpdateOptions{}); err != nil {
-			if !errors.IsNotFound(err) {
-				ac.eventRecorder.Eventf(newHPA, v1.EventTypeWarning, "FailedUpdateHPA", fmt.Sprintf("Failed to Recover update HPA %s/%s", newHPA.Namespace, newHPA.Name))
-				klog.Errorf("Failed to update HPA %s/%s %v", newHPA.Namespace, newHPA.Name, err)
-				return err
-			}
+	}
+
+	action, reason := createAction, "CreateHPA"
+	if oldHPA != nil {
+		action, reason = updateAction, "UpdateHPA"
+	}
+	// 旧版本控制器 create/update 的 HPA，字段的所有权需要转移给 PixiuManager
+	legacy := oldHPA != nil && controller.HasLegacyManagedFields(oldHPA)
+	appliedHPA, err := ac.applyHPA(ctx, newHPA, hpaApply, legacy)
+	metrics.HPAOperations.WithLabelValues(action, metrics.Result(err)).Inc()
+	if err != nil {
+		ac.eventRecorder.Eventf(newHPA, v1.EventTypeWarning, "Failed"+reason, fmt.Sprintf("Failed to apply HPA %s/%s: %v", newHPA.Namespace, newHPA.Name, err))
+		return err
+	}
+	if legacy {
+		if err = ac.removeLegacyManagedFields(ctx, appliedHPA); err != nil {
+			return err
 		}
-		ac.eventRecorder.Eventf(newHPA, v1.EventTypeNormal, "UpdateHPA", fmt.Sprintf("Update HPA %s/%s success", newHPA.Namespace, newHPA.Name))
+	}
+	if ac.dryRun {
+		ac.recordDryRun(newHPA, hpaResource, action, newHPA.Namespace, newHPA.Name, oldHPA, newHPA)
+	} else {
+		ac.eventRecorder.Eventf(newHPA, v1.EventTypeNormal, reason, fmt.Sprintf("Apply HPA %s/%s success", newHPA.Namespace, newHPA.Name))
 	}
 
-	return ac.Notify(d)
+	return ac.Notify(ctx, newHPA)
 }
 
-func (ac *AutoscalerController) Notify(d *appsv1.Deployment) error {
-	if !ac.IsCustomMetricHPA(d) {
+// applyHPA applies the HPA with the field manager of controller, the conflicts
+// with the other managers are handled by the force-ownership policy unless
+// force is set.
+func (ac *AutoscalerController) applyHPA(ctx context.Context, newHPA *autoscalingv2.HorizontalPodAutoscaler, hpaApply *autoscalingv2apply.HorizontalPodAutoscalerApplyConfiguration, force bool) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	opts := metav1.ApplyOptions{
+		FieldManager: controller.PixiuManager,
+		Force:        force || ac.forceOwnership == controller.ForceOwnershipAlways,
+		DryRun:       ac.dryRunOption(),
+	}
+	hpa, err := ac.client.AutoscalingV2().HorizontalPodAutoscalers(newHPA.Namespace).Apply(ctx, hpaApply, opts)
+	if err == nil || opts.Force || !errors.IsConflict(err) {
+		return hpa, err
+	}
+
+	// 冲突的字段由其他管理者修改过，例如通过 kubectl edit 修改了 HPA
+	ac.eventRecorder.Eventf(newHPA, v1.EventTypeWarning, "HPAFieldConflict", fmt.Sprintf("HPA %s/%s is modified by other managers: %v", newHPA.Namespace, newHPA.Name, err))
+	if ac.forceOwnership == controller.ForceOwnershipNever {
+		return nil, err
+	}
+	opts.Force = true
+	return ac.client.AutoscalingV2().HorizontalPodAutoscalers(newHPA.Namespace).Apply(ctx, hpaApply, opts)
+}
+
+// removeLegacyManagedFields removes the managed fields entries of the legacy
+// field managers after the HPA is applied, so that the fields removed from the
+// annotations are no longer kept by the legacy managers.
+func (ac *AutoscalerController) removeLegacyManagedFields(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			// resourceVersion 保证 managedFields 未被其他请求修改
+			"resourceVersion": hpa.ResourceVersion,
+			"managedFields":   controller.RemoveLegacyManagedFields(hpa.ManagedFields),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = ac.client.AutoscalingV2().HorizontalPodAutoscalers(hpa.Namespace).Patch(ctx, hpa.Name, types.MergePatchType, patch, metav1.PatchOptions{DryRun: ac.dryRunOption()})
+	if err != nil {
+		return fmt.Errorf("failed to remove the legacy managed fields of HPA %s/%s: %v", hpa.Namespace, hpa.Name, err)
+	}
+	klog.V(2).Infof("HPA %s/%s is taken over from the legacy field managers", hpa.Namespace, hpa.Name)
+	return nil
+}
+
+// dryRunOption returns the dry-run option of the write requests.
+func (ac *AutoscalerController) dryRunOption() []string {
+	if ac.dryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+// recordDryRun logs the action intended in dry-run mode with the diff of the
+// object, and exposes it as a metric and an event.
+func (ac *AutoscalerController) recordDryRun(obj runtime.Object, resource, action, namespace, name string, oldObj, newObj interface{}) {
+	klog.InfoS("Dry run", "action", action, "resource", resource, "object", klog.KRef(namespace, name), "diff", diff.ObjectReflectDiff(oldObj, newObj))
+	metrics.DryRunActions.WithLabelValues(resource, action).Inc()
+	ac.eventRecorder.Eventf(obj, v1.EventTypeNormal, "DryRun", fmt.Sprintf("Would %s %s %s/%s", action, resource, namespace, name))
+}
+
+// updatePoliciesStatus sets the Ready condition of the policies.
+func (ac *AutoscalerController) updatePoliciesStatus(ctx context.Context, policies []*v1alpha1.AutoscalingPolicy, hpaName string, status metav1.ConditionStatus, reason, message string) error {
+	for _, policy := range policies {
+		if err := ac.updatePolicyStatus(ctx, policy, hpaName, status, reason, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ac *AutoscalerController) updatePolicyStatus(ctx context.Context, policy *v1alpha1.AutoscalingPolicy, hpaName string, status metav1.ConditionStatus, reason, message string) error {
+	newPolicy := policy.DeepCopy()
+	newPolicy.Status.ObservedGeneration = policy.Generation
+	newPolicy.Status.HPAName = hpaName
+	meta.SetStatusCondition(&newPolicy.Status.Conditions, metav1.Condition{
+		Type:               v1alpha1.PolicyReady,
+		Status:             status,
+		ObservedGeneration: policy.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+	if reflect.DeepEqual(policy.Status, newPolicy.Status) {
 		return nil
 	}
 
-	// TODO: 抽成变量
-	ns := "pixiu-system"
-	cm, err := ac.cmLister.ConfigMaps(ns).Get(controller.DesireConfigMapName)
+	u, err := controller.ConvertFromPolicy(newPolicy)
 	if err != nil {
 		return err
 	}
-	patchPayloadTemplate :=
-		`[{
-        "op": "%s",
-        "path": "/metadata/annotations",
-        "value": %s
-    }]`
-	op := "replace"
-	if len(cm.Annotations) == 0 {
-		cm.Annotations = map[string]string{}
-		op = "add"
+	if _, err = ac.policyClient.Namespace(policy.Namespace).UpdateStatus(ctx, u, metav1.UpdateOptions{DryRun: ac.dryRunOption()}); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		klog.Errorf("Failed to update status of policy %s/%s: %v", policy.Namespace, policy.Name, err)
+		return err
+	}
+	if ac.dryRun {
+		ac.recordDryRun(u, policyStatusResource, updateAction, policy.Namespace, policy.Name, policy.Status, newPolicy.Status)
 	}
 
-	cm.Annotations[controller.NotifyAt] = time.Now().Format("2006-01-02T15:04:05Z")
-	raw, err := json.Marshal(cm.Annotations)
+	return nil
+}
+
+// Notify triggers the resync of the adapter config if the HPA uses custom metrics.
+func (ac *AutoscalerController) Notify(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler) error {
+	if hpa.Labels[controller.PrometheusCustomMetric] != "true" {
+		return nil
+	}
+
+	ns := ac.adapter.Namespace
+	if _, err := ac.cmLister.ConfigMaps(ns).Get(ac.adapter.ConfigMapName); err != nil {
+		return err
+	}
+
+	// 仅修改 notifyAt 注释，避免覆盖 configmap 上的其他注释（例如控制器生成规则的记录）
+	patchPayload, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				controller.NotifyAt: time.Now().Format("2006-01-02T15:04:05Z"),
+			},
+		},
+	})
 	if err != nil {
 		return err
 	}
-	patchPayload := fmt.Sprintf(patchPayloadTemplate, op, raw)
-	if _, err = ac.client.CoreV1().ConfigMaps(ns).Patch(context.Background(), controller.DesireConfigMapName, types.JSONPatchType, []byte(patchPayload), metav1.PatchOptions{}); err != nil {
+	cm, err := ac.client.CoreV1().ConfigMaps(ns).Patch(ctx, ac.adapter.ConfigMapName, types.MergePatchType, patchPayload, metav1.PatchOptions{DryRun: ac.dryRunOption()})
+	if err != nil {
 		klog.Errorf("failed to patch configmap: %v", err)
 		return err
 	}
+	if ac.dryRun {
+		ac.recordDryRun(cm, configMapResource, patchAction, ns, ac.adapter.ConfigMapName, nil, string(patchPayload))
+	}
 
 	return nil
 }
 
-func (ac *AutoscalerController) deleteHPAsInBatch(hpaList []*autoscalingv2.HorizontalPodAutoscaler) error {
+func (ac *AutoscalerController) deleteHPAsInBatch(ctx context.Context, hpaList []*autoscalingv2.HorizontalPodAutoscaler) error {
 	if len(hpaList) == 0 {
 		return nil
 	}
 	for _, hpa := range hpaList {
-		if err := ac.client.AutoscalingV2().HorizontalPodAutoscalers(hpa.Namespace).Delete(context.TODO(), hpa.Name, metav1.DeleteOptions{}); err != nil {
-			if !errors.IsNotFound(err) {
-				ac.eventRecorder.Eventf(hpa, v1.EventTypeWarning, "FailedDeleteHPA", fmt.Sprintf("Failed to delete HPA %s/%s", hpa.Namespace, hpa.Name))
-				return err
-			}
+		err := ac.client.AutoscalingV2().HorizontalPodAutoscalers(hpa.Namespace).Delete(ctx, hpa.Name, metav1.DeleteOptions{DryRun: ac.dryRunOption()})
+		if errors.IsNotFound(err) {
+			err = nil
+		}
+		metrics.HPAOperations.WithLabelValues(deleteAction, metrics.Result(err)).Inc()
+		if err != nil {
+			ac.eventRecorder.Eventf(hpa, v1.EventTypeWarning, "FailedDeleteHPA", fmt.Sprintf("Failed to delete HPA %s/%s", hpa.Namespace, hpa.Name))
+			return err
+		}
+		if ac.dryRun {
+			ac.recordDryRun(hpa, hpaResource, deleteAction, hpa.Namespace, hpa.Name, hpa, nil)
+			continue
 		}
 		ac.eventRecorder.Eventf(hpa, v1.EventTypeNormal, "DeleteHPA", fmt.Sprintf("Delete HPA %s/%s", hpa.Namespace, hpa.Name))
 	}
@@ -461,8 +1261,8 @@ func (ac *AutoscalerController) deleteHPAsInBatch(hpaList []*autoscalingv2.Horiz
 	return nil
 }
 
-func (ac *AutoscalerController) getHPAsForDeployment(d *appsv1.Deployment) ([]*autoscalingv2.HorizontalPodAutoscaler, error) {
-	hpaList, err := ac.hpaLister.HorizontalPodAutoscalers(d.Namespace).List(labels.Everything())
+func (ac *AutoscalerController) getHPAsForWorkload(w *controller.Workload) ([]*autoscalingv2.HorizontalPodAutoscaler, error) {
+	hpaList, err := ac.hpaLister.HorizontalPodAutoscalers(w.Namespace).List(labels.Everything())
 	if err != nil {
 		return nil, err
 	}
@@ -473,7 +1273,7 @@ func (ac *AutoscalerController) getHPAsForDeployment(d *appsv1.Deployment) ([]*a
 		if controllerRef == nil {
 			continue
 		}
-		if d.UID == controllerRef.UID && controllerRef.Kind == controller.Deployment && controllerRef.Name == d.Name {
+		if w.UID == controllerRef.UID && controllerRef.Kind == w.Kind && controllerRef.Name == w.Name {
 			wanted = append(wanted, hpa)
 		}
 	}
@@ -481,16 +1281,69 @@ func (ac *AutoscalerController) getHPAsForDeployment(d *appsv1.Deployment) ([]*a
 	return wanted, nil
 }
 
-func (ac *AutoscalerController) enqueue(deployment *appsv1.Deployment) {
-	key, err := controller.KeyFunc(deployment)
+// getUnmanagedHPAsForWorkload returns the HPAs which target the workload but
+// are not controlled by it, sorted by creation time.
+func (ac *AutoscalerController) getUnmanagedHPAsForWorkload(w *controller.Workload) ([]*autoscalingv2.HorizontalPodAutoscaler, error) {
+	hpaList, err := ac.hpaLister.HorizontalPodAutoscalers(w.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+
+	var unmanaged []*autoscalingv2.HorizontalPodAutoscaler
+	for _, hpa := range hpaList {
+		if !controller.IsHPATargetWorkload(hpa, w) {
+			continue
+		}
+		if controllerRef := metav1.GetControllerOf(hpa); controllerRef != nil && controllerRef.UID == w.UID {
+			continue
+		}
+		unmanaged = append(unmanaged, hpa)
+	}
+	sort.Slice(unmanaged, func(i, j int) bool {
+		if unmanaged[i].CreationTimestamp.Equal(&unmanaged[j].CreationTimestamp) {
+			return unmanaged[i].Name < unmanaged[j].Name
+		}
+		return unmanaged[i].CreationTimestamp.Before(&unmanaged[j].CreationTimestamp)
+	})
+
+	return unmanaged, nil
+}
+
+// hpaNames returns the names of the HPAs joined by comma.
+func hpaNames(hpaList []*autoscalingv2.HorizontalPodAutoscaler) string {
+	names := make([]string, 0, len(hpaList))
+	for _, hpa := range hpaList {
+		names = append(names, hpa.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (ac *AutoscalerController) enqueue(gk schema.GroupKind, obj metav1.Object) {
+	if !ac.inScope(obj.GetNamespace()) {
+		return
+	}
+	key, err := controller.WorkloadKeyFunc(gk, obj)
 	if err != nil {
-		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %#v: %v", deployment, err))
+		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %#v: %v", obj, err))
 		return
 	}
 
 	ac.queue.Add(key)
 }
 
+func (ac *AutoscalerController) enqueueAfter(gk schema.GroupKind, obj metav1.Object, duration time.Duration) {
+	if !ac.inScope(obj.GetNamespace()) {
+		return
+	}
+	key, err := controller.WorkloadKeyFunc(gk, obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get key for object %#v: %v", obj, err))
+		return
+	}
+
+	ac.queue.AddAfter(key, duration)
+}
+
 func (ac *AutoscalerController) enqueueCM(cm *corev1.ConfigMap) {
 	key, err := controller.KeyFunc(cm)
 	if err != nil {
@@ -502,36 +1355,46 @@ func (ac *AutoscalerController) enqueueCM(cm *corev1.ConfigMap) {
 }
 
 // worker runs a worker thread that just dequeues items, processes then, and marks them done.
-func (ac *AutoscalerController) worker() {
-	for ac.processNextWorkItem() {
+func (ac *AutoscalerController) worker(ctx context.Context) {
+	for ac.processNextWorkItem(ctx) {
 	}
 }
 
-func (ac *AutoscalerController) configMapWorker() {
-	for ac.processNextConfigMapWorkItem() {
+func (ac *AutoscalerController) configMapWorker(ctx context.Context) {
+	for ac.processNextConfigMapWorkItem(ctx) {
 	}
 }
 
-func (ac *AutoscalerController) processNextWorkItem() bool {
+func (ac *AutoscalerController) processNextWorkItem(ctx context.Context) bool {
 	key, quit := ac.queue.Get()
 	if quit {
 		return false
 	}
 	defer ac.queue.Done(key)
 
-	err := ac.syncHandler(key.(string))
+	ac.heartbeats.start(autoscalerHandler, key)
+	defer ac.heartbeats.done(autoscalerHandler, key)
+
+	startTime := time.Now()
+	err := ac.syncHandler(ctx, key.(string))
+	metrics.SyncDuration.WithLabelValues(autoscalerHandler, metrics.Result(err)).Observe(time.Since(startTime).Seconds())
 	ac.handleErr(err, key)
 	return true
 }
 
-func (ac *AutoscalerController) processNextConfigMapWorkItem() bool {
+func (ac *AutoscalerController) processNextConfigMapWorkItem(ctx context.Context) bool {
 	key, quit := ac.cmQueue.Get()
 	if quit {
 		return false
 	}
 	defer ac.cmQueue.Done(key)
 
-	err := ac.syncConfigMapHandler(key.(string))
+	ac.heartbeats.start(configMapHandler, key)
+	defer ac.heartbeats.done(configMapHandler, key)
+
+	startTime := time.Now()
+	err := ac.syncConfigMapHandler(ctx, key.(string))
+	metrics.SyncDuration.WithLabelValues(configMapHandler, metrics.Result(err)).Observe(time.Since(startTime).Seconds())
 	ac.handleConfigMapErr(err, key)
 	return true
 }
@@ -574,7 +1437,7 @@ func (ac *AutoscalerController) handleConfigMapErr(err error, key interface{}) {
 func (ac *AutoscalerController) addDeployment(obj interface{}) {
 	d := obj.(*appsv1.Deployment)
 	klog.V(4).InfoS("Adding deployment", "deployment", klog.KObj(d))
-	ac.enqueueDeployment(d)
+	ac.enqueueWorkload(appsv1.SchemeGroupVersion.WithKind(controller.Deployment).GroupKind(), d)
 }
 
 func (ac *AutoscalerController) updateDeployment(old, cur interface{}) {
@@ -591,7 +1454,7 @@ func (ac *AutoscalerController) updateDeployment(old, cur interface{}) {
 	}
 	klog.V(4).InfoS("Updating deployment", "deployment", klog.KObj(oldD))
 
-	ac.enqueueDeployment(curD)
+	ac.enqueueWorkload(appsv1.SchemeGroupVersion.WithKind(controller.Deployment).GroupKind(), curD)
 }
 
 func (ac *AutoscalerController) deleteDeployment(obj interface{}) {
@@ -609,7 +1472,94 @@ func (ac *AutoscalerController) deleteDeployment(obj interface{}) {
 		}
 	}
 	klog.V(4).InfoS("Deleting deployment", "deployment", klog.KObj(d))
-	ac.enqueueDeployment(d)
+	ac.enqueueWorkload(appsv1.SchemeGroupVersion.WithKind(controller.Deployment).GroupKind(), d)
+}
+
+// This functions just wrap Handler StatefulSet Events for improve the readability of codes
+func (ac *AutoscalerController) addStatefulSet(obj interface{}) {
+	s := obj.(*appsv1.StatefulSet)
+	klog.V(4).InfoS("Adding statefulset", "statefulset", klog.KObj(s))
+	ac.enqueueWorkload(appsv1.SchemeGroupVersion.WithKind(controller.StatefulSet).GroupKind(), s)
+}
+
+func (ac *AutoscalerController) updateStatefulSet(old, cur interface{}) {
+	oldS := old.(*appsv1.StatefulSet)
+	curS := cur.(*appsv1.StatefulSet)
+
+	if oldS.ResourceVersion == curS.ResourceVersion {
+		return
+	}
+	// statefulset 的注释未变化，则HPA不变
+	if reflect.DeepEqual(oldS.Annotations, curS.Annotations) {
+		return
+	}
+	klog.V(4).InfoS("Updating statefulset", "statefulset", klog.KObj(oldS))
+
+	ac.enqueueWorkload(appsv1.SchemeGroupVersion.WithKind(controller.StatefulSet).GroupKind(), curS)
+}
+
+func (ac *AutoscalerController) deleteStatefulSet(obj interface{}) {
+	s, ok := obj.(*appsv1.StatefulSet)
+	if !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("couldn't get object from tombstone %#v", obj))
+			return
+		}
+		s, ok = tombstone.Obj.(*appsv1.StatefulSet)
+		if !ok {
+			utilruntime.HandleError(fmt.Errorf("tombstone contained object that is not a StatefulSet %#v", obj))
+			return
+		}
+	}
+	klog.V(4).InfoS("Deleting statefulset", "statefulset", klog.KObj(s))
+	ac.enqueueWorkload(appsv1.SchemeGroupVersion.WithKind(controller.StatefulSet).GroupKind(), s)
+}
+
+// This functions just wrap Handler scale target Events for improve the readability of codes
+func (ac *AutoscalerController) addScaleTarget(gk schema.GroupKind, obj interface{}) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get object meta for %#v: %v", obj, err))
+		return
+	}
+	klog.V(4).InfoS("Adding scale target", "kind", gk.String(), "object", klog.KObj(accessor))
+	ac.enqueueWorkload(gk, accessor)
+}
+
+func (ac *AutoscalerController) updateScaleTarget(gk schema.GroupKind, old, cur interface{}) {
+	oldAccessor, err := meta.Accessor(old)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get object meta for %#v: %v", old, err))
+		return
+	}
+	curAccessor, err := meta.Accessor(cur)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get object meta for %#v: %v", cur, err))
+		return
+	}
+
+	if oldAccessor.GetResourceVersion() == curAccessor.GetResourceVersion() {
+		return
+	}
+	if reflect.DeepEqual(oldAccessor.GetAnnotations(), curAccessor.GetAnnotations()) {
+		return
+	}
+	klog.V(4).InfoS("Updating scale target", "kind", gk.String(), "object", klog.KObj(curAccessor))
+	ac.enqueueWorkload(gk, curAccessor)
+}
+
+func (ac *AutoscalerController) deleteScaleTarget(gk schema.GroupKind, obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(fmt.Errorf("couldn't get object meta for %#v: %v", obj, err))
+		return
+	}
+	klog.V(4).InfoS("Deleting scale target", "kind", gk.String(), "object", klog.KObj(accessor))
+	ac.enqueueWorkload(gk, accessor)
 }
 
 func (ac *AutoscalerController) addHPA(obj interface{}) {
@@ -623,14 +1573,17 @@ func (ac *AutoscalerController) addHPA(obj interface{}) {
 
 	// 如果存在 OwnerReference， 则直接获取上级资源
 	if controllerRef := metav1.GetControllerOf(hpa); controllerRef != nil {
-		d := ac.resolveControllerRef(hpa.Namespace, controllerRef)
-		if d == nil {
+		owner := ac.resolveControllerRef(hpa.Namespace, controllerRef)
+		if owner == nil {
 			return
 		}
 		klog.V(4).InfoS("HPA added", "hpa", klog.KObj(hpa))
-		ac.enqueueDeployment(d)
+		ac.enqueueWorkload(groupKindForRef(controllerRef), owner)
 		return
 	}
+
+	// 不受控制的 HPA，同步其伸缩的工作负载以处理冲突
+	ac.enqueueScaleTarget(hpa)
 }
 
 // updateHPA figures out what HPA(s) is updated and wake them up. old and cur must be *autoscalingv2.HorizontalPodAutoscaler types.
@@ -648,16 +1601,22 @@ func (ac *AutoscalerController) updateHPA(old, cur interface{}) {
 	controllerRefChanged := !reflect.DeepEqual(curControllerRef, oldControllerRef)
 	if controllerRefChanged && oldControllerRef != nil {
 		// hpa 的 ControllerRef 发生了变化，同步老的 controller
-		if d := ac.resolveControllerRef(oldHPA.Namespace, oldControllerRef); d != nil {
-			ac.enqueueDeployment(d)
+		if owner := ac.resolveControllerRef(oldHPA.Namespace, oldControllerRef); owner != nil {
+			ac.enqueueWorkload(groupKindForRef(oldControllerRef), owner)
 		}
 	}
 
 	if curControllerRef != nil {
-		if d := ac.resolveControllerRef(curHPA.Namespace, curControllerRef); d != nil {
-			ac.enqueueDeployment(d)
+		if owner := ac.resolveControllerRef(curHPA.Namespace, curControllerRef); owner != nil {
+			ac.enqueueWorkload(groupKindForRef(curControllerRef), owner)
 		}
+		return
+	}
+
+	if !reflect.DeepEqual(oldHPA.Spec.ScaleTargetRef, curHPA.Spec.ScaleTargetRef) {
+		ac.enqueueScaleTarget(oldHPA)
 	}
+	ac.enqueueScaleTarget(curHPA)
 }
 
 func (ac *AutoscalerController) deleteHPA(obj interface{}) {
@@ -677,14 +1636,160 @@ func (ac *AutoscalerController) deleteHPA(obj interface{}) {
 
 	controllerRef := metav1.GetControllerOf(hpa)
 	if controllerRef == nil {
+		ac.enqueueScaleTarget(hpa)
 		return
 	}
-	d := ac.resolveControllerRef(hpa.Namespace, controllerRef)
-	if d == nil {
+	owner := ac.resolveControllerRef(hpa.Namespace, controllerRef)
+	if owner == nil {
 		return
 	}
 	klog.V(0).Infof("Deleting HPA %s/%s", hpa.Namespace, hpa.Name)
-	ac.enqueueDeployment(d)
+	ac.enqueueWorkload(groupKindForRef(controllerRef), owner)
+}
+
+// This functions just wrap Handler AutoscalingPolicy Events for improve the readability of codes
+func (ac *AutoscalerController) addPolicy(obj interface{}) {
+	policy, err := controller.ConvertToPolicy(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	klog.V(4).InfoS("Adding policy", "policy", klog.KObj(policy))
+	ac.enqueuePolicyTarget(policy)
+}
+
+func (ac *AutoscalerController) updatePolicy(old, cur interface{}) {
+	oldPolicy, err := controller.ConvertToPolicy(old)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	curPolicy, err := controller.ConvertToPolicy(cur)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+
+	if oldPolicy.ResourceVersion == curPolicy.ResourceVersion {
+		return
+	}
+	// 仅 status 变化时 generation 不变，无需同步
+	if oldPolicy.Generation == curPolicy.Generation && curPolicy.DeletionTimestamp == nil {
+		return
+	}
+	klog.V(4).InfoS("Updating policy", "policy", klog.KObj(curPolicy))
+
+	if !reflect.DeepEqual(oldPolicy.Spec.TargetRef, curPolicy.Spec.TargetRef) {
+		// 目标工作负载发生了变化，同步原来的工作负载
+		ac.enqueuePolicyTarget(oldPolicy)
+	}
+	ac.enqueuePolicyTarget(curPolicy)
+}
+
+func (ac *AutoscalerController) deletePolicy(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	policy, err := controller.ConvertToPolicy(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	klog.V(4).InfoS("Deleting policy", "policy", klog.KObj(policy))
+	ac.enqueuePolicyTarget(policy)
+}
+
+// enqueuePolicyTarget enqueues the workload targeted by the policy.
+func (ac *AutoscalerController) enqueuePolicyTarget(policy *v1alpha1.AutoscalingPolicy) {
+	ac.enqueueWorkload(controller.PolicyTargetGroupKind(policy), &metav1.ObjectMeta{
+		Namespace: policy.Namespace,
+		Name:      policy.Spec.TargetRef.Name,
+	})
+}
+
+func (ac *AutoscalerController) addNamespace(obj interface{}) {
+	ns := obj.(*corev1.Namespace)
+	// 未使用 namespaceSelector 时，新增命名空间中的工作负载由其自身的事件触发同步
+	if !ac.scope.SelectsNamespaces() {
+		return
+	}
+	klog.V(4).InfoS("Adding namespace", "namespace", klog.KObj(ns))
+
+	ac.forEachWorkloadInNamespace(ns.Name, ac.enqueueWorkload)
+}
+
+func (ac *AutoscalerController) updateNamespace(old, cur interface{}) {
+	oldNS := old.(*corev1.Namespace)
+	curNS := cur.(*corev1.Namespace)
+
+	if oldNS.ResourceVersion == curNS.ResourceVersion {
+		return
+	}
+	// 默认 hpa 注释未变化，则其中工作负载的 HPA 不变
+	if reflect.DeepEqual(controller.NamespaceDefaults(oldNS), controller.NamespaceDefaults(curNS)) {
+		return
+	}
+	klog.V(4).InfoS("Updating namespace defaults", "namespace", klog.KObj(curNS))
+
+	ac.enqueueWorkloadsInNamespace(curNS.Name)
+}
+
+// enqueueWorkloadsInNamespace enqueues the workloads in the namespace which
+// inherit the namespace defaults.
+func (ac *AutoscalerController) enqueueWorkloadsInNamespace(namespace string) {
+	ac.forEachWorkloadInNamespace(namespace, func(gk schema.GroupKind, obj metav1.Object) {
+		if obj.GetAnnotations()[controller.InheritNamespaceDefaults] == "false" {
+			return
+		}
+		ac.enqueueWorkload(gk, obj)
+	})
+}
+
+// forEachWorkloadInNamespace calls fn for each cached workload in the namespace.
+func (ac *AutoscalerController) forEachWorkloadInNamespace(namespace string, fn func(gk schema.GroupKind, obj metav1.Object)) {
+	deployments, err := ac.dLister.Deployments(namespace).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+	}
+	for _, d := range deployments {
+		fn(appsv1.SchemeGroupVersion.WithKind(controller.Deployment).GroupKind(), d)
+	}
+
+	statefulSets, err := ac.sLister.StatefulSets(namespace).List(labels.Everything())
+	if err != nil {
+		utilruntime.HandleError(err)
+	}
+	for _, s := range statefulSets {
+		fn(appsv1.SchemeGroupVersion.WithKind(controller.StatefulSet).GroupKind(), s)
+	}
+
+	for gk, target := range ac.scaleTargets {
+		objs, err := target.Informer.Lister().ByNamespace(namespace).List(labels.Everything())
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		for _, obj := range objs {
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				utilruntime.HandleError(err)
+				continue
+			}
+			fn(gk, accessor)
+		}
+	}
+}
+
+// isAdapterConfigMap returns true if the object is the configmap of prometheus adapter.
+func (ac *AutoscalerController) isAdapterConfigMap(obj interface{}) bool {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return false
+	}
+	return cm.Namespace == ac.adapter.Namespace && cm.Name == ac.adapter.ConfigMapName
 }
 
 func (ac *AutoscalerController) addConfigMap(obj interface{}) {
@@ -700,11 +1805,7 @@ func (ac *AutoscalerController) updateConfigMap(old, cur interface{}) {
 	if oldCM.ResourceVersion == curCM.ResourceVersion {
 		return
 	}
-	//if reflect.DeepEqual(oldCM.Data[controller.ExternalRuleKey], curCM.Data[controller.ExternalRuleKey]) {
-	//	return
-	//}
-	//klog.V(4).InfoS("Updating configmap", "configmap", klog.KObj(oldCM))
-
+	klog.V(4).InfoS("Updating configmap", "configmap", klog.KObj(curCM))
 	ac.enqueueConfigMap(curCM)
 }
 
@@ -722,20 +1823,52 @@ func (ac *AutoscalerController) deleteConfigMap(obj interface{}) {
 			return
 		}
 	}
-	klog.V(4).InfoS("Adding configmap", "configmap", klog.KObj(cm))
+	klog.V(4).InfoS("Deleting configmap", "configmap", klog.KObj(cm))
 	ac.enqueueConfigMap(cm)
 }
 
-func (ac *AutoscalerController) resolveControllerRef(namespace string, controllerRef *metav1.OwnerReference) *appsv1.Deployment {
-	if controllerRef.Kind != controller.Deployment {
-		return nil
+// resolveControllerRef returns the workload referenced by a ControllerRef,
+// or nil if the ControllerRef could not be resolved to a matching workload
+// of the correct Kind.
+func (ac *AutoscalerController) resolveControllerRef(namespace string, controllerRef *metav1.OwnerReference) metav1.Object {
+	var (
+		obj metav1.Object
+		err error
+	)
+	switch groupKindForRef(controllerRef) {
+	case appsv1.SchemeGroupVersion.WithKind(controller.Deployment).GroupKind():
+		obj, err = ac.dLister.Deployments(namespace).Get(controllerRef.Name)
+	case appsv1.SchemeGroupVersion.WithKind(controller.StatefulSet).GroupKind():
+		obj, err = ac.sLister.StatefulSets(namespace).Get(controllerRef.Name)
+	default:
+		target, ok := ac.scaleTargets[groupKindForRef(controllerRef)]
+		if !ok {
+			return nil
+		}
+		runtimeObj, getErr := target.Informer.Lister().ByNamespace(namespace).Get(controllerRef.Name)
+		if getErr != nil {
+			return nil
+		}
+		obj, err = meta.Accessor(runtimeObj)
 	}
-	d, err := ac.dLister.Deployments(namespace).Get(controllerRef.Name)
 	if err != nil {
 		return nil
 	}
-	if d.UID != controllerRef.UID {
+	if obj.GetUID() != controllerRef.UID {
 		return nil
 	}
-	return d
+	return obj
+}
+
+// enqueueScaleTarget enqueues the workload which the unmanaged HPA targets.
+func (ac *AutoscalerController) enqueueScaleTarget(hpa *autoscalingv2.HorizontalPodAutoscaler) {
+	gk := controller.HPATargetGroupKind(hpa)
+	if !ac.isSupportedGroupKind(gk) {
+		return
+	}
+	ac.enqueueWorkload(gk, &metav1.ObjectMeta{Namespace: hpa.Namespace, Name: hpa.Spec.ScaleTargetRef.Name})
+}
+
+func groupKindForRef(controllerRef *metav1.OwnerReference) schema.GroupKind {
+	return schema.FromAPIVersionAndKind(controllerRef.APIVersion, controllerRef.Kind).GroupKind()
 }
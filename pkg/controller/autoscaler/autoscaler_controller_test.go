@@ -15,3 +15,1116 @@ limitations under the License.
 */
 
 package autoscaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	metadatafake "k8s.io/client-go/metadata/fake"
+	"k8s.io/client-go/metadata/metadatainformer"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/component-base/metrics/testutil"
+	testingclock "k8s.io/utils/clock/testing"
+	utilpointer "k8s.io/utils/pointer"
+
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/apis/pixiu/v1alpha1"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller/metrics"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller/notifier"
+)
+
+func newTestController(t *testing.T, objects ...*appsv1.Deployment) (*AutoscalerController, *fake.Clientset, informers.SharedInformerFactory) {
+	client := fake.NewSimpleClientset()
+	factory := informers.NewSharedInformerFactory(client, 0)
+	dInformer := factory.Apps().V1().Deployments()
+
+	ac, err := NewAutoscalerController(
+		dInformer,
+		factory.Apps().V1().StatefulSets(),
+		factory.Autoscaling().V2().HorizontalPodAutoscalers(),
+		factory.Core().V1().ConfigMaps(),
+		factory.Core().V1().Namespaces(),
+		client,
+		controller.PrometheusAdapter{},
+		nil)
+	if err != nil {
+		t.Fatalf("failed to create controller: %v", err)
+	}
+	ac.eventRecorder = record.NewFakeRecorder(10)
+	client.PrependReactor("patch", "horizontalpodautoscalers", applyHPAReactor(client.Tracker()))
+	for _, d := range objects {
+		if err = dInformer.Informer().GetIndexer().Add(d); err != nil {
+			t.Fatalf("failed to add deployment: %v", err)
+		}
+	}
+	return ac, client, factory
+}
+
+var deploymentGroupKind = appsv1.SchemeGroupVersion.WithKind(controller.Deployment).GroupKind()
+
+// newDeployment returns a deployment in the default namespace with the hpa annotations.
+func newDeployment(name string, annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "default",
+			UID:         types.UID(name + "-7a2b9f0e-2c1d-4e5f-8a9b"),
+			Annotations: annotations,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{Containers: []v1.Container{{Name: "nginx", Image: "nginx"}}},
+			},
+		},
+	}
+}
+
+// workloadKey returns the queue key of the workload.
+func workloadKey(t *testing.T, gk schema.GroupKind, obj metav1.Object) string {
+	key, err := controller.WorkloadKeyFunc(gk, obj)
+	if err != nil {
+		t.Fatalf("failed to get key: %v", err)
+	}
+	return key
+}
+
+// applyHPAReactor emulates the server-side apply of HPAs, which is not supported
+// by the fake clientset. The fields of the controller are replaced as a whole.
+func applyHPAReactor(tracker clienttesting.ObjectTracker) clienttesting.ReactionFunc {
+	return func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patchAction := action.(clienttesting.PatchAction)
+		if patchAction.GetPatchType() != types.ApplyPatchType {
+			return false, nil, nil
+		}
+
+		gvr := autoscalingv2.SchemeGroupVersion.WithResource("horizontalpodautoscalers")
+		hpa := &autoscalingv2.HorizontalPodAutoscaler{}
+		obj, err := tracker.Get(gvr, patchAction.GetNamespace(), patchAction.GetName())
+		exists := err == nil
+		if exists {
+			hpa = obj.(*autoscalingv2.HorizontalPodAutoscaler).DeepCopy()
+		} else if !errors.IsNotFound(err) {
+			return true, nil, err
+		}
+		applied := &autoscalingv2.HorizontalPodAutoscaler{}
+		if err = json.Unmarshal(patchAction.GetPatch(), applied); err != nil {
+			return true, nil, err
+		}
+		hpa.Name, hpa.Namespace = applied.Name, applied.Namespace
+		hpa.Labels, hpa.Annotations, hpa.OwnerReferences = applied.Labels, applied.Annotations, applied.OwnerReferences
+		hpa.Spec = applied.Spec
+
+		if exists {
+			err = tracker.Update(gvr, hpa, hpa.Namespace)
+		} else {
+			err = tracker.Create(gvr, hpa, hpa.Namespace)
+		}
+		return true, hpa, err
+	}
+}
+
+// syncHPAsToCache adds the HPAs in the fake client to the informer cache.
+func syncHPAsToCache(t *testing.T, client *fake.Clientset, factory informers.SharedInformerFactory) []autoscalingv2.HorizontalPodAutoscaler {
+	hpaList, err := client.AutoscalingV2().HorizontalPodAutoscalers("default").List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list hpa: %v", err)
+	}
+	indexer := factory.Autoscaling().V2().HorizontalPodAutoscalers().Informer().GetIndexer()
+	for i := range hpaList.Items {
+		if err = indexer.Update(&hpaList.Items[i]); err != nil {
+			t.Fatalf("failed to add hpa: %v", err)
+		}
+	}
+	return hpaList.Items
+}
+
+// popEvents returns the reasons of the recorded events.
+func popEvents(recorder record.EventRecorder) []string {
+	var reasons []string
+	events := recorder.(*record.FakeRecorder).Events
+	for len(events) != 0 {
+		reasons = append(reasons, strings.Fields(<-events)[1])
+	}
+	return reasons
+}
+
+func hasEvent(reasons []string, reason string) bool {
+	for _, r := range reasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSyncTimedReplicas(t *testing.T) {
+	type step struct {
+		// advance 为同步前时钟前进的时间
+		advance  time.Duration
+		min, max int32
+		paused   bool
+		schedule string
+		requeue  []time.Duration
+		event    string
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		replicas    *int32
+		// 2021-10-18 为周一
+		now   time.Time
+		steps []step
+	}{
+		{
+			name: "requeue at schedule boundary",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+				"schedule.hpa.caoyingjunz.io/workday.start":       "0 9 * * 1-5",
+				"schedule.hpa.caoyingjunz.io/workday.end":         "0 18 * * 1-5",
+				"schedule.hpa.caoyingjunz.io/workday.minReplicas": "4",
+			},
+			now: time.Date(2021, 10, 18, 10, 30, 0, 0, time.UTC),
+			steps: []step{
+				{min: 4, max: 6, schedule: "workday", requeue: []time.Duration{7*time.Hour + 30*time.Minute}, event: "ScheduleActivated"},
+			},
+		},
+		{
+			name: "pause and resume",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/maxReplicas":                  "10",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+				"hpa.caoyingjunz.io/paused":                       "true",
+				"hpa.caoyingjunz.io/pausedUntil":                  "2021-10-18T12:00:00Z",
+			},
+			replicas: utilpointer.Int32Ptr(3),
+			now:      time.Date(2021, 10, 18, 11, 0, 0, 0, time.UTC),
+			steps: []step{
+				// 暂停期间固定为当前副本数
+				{min: 3, max: 3, paused: true, requeue: []time.Duration{time.Hour}, event: "AutoscalingPaused"},
+				// 到期后自动恢复
+				{advance: time.Hour, min: 1, max: 10, event: "AutoscalingResumed"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newDeployment("test1", test.annotations)
+			d.Spec.Replicas = test.replicas
+			ac, client, factory := newTestController(t, d)
+
+			fakeClock := testingclock.NewFakeClock(test.now)
+			ac.clock = fakeClock
+			var requeued []time.Duration
+			ac.enqueueWorkloadAfter = func(gk schema.GroupKind, obj metav1.Object, duration time.Duration) {
+				requeued = append(requeued, duration)
+			}
+
+			key := workloadKey(t, deploymentGroupKind, d)
+			for i, step := range test.steps {
+				fakeClock.Step(step.advance)
+				requeued = nil
+				if err := ac.syncAutoscalers(context.TODO(), key); err != nil {
+					t.Fatalf("step %d: failed to sync: %v", i, err)
+				}
+
+				hpaList := syncHPAsToCache(t, client, factory)
+				if len(hpaList) != 1 {
+					t.Fatalf("step %d: expected 1 hpa, got %d", i, len(hpaList))
+				}
+				hpa := &hpaList[0]
+				if *hpa.Spec.MinReplicas != step.min || hpa.Spec.MaxReplicas != step.max {
+					t.Errorf("step %d: expected replicas [%d, %d], got [%d, %d]", i, step.min, step.max, *hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+				}
+				if paused := controller.IsHPAPaused(hpa); paused != step.paused {
+					t.Errorf("step %d: expected paused %v, got %v", i, step.paused, paused)
+				}
+				if schedule := hpa.Annotations[controller.ActiveScheduleAnnotation]; schedule != step.schedule {
+					t.Errorf("step %d: expected active schedule %q, got %q", i, step.schedule, schedule)
+				}
+				if !reflect.DeepEqual(requeued, step.requeue) {
+					t.Errorf("step %d: expected requeue after %v, got %v", i, step.requeue, requeued)
+				}
+				if events := popEvents(ac.eventRecorder); !hasEvent(events, step.event) {
+					t.Errorf("step %d: expected %s event, got %v", i, step.event, events)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	d := newDeployment("test1", map[string]string{
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+	})
+	ac, client, factory := newTestController(t, d)
+	ac.EnableDryRun()
+
+	// fake client 不支持 dry-run，模拟 apiserver 校验后不持久化
+	client.PrependReactor("patch", "horizontalpodautoscalers", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, &autoscalingv2.HorizontalPodAutoscaler{}, nil
+	})
+
+	created := metrics.DryRunActions.WithLabelValues(hpaResource, createAction)
+	before, _ := testutil.GetCounterMetricValue(created)
+	if err := ac.syncAutoscalers(context.TODO(), workloadKey(t, deploymentGroupKind, d)); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if hpaList := syncHPAsToCache(t, client, factory); len(hpaList) != 0 {
+		t.Errorf("expected no hpa is created, got %d", len(hpaList))
+	}
+	if after, _ := testutil.GetCounterMetricValue(created); after != before+1 {
+		t.Errorf("expected dry run create counted once, got %v", after-before)
+	}
+	events := popEvents(ac.eventRecorder)
+	if !hasEvent(events, "DryRun") || hasEvent(events, "CreateHPA") {
+		t.Errorf("expected only DryRun event, got %v", events)
+	}
+
+	// 移除注释后 HPA 应被删除，dry-run 时仅通过 apiserver 校验
+	hpa, err := controller.CreateHPAFromDeployment(d, time.Now())
+	if err != nil {
+		t.Fatalf("failed to create hpa: %v", err)
+	}
+	if _, err = client.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.TODO(), hpa, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create hpa: %v", err)
+	}
+	client.ClearActions()
+	if err = ac.deleteHPAsInBatch(context.TODO(), []*autoscalingv2.HorizontalPodAutoscaler{hpa}); err != nil {
+		t.Fatalf("failed to delete hpa: %v", err)
+	}
+	for _, action := range client.Actions() {
+		deleteAction, ok := action.(clienttesting.DeleteAction)
+		if !ok {
+			continue
+		}
+		if dryRun := deleteAction.GetDeleteOptions().DryRun; len(dryRun) != 1 || dryRun[0] != metav1.DryRunAll {
+			t.Errorf("expected delete with dry run, got %v", dryRun)
+		}
+	}
+}
+
+func TestSyncUnmanagedHPA(t *testing.T) {
+	d := newDeployment("test1", map[string]string{
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+	})
+	// 手动创建的 HPA，同样伸缩 test1
+	newUnmanagedHPA := func(managedFields ...metav1.ManagedFieldsEntry) *autoscalingv2.HorizontalPodAutoscaler {
+		return &autoscalingv2.HorizontalPodAutoscaler{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:          "nginx-hpa",
+				Namespace:     "default",
+				ManagedFields: managedFields,
+			},
+			Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+				ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Name: "test1"},
+				MaxReplicas:    3,
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		policy      string
+		unmanaged   *autoscalingv2.HorizontalPodAutoscaler
+		expectedHPA []string
+		adopted     bool
+		event       string
+	}{
+		{
+			name:        "adopt",
+			policy:      controller.AdoptPolicyAdopt,
+			unmanaged:   newUnmanagedHPA(),
+			expectedHPA: []string{"nginx-hpa"},
+			adopted:     true,
+			event:       "AdoptHPA",
+		},
+		{
+			// 不修改已存在的 HPA，控制器创建的 HPA 被删除
+			name:        "refuse",
+			policy:      controller.AdoptPolicyRefuse,
+			unmanaged:   newUnmanagedHPA(),
+			expectedHPA: []string{"nginx-hpa"},
+			event:       "UnmanagedHPAConflict",
+		},
+		{
+			// 其他控制器管理的 HPA 无法接管
+			name:   "adopt controlled by others",
+			policy: controller.AdoptPolicyAdopt,
+			unmanaged: func() *autoscalingv2.HorizontalPodAutoscaler {
+				hpa := newUnmanagedHPA()
+				hpa.OwnerReferences = []metav1.OwnerReference{{APIVersion: "keda.sh/v1alpha1", Kind: "ScaledObject", Name: "nginx", UID: "keda", Controller: utilpointer.BoolPtr(true)}}
+				return hpa
+			}(),
+			expectedHPA: []string{"nginx-hpa"},
+			event:       "UnmanagedHPAConflict",
+		},
+		{
+			name:        "defer",
+			policy:      controller.AdoptPolicyDefer,
+			unmanaged:   newUnmanagedHPA(),
+			expectedHPA: []string{"nginx-hpa"},
+			event:       "DeferToUnmanagedHPA",
+		},
+		{
+			// 控制器创建但丢失 ownerReference 的 HPA 不受策略影响
+			name:   "orphaned",
+			policy: controller.AdoptPolicyRefuse,
+			unmanaged: newUnmanagedHPA(metav1.ManagedFieldsEntry{
+				Manager:    controller.PixiuManager,
+				Operation:  metav1.ManagedFieldsOperationApply,
+				APIVersion: controller.AutoscalingAPIVersion,
+				FieldsType: "FieldsV1",
+				FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:maxReplicas":{}}}`)},
+			}),
+			expectedHPA: []string{"nginx-hpa"},
+			adopted:     true,
+			event:       "AdoptHPA",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ac, client, factory := newTestController(t, d)
+			if err := ac.SetAdoptPolicy(test.policy); err != nil {
+				t.Fatalf("failed to set adopt policy: %v", err)
+			}
+
+			// 控制器已为 test1 创建了 HPA
+			managed, err := controller.CreateHPAFromDeployment(d, time.Now())
+			if err != nil {
+				t.Fatalf("failed to create hpa: %v", err)
+			}
+			managed.Name = "test1-hpa"
+			for _, hpa := range []*autoscalingv2.HorizontalPodAutoscaler{managed, test.unmanaged} {
+				if _, err = client.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.TODO(), hpa, metav1.CreateOptions{}); err != nil {
+					t.Fatalf("failed to create hpa: %v", err)
+				}
+			}
+			syncHPAsToCache(t, client, factory)
+
+			// 再次同步时不会重新创建 HPA
+			for i := 0; i < 2; i++ {
+				if err = ac.syncAutoscalers(context.TODO(), workloadKey(t, deploymentGroupKind, d)); err != nil {
+					t.Fatalf("failed to sync: %v", err)
+				}
+				syncHPAsToCache(t, client, factory)
+			}
+
+			var names []string
+			for _, hpa := range syncHPAsToCache(t, client, factory) {
+				names = append(names, hpa.Name)
+				if hpa.Name != test.unmanaged.Name {
+					continue
+				}
+				controllerRef := metav1.GetControllerOf(&hpa)
+				if adopted := controllerRef != nil && controllerRef.UID == d.UID; adopted != test.adopted {
+					t.Errorf("expected adopted %v, got owner %v", test.adopted, controllerRef)
+				}
+				// 接管后按注释更新，否则保持不变
+				if expected := map[bool]int32{true: 6, false: 3}[test.adopted]; hpa.Spec.MaxReplicas != expected {
+					t.Errorf("expected maxReplicas %d, got %d", expected, hpa.Spec.MaxReplicas)
+				}
+			}
+			if strings.Join(names, ",") != strings.Join(test.expectedHPA, ",") {
+				t.Errorf("expected hpa %v, got %v", test.expectedHPA, names)
+			}
+			if events := popEvents(ac.eventRecorder); !hasEvent(events, test.event) {
+				t.Errorf("expected %s event, got %v", test.event, events)
+			}
+		})
+	}
+}
+
+func TestSyncHPAFieldConflict(t *testing.T) {
+	d := newDeployment("test1", map[string]string{
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+	})
+
+	tests := []struct {
+		name    string
+		policy  string
+		applies int
+		synced  bool
+	}{
+		{name: "on-conflict", policy: controller.ForceOwnershipOnConflict, applies: 2, synced: true},
+		{name: "never", policy: controller.ForceOwnershipNever, applies: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ac, client, _ := newTestController(t, d)
+			if err := ac.SetForceOwnership(test.policy); err != nil {
+				t.Fatalf("failed to set force ownership: %v", err)
+			}
+
+			// 第一次 apply 时 maxReplicas 已被 kubectl edit 修改
+			var applies int
+			client.PrependReactor("patch", "horizontalpodautoscalers", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				applies++
+				if applies > 1 {
+					return false, nil, nil
+				}
+				return true, nil, errors.NewConflict(autoscalingv2.Resource("horizontalpodautoscalers"), action.(clienttesting.PatchAction).GetName(),
+					fmt.Errorf(`Apply failed with 1 conflict: conflict with "kubectl-edit" using autoscaling/v2: .spec.maxReplicas`))
+			})
+
+			err := ac.syncAutoscalers(context.TODO(), workloadKey(t, deploymentGroupKind, d))
+			if synced := err == nil; synced != test.synced {
+				t.Errorf("expected synced %v, got error %v", test.synced, err)
+			}
+			if applies != test.applies {
+				t.Errorf("expected %d applies, got %d", test.applies, applies)
+			}
+			if events := popEvents(ac.eventRecorder); !hasEvent(events, "HPAFieldConflict") || hasEvent(events, "CreateHPA") != test.synced {
+				t.Errorf("unexpected events %v", events)
+			}
+		})
+	}
+}
+
+func TestSyncMetrics(t *testing.T) {
+	d := newDeployment("test1", map[string]string{
+		"hpa.caoyingjunz.io/maxReplicas":                  "10",
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+	})
+	ac, client, factory := newTestController(t, d)
+	key := workloadKey(t, deploymentGroupKind, d)
+
+	created := metrics.HPAOperations.WithLabelValues(createAction, metrics.Success)
+	before, _ := testutil.GetCounterMetricValue(created)
+	if err := ac.syncAutoscalers(context.TODO(), key); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if after, _ := testutil.GetCounterMetricValue(created); after != before+1 {
+		t.Errorf("expected hpa create counted once, got %v", after-before)
+	}
+
+	// apiserver 为 apply 的 HPA 记录 managedFields
+	hpaList := syncHPAsToCache(t, client, factory)
+	hpa := hpaList[0].DeepCopy()
+	hpa.ManagedFields = []metav1.ManagedFieldsEntry{{
+		Manager:    controller.PixiuManager,
+		Operation:  metav1.ManagedFieldsOperationApply,
+		APIVersion: controller.AutoscalingAPIVersion,
+	}}
+	if err := factory.Autoscaling().V2().HorizontalPodAutoscalers().Informer().GetIndexer().Update(hpa); err != nil {
+		t.Fatalf("failed to update hpa: %v", err)
+	}
+	ac.updateManagedWorkloads()
+	managed := metrics.ManagedWorkloads.WithLabelValues("Deployment.apps")
+	if count, _ := testutil.GetGaugeMetricValue(managed); count != 1 {
+		t.Errorf("expected 1 managed deployment, got %v", count)
+	}
+
+	// 注释有误时按原因统计
+	d.Annotations["hpa.caoyingjunz.io/minReplicas"] = "20"
+	invalid := metrics.ParseFailures.WithLabelValues("FieldValueInvalid")
+	before, _ = testutil.GetCounterMetricValue(invalid)
+	if err := ac.syncAutoscalers(context.TODO(), key); err == nil {
+		t.Fatalf("expected sync failed")
+	}
+	if after, _ := testutil.GetCounterMetricValue(invalid); after != before+1 {
+		t.Errorf("expected parse failure counted once, got %v", after-before)
+	}
+}
+
+func TestCheckWorkers(t *testing.T) {
+	fakeClock := testingclock.NewFakePassiveClock(time.Now())
+	h := newHeartbeats(fakeClock)
+
+	h.start(autoscalerHandler, "default/foo")
+	fakeClock.SetTime(fakeClock.Now().Add(time.Minute))
+	if err := h.check(2 * time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fakeClock.SetTime(fakeClock.Now().Add(2 * time.Minute))
+	if err := h.check(2 * time.Minute); err == nil {
+		t.Fatalf("expected wedged worker error, got nil")
+	}
+
+	h.done(autoscalerHandler, "default/foo")
+	if err := h.check(2 * time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSyncOutOfScope(t *testing.T) {
+	d := newDeployment("test1", map[string]string{
+		"hpa.caoyingjunz.io/maxReplicas":                  "10",
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+	})
+	key := workloadKey(t, deploymentGroupKind, d)
+
+	tests := []struct {
+		name              string
+		includeNamespaces []string
+		excludeNamespaces []string
+		namespaceSelector string
+		namespaceLabels   map[string]string
+		expectHPAs        int
+	}{
+		{name: "not included", includeNamespaces: []string{"team-a", "team-b"}},
+		{name: "excluded", excludeNamespaces: []string{"default"}},
+		{name: "namespace not selected", namespaceSelector: "tenant=team-a", namespaceLabels: map[string]string{"tenant": "team-b"}},
+		{name: "namespace selected", namespaceSelector: "tenant=team-a", namespaceLabels: map[string]string{"tenant": "team-a"}, expectHPAs: 1},
+		{name: "included", includeNamespaces: []string{"default", "team-a"}, expectHPAs: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ac, client, factory := newTestController(t, d)
+			scope, err := controller.NewScope(test.includeNamespaces, test.excludeNamespaces, test.namespaceSelector, "")
+			if err != nil {
+				t.Fatalf("failed to create scope: %v", err)
+			}
+			ac.SetScope(scope)
+			// namespace informer 仅缓存 namespaceSelector 选中的命名空间
+			if scope.SelectsNamespaces() && scope.NamespaceSelector.Matches(labels.Set(test.namespaceLabels)) {
+				ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: test.namespaceLabels}}
+				if err = factory.Core().V1().Namespaces().Informer().GetIndexer().Add(ns); err != nil {
+					t.Fatalf("failed to add namespace: %v", err)
+				}
+			}
+
+			if err := ac.syncAutoscalers(context.TODO(), key); err != nil {
+				t.Fatalf("failed to sync: %v", err)
+			}
+			if hpaList := syncHPAsToCache(t, client, factory); len(hpaList) != test.expectHPAs {
+				t.Errorf("expected %d hpa, got %d", test.expectHPAs, len(hpaList))
+			}
+		})
+	}
+}
+
+func TestDrain(t *testing.T) {
+	tests := []struct {
+		name string
+		// block 为 true 时同步阻塞至 context 取消
+		block          bool
+		expectCanceled bool
+	}{
+		{name: "drained"},
+		{name: "timed out", block: true, expectCanceled: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ac, _, _ := newTestController(t)
+			ac.SetDrainTimeout(100 * time.Millisecond)
+
+			started := make(chan struct{})
+			synced := make(chan error, 1)
+			ac.syncHandler = func(ctx context.Context, key string) error {
+				close(started)
+				if test.block {
+					<-ctx.Done()
+				}
+				synced <- ctx.Err()
+				return nil
+			}
+
+			workerCtx, cancel := context.WithCancel(context.Background())
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ac.worker(workerCtx)
+			}()
+
+			ac.queue.Add("default/foo")
+			<-started
+			ac.drain(cancel, &wg)
+
+			select {
+			case err := <-synced:
+				if canceled := err != nil; canceled != test.expectCanceled {
+					t.Errorf("expected canceled %v, got %v", test.expectCanceled, err)
+				}
+			default:
+				t.Fatalf("expected the in-flight sync finished after drain")
+			}
+			if !ac.queue.ShuttingDown() || !ac.cmQueue.ShuttingDown() {
+				t.Errorf("expected both queues shut down")
+			}
+		})
+	}
+}
+
+func TestSyncLegacyHPA(t *testing.T) {
+	d := newDeployment("test1", map[string]string{
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+	})
+	ac, client, factory := newTestController(t, d)
+	// 旧版本控制器 create 的 HPA，refuse 策略下同样被接管
+	if err := ac.SetAdoptPolicy(controller.AdoptPolicyRefuse); err != nil {
+		t.Fatalf("failed to set adopt policy: %v", err)
+	}
+
+	legacy, err := controller.CreateHPAFromDeployment(d, time.Now())
+	if err != nil {
+		t.Fatalf("failed to create hpa: %v", err)
+	}
+	legacy.OwnerReferences = nil
+	legacy.Spec.MaxReplicas = 3
+	legacy.ManagedFields = []metav1.ManagedFieldsEntry{{
+		Manager:    controller.LegacyPixiuManager,
+		Operation:  metav1.ManagedFieldsOperationUpdate,
+		APIVersion: controller.AutoscalingAPIVersion,
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:maxReplicas":{}}}`)},
+	}}
+	if _, err = client.AutoscalingV2().HorizontalPodAutoscalers("default").Create(context.TODO(), legacy, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("failed to create hpa: %v", err)
+	}
+	syncHPAsToCache(t, client, factory)
+
+	if err = ac.syncAutoscalers(context.TODO(), workloadKey(t, deploymentGroupKind, d)); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+
+	hpaList := syncHPAsToCache(t, client, factory)
+	if len(hpaList) != 1 {
+		t.Fatalf("expected the legacy hpa is adopted, got %d hpa", len(hpaList))
+	}
+	hpa := &hpaList[0]
+	if controllerRef := metav1.GetControllerOf(hpa); controllerRef == nil || controllerRef.UID != d.UID {
+		t.Errorf("expected hpa controlled by %s, got %v", d.Name, controllerRef)
+	}
+	if hpa.Spec.MaxReplicas != 6 {
+		t.Errorf("expected maxReplicas 6, got %d", hpa.Spec.MaxReplicas)
+	}
+	if controller.HasLegacyManagedFields(hpa) {
+		t.Errorf("expected the legacy managed fields removed, got %v", hpa.ManagedFields)
+	}
+}
+
+func TestSyncWorkloadKinds(t *testing.T) {
+	annotations := map[string]string{
+		"hpa.caoyingjunz.io/maxReplicas":                  "10",
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+	}
+
+	tests := []struct {
+		name string
+		// newWorkload 将带有注释的工作负载加入缓存，返回其类型和对象
+		newWorkload func(t *testing.T, ac *AutoscalerController, factory informers.SharedInformerFactory) (schema.GroupKind, metav1.Object)
+		expected    autoscalingv2.CrossVersionObjectReference
+	}{
+		{
+			name: "statefulset",
+			newWorkload: func(t *testing.T, ac *AutoscalerController, factory informers.SharedInformerFactory) (schema.GroupKind, metav1.Object) {
+				s := &appsv1.StatefulSet{
+					TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "mysql",
+						Namespace:   "default",
+						UID:         "mysql-7a2b9f0e-2c1d-4e5f-8a9b",
+						Annotations: annotations,
+					},
+					Spec: appsv1.StatefulSetSpec{
+						Template: v1.PodTemplateSpec{
+							Spec: v1.PodSpec{Containers: []v1.Container{{Name: "mysql", Image: "mysql"}}},
+						},
+					},
+				}
+				if err := factory.Apps().V1().StatefulSets().Informer().GetIndexer().Add(s); err != nil {
+					t.Fatalf("failed to add statefulset: %v", err)
+				}
+				return appsv1.SchemeGroupVersion.WithKind(controller.StatefulSet).GroupKind(), s
+			},
+			expected: autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "mysql"},
+		},
+		{
+			name: "custom scale target",
+			newWorkload: func(t *testing.T, ac *AutoscalerController, factory informers.SharedInformerFactory) (schema.GroupKind, metav1.Object) {
+				gvr := schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+				gvk := gvr.GroupVersion().WithKind("Rollout")
+				// 自定义资源通过 metadata informer 获取，对象中不含类型信息
+				metadataFactory := metadatainformer.NewSharedInformerFactory(metadatafake.NewSimpleMetadataClient(runtime.NewScheme()), 0)
+				target := &controller.ScaleTarget{Resource: gvr, Kind: gvk, Informer: metadataFactory.ForResource(gvr)}
+				ac.AddScaleTarget(target)
+
+				rollout := &metav1.PartialObjectMetadata{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "canary",
+						Namespace:   "default",
+						UID:         "canary-7a2b9f0e-2c1d-4e5f-8a9b",
+						Annotations: annotations,
+					},
+				}
+				if err := target.Informer.Informer().GetIndexer().Add(rollout); err != nil {
+					t.Fatalf("failed to add rollout: %v", err)
+				}
+				return gvk.GroupKind(), rollout
+			},
+			expected: autoscalingv2.CrossVersionObjectReference{APIVersion: "argoproj.io/v1alpha1", Kind: "Rollout", Name: "canary"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ac, client, factory := newTestController(t)
+			gk, obj := test.newWorkload(t, ac, factory)
+			key := workloadKey(t, gk, obj)
+
+			if err := ac.syncAutoscalers(context.TODO(), key); err != nil {
+				t.Fatalf("failed to sync: %v", err)
+			}
+			hpaList := syncHPAsToCache(t, client, factory)
+			if len(hpaList) != 1 {
+				t.Fatalf("expected 1 hpa, got %d", len(hpaList))
+			}
+			hpa := hpaList[0]
+			if hpa.Spec.ScaleTargetRef != test.expected {
+				t.Errorf("expected scale target %+v, got %+v", test.expected, hpa.Spec.ScaleTargetRef)
+			}
+			controllerRef := metav1.GetControllerOf(&hpa)
+			if controllerRef == nil || controllerRef.APIVersion != test.expected.APIVersion || controllerRef.Kind != test.expected.Kind ||
+				controllerRef.Name != obj.GetName() || controllerRef.UID != obj.GetUID() {
+				t.Errorf("expected hpa controlled by %s %s, got %+v", test.expected.Kind, obj.GetName(), controllerRef)
+			}
+
+			// 移除注释后删除 hpa
+			obj.SetAnnotations(nil)
+			if err := ac.syncAutoscalers(context.TODO(), key); err != nil {
+				t.Fatalf("failed to sync: %v", err)
+			}
+			if hpaList, _ := client.AutoscalingV2().HorizontalPodAutoscalers("default").List(context.TODO(), metav1.ListOptions{}); len(hpaList.Items) != 0 {
+				t.Errorf("expected hpa deleted, got %d", len(hpaList.Items))
+			}
+		})
+	}
+}
+
+// countingNotifier counts the notifications to the prometheus adapter.
+type countingNotifier struct {
+	notifies int
+}
+
+func (n *countingNotifier) Notify(ctx context.Context) error {
+	n.notifies++
+	return nil
+}
+
+func (n *countingNotifier) Run(ctx context.Context) {}
+
+func TestFlushPendingNotify(t *testing.T) {
+	adapter := controller.PrometheusAdapter{Namespace: "pixiu-system", ConfigMapName: "prometheus-adapter", ConfigMapKey: "config.yaml"}
+
+	tests := []struct {
+		name string
+		// annotations 为 nil 时 configmap 不存在
+		annotations    map[string]string
+		expectNotifies int
+	}{
+		{
+			name:           "pending",
+			annotations:    map[string]string{controller.NotifyPending: "2021-10-18T09:00:00Z"},
+			expectNotifies: 1,
+		},
+		{
+			name:        "notified",
+			annotations: map[string]string{controller.NotifyAt: "2021-10-18T09:00:00Z"},
+		},
+		{
+			name: "configmap not found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ac, _, factory := newTestController(t)
+			n := &countingNotifier{}
+			ac.adapter, ac.notifier = adapter, n
+			if test.annotations != nil {
+				cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: adapter.ConfigMapName, Namespace: adapter.Namespace, Annotations: test.annotations}}
+				if err := factory.Core().V1().ConfigMaps().Informer().GetIndexer().Add(cm); err != nil {
+					t.Fatalf("failed to add configmap: %v", err)
+				}
+			}
+
+			ac.flushPendingNotify(context.TODO())
+			if n.notifies != test.expectNotifies {
+				t.Errorf("expected %d notifies, got %d", test.expectNotifies, n.notifies)
+			}
+		})
+	}
+}
+
+func TestSyncConfigMapsRetriesRestart(t *testing.T) {
+	adapter := controller.PrometheusAdapter{
+		Namespace:      "pixiu-system",
+		ConfigMapName:  "prometheus-adapter",
+		ConfigMapKey:   "config.yaml",
+		DeploymentName: "prometheus-adapter",
+	}
+	ac, client, factory := newTestController(t)
+	n, err := notifier.New(notifier.Restart, client, adapter, 0, false)
+	if err != nil {
+		t.Fatalf("failed to create notifier: %v", err)
+	}
+	ac.adapter, ac.notifier = adapter, n
+
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test1",
+			Namespace: "default",
+			Labels:    map[string]string{controller.PrometheusCustomMetric: "true"},
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			Metrics: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.PodsMetricSourceType,
+				Pods: &autoscalingv2.PodsMetricSource{Metric: autoscalingv2.MetricIdentifier{Name: "http_requests_per_second"}},
+			}},
+		},
+	}
+	if err = factory.Autoscaling().V2().HorizontalPodAutoscalers().Informer().GetIndexer().Add(hpa); err != nil {
+		t.Fatalf("failed to add hpa: %v", err)
+	}
+	cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: adapter.ConfigMapName, Namespace: adapter.Namespace}}
+	for _, obj := range []runtime.Object{cm, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: adapter.DeploymentName, Namespace: adapter.Namespace}}} {
+		if err = client.Tracker().Add(obj); err != nil {
+			t.Fatalf("failed to add object: %v", err)
+		}
+	}
+	// 第一次重启 prometheus-adapter 失败
+	failed := false
+	client.PrependReactor("patch", "deployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if failed {
+			return false, nil, nil
+		}
+		failed = true
+		return true, nil, fmt.Errorf("the server is currently unable to handle the request")
+	})
+
+	// sync 从缓存中读取 configmap，模拟 informer 收到 configmap 的变更
+	sync := func() error {
+		current, err := client.CoreV1().ConfigMaps(adapter.Namespace).Get(context.TODO(), adapter.ConfigMapName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get configmap: %v", err)
+		}
+		if err = factory.Core().V1().ConfigMaps().Informer().GetIndexer().Update(current); err != nil {
+			t.Fatalf("failed to update configmap: %v", err)
+		}
+		return ac.syncConfigMaps(context.TODO(), adapter.Namespace+"/"+adapter.ConfigMapName)
+	}
+	pending := func() bool {
+		current, err := client.CoreV1().ConfigMaps(adapter.Namespace).Get(context.TODO(), adapter.ConfigMapName, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get configmap: %v", err)
+		}
+		_, ok := current.Annotations[controller.NotifyPending]
+		return ok
+	}
+
+	if err = sync(); err == nil {
+		t.Fatalf("expected the failed restart to be returned")
+	}
+	// 规则与待通知的记录一同写入
+	if !pending() {
+		t.Fatalf("expected the pending notification to be recorded with the rules")
+	}
+
+	// 重新同步时规则未变化，仍然重试重启
+	if err = sync(); err != nil {
+		t.Fatalf("failed to sync configmap: %v", err)
+	}
+	var restarts int
+	for _, action := range client.Actions() {
+		if action.Matches("patch", "deployments") {
+			restarts++
+		}
+	}
+	if restarts != 2 {
+		t.Errorf("expected 2 restarts, got %d", restarts)
+	}
+	if pending() {
+		t.Errorf("expected the pending notification to be cleared")
+	}
+
+	// 通知完成后不再重启
+	if err = sync(); err != nil {
+		t.Fatalf("failed to sync configmap: %v", err)
+	}
+	restarts = 0
+	for _, action := range client.Actions() {
+		if action.Matches("patch", "deployments") {
+			restarts++
+		}
+	}
+	if restarts != 2 {
+		t.Errorf("expected no more restarts, got %d", restarts)
+	}
+}
+
+// newPolicy returns an AutoscalingPolicy which targets the deployment.
+func newPolicy(name, target string, minReplicas *int32, maxReplicas int32, created time.Time) *v1alpha1.AutoscalingPolicy {
+	return &v1alpha1.AutoscalingPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			UID:               types.UID(name + "-7a2b9f0e-2c1d-4e5f-8a9b"),
+			CreationTimestamp: metav1.NewTime(created),
+		},
+		Spec: v1alpha1.AutoscalingPolicySpec{
+			TargetRef:   autoscalingv2.CrossVersionObjectReference{APIVersion: "apps/v1", Kind: controller.Deployment, Name: target},
+			MinReplicas: minReplicas,
+			MaxReplicas: maxReplicas,
+			Metrics: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.ResourceMetricSourceType,
+				Resource: &autoscalingv2.ResourceMetricSource{
+					Name:   v1.ResourceCPU,
+					Target: autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: utilpointer.Int32Ptr(50)},
+				},
+			}},
+		},
+	}
+}
+
+func TestSyncPolicy(t *testing.T) {
+	created := time.Date(2021, 10, 18, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		// deleted 为 true 时 policy 指向的 deployment 不存在
+		deleted  bool
+		policies []*v1alpha1.AutoscalingPolicy
+		// expectedReplicas 为生成的 hpa 的最小和最大副本数，为 nil 时不生成 hpa
+		expectedReplicas []int32
+		expectedReasons  map[string]string
+		expectedEvent    string
+	}{
+		{
+			name: "policy overrides annotations",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/maxReplicas":                  "10",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			policies:         []*v1alpha1.AutoscalingPolicy{newPolicy("policy1", "test1", utilpointer.Int32Ptr(2), 5, created)},
+			expectedReplicas: []int32{2, 5},
+			expectedReasons:  map[string]string{"policy1": v1alpha1.ReasonHPASynced},
+			expectedEvent:    "PolicyOverridesAnnotations",
+		},
+		{
+			name: "oldest policy wins",
+			policies: []*v1alpha1.AutoscalingPolicy{
+				newPolicy("policy2", "test1", nil, 8, created.Add(time.Minute)),
+				newPolicy("policy1", "test1", nil, 5, created),
+			},
+			expectedReplicas: []int32{1, 5},
+			expectedReasons: map[string]string{
+				"policy1": v1alpha1.ReasonHPASynced,
+				"policy2": v1alpha1.ReasonPolicyConflicts,
+			},
+		},
+		{
+			name:            "target not found",
+			deleted:         true,
+			policies:        []*v1alpha1.AutoscalingPolicy{newPolicy("policy1", "test1", nil, 5, created)},
+			expectedReasons: map[string]string{"policy1": v1alpha1.ReasonTargetNotFound},
+		},
+		{
+			name:            "max less than min",
+			policies:        []*v1alpha1.AutoscalingPolicy{newPolicy("policy1", "test1", utilpointer.Int32Ptr(5), 2, created)},
+			expectedReasons: map[string]string{"policy1": v1alpha1.ReasonInvalidPolicy},
+			expectedEvent:   "FailedNewestHPA",
+		},
+		{
+			name:            "zero min",
+			policies:        []*v1alpha1.AutoscalingPolicy{newPolicy("policy1", "test1", utilpointer.Int32Ptr(0), 2, created)},
+			expectedReasons: map[string]string{"policy1": v1alpha1.ReasonInvalidPolicy},
+			expectedEvent:   "FailedNewestHPA",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newDeployment("test1", test.annotations)
+			var objects []*appsv1.Deployment
+			if !test.deleted {
+				objects = append(objects, d)
+			}
+			ac, client, factory := newTestController(t, objects...)
+
+			var policyObjects []runtime.Object
+			for _, policy := range test.policies {
+				u, err := controller.ConvertFromPolicy(policy)
+				if err != nil {
+					t.Fatalf("failed to convert policy: %v", err)
+				}
+				policyObjects = append(policyObjects, u)
+			}
+			dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{v1alpha1.AutoscalingPolicyResource: "AutoscalingPolicyList"}, policyObjects...)
+			informer := dynamicinformer.NewDynamicSharedInformerFactory(dynamicClient, 0).ForResource(v1alpha1.AutoscalingPolicyResource)
+			for _, obj := range policyObjects {
+				if err := informer.Informer().GetIndexer().Add(obj); err != nil {
+					t.Fatalf("failed to add policy: %v", err)
+				}
+			}
+			ac.AddAutoscalingPolicy(informer, dynamicClient)
+
+			if err := ac.syncAutoscalers(context.TODO(), workloadKey(t, deploymentGroupKind, d)); err != nil {
+				t.Fatalf("failed to sync: %v", err)
+			}
+
+			hpaList := syncHPAsToCache(t, client, factory)
+			if test.expectedReplicas == nil {
+				if len(hpaList) != 0 {
+					t.Errorf("expected no hpa, got %d", len(hpaList))
+				}
+			} else {
+				if len(hpaList) != 1 {
+					t.Fatalf("expected 1 hpa, got %d", len(hpaList))
+				}
+				if spec := hpaList[0].Spec; *spec.MinReplicas != test.expectedReplicas[0] || spec.MaxReplicas != test.expectedReplicas[1] {
+					t.Errorf("expected replicas %v, got [%d %d]", test.expectedReplicas, *spec.MinReplicas, spec.MaxReplicas)
+				}
+			}
+
+			for name, reason := range test.expectedReasons {
+				u, err := dynamicClient.Resource(v1alpha1.AutoscalingPolicyResource).Namespace("default").Get(context.TODO(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatalf("failed to get policy: %v", err)
+				}
+				policy, err := controller.ConvertToPolicy(u)
+				if err != nil {
+					t.Fatalf("failed to convert policy: %v", err)
+				}
+				condition := meta.FindStatusCondition(policy.Status.Conditions, v1alpha1.PolicyReady)
+				if condition == nil || condition.Reason != reason {
+					t.Errorf("expected policy %s reason %s, got %+v", name, reason, condition)
+				}
+			}
+			if events := popEvents(ac.eventRecorder); len(test.expectedEvent) != 0 && !hasEvent(events, test.expectedEvent) {
+				t.Errorf("expected event %s, got %v", test.expectedEvent, events)
+			}
+		})
+	}
+}
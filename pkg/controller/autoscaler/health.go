@@ -0,0 +1,89 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package autoscaler
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/utils/clock"
+)
+
+// workItem identifies the item being processed by a worker.
+type workItem struct {
+	handler string
+	key     interface{}
+}
+
+// heartbeats tracks when the workers started processing their current items,
+// a worker which processes one item too long is considered wedged.
+type heartbeats struct {
+	clock clock.PassiveClock
+
+	lock sync.Mutex
+	busy map[workItem]time.Time
+}
+
+func newHeartbeats(c clock.PassiveClock) *heartbeats {
+	return &heartbeats{
+		clock: c,
+		busy:  make(map[workItem]time.Time),
+	}
+}
+
+// start records that the item starts to be processed, workqueue guarantees
+// that the same key is never processed concurrently.
+func (h *heartbeats) start(handler string, key interface{}) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.busy[workItem{handler: handler, key: key}] = h.clock.Now()
+}
+
+// done records that the item has been processed.
+func (h *heartbeats) done(handler string, key interface{}) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.busy, workItem{handler: handler, key: key})
+}
+
+// check returns an error if any item has been processed longer than timeout.
+func (h *heartbeats) check(timeout time.Duration) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	now := h.clock.Now()
+	for item, startTime := range h.busy {
+		if elapsed := now.Sub(startTime); elapsed > timeout {
+			return fmt.Errorf("%s worker has been processing %v for %v", item.handler, item.key, elapsed.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+// HasSynced returns true if the caches have been synced and the workers are
+// started.
+func (ac *AutoscalerController) HasSynced() bool {
+	return atomic.LoadInt32(&ac.synced) == 1
+}
+
+// CheckWorkers returns an error if any worker has been processing one item
+// longer than timeout.
+func (ac *AutoscalerController) CheckWorkers(timeout time.Duration) error {
+	return ac.heartbeats.check(timeout)
+}
@@ -19,15 +19,22 @@ package controller
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
 	autoscalingv2 "k8s.io/api/autoscaling/v2"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	clientset "k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
@@ -82,13 +89,22 @@ func (b SimpleControllerClientBuilder) ClientOrDie(name string) clientset.Interf
 	return client
 }
 
-func CreateHPAFromDeployment(d *appsv1.Deployment) (*autoscalingv2.HorizontalPodAutoscaler, error) {
-	annotations := d.GetAnnotations()
-	name := d.GetName()
-	namespace := d.GetNamespace()
-	uid := d.GetUID()
-	apiVersion := AppsAPIVersion
-	kind := Deployment
+func CreateHPAFromDeployment(d *appsv1.Deployment, now time.Time) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	return CreateHPAFromWorkload(NewWorkloadFromDeployment(d), now)
+}
+
+func CreateHPAFromStatefulSet(s *appsv1.StatefulSet, now time.Time) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	return CreateHPAFromWorkload(NewWorkloadFromStatefulSet(s), now)
+}
+
+// CreateHPAFromWorkload creates the desired HPA from the workload's annotations,
+// the replicas bounds are overridden by the schedule active at now.
+func CreateHPAFromWorkload(w *Workload, now time.Time) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	annotations := w.GetAnnotations()
+	// 先校验全部注释，一次性返回所有错误
+	if errs := ValidateAnnotations(annotations); len(errs) != 0 {
+		return nil, errs.ToAggregate()
+	}
 
 	minReplicas, err := extractReplicas(annotations, MinReplicas)
 	if err != nil {
@@ -103,6 +119,78 @@ func CreateHPAFromDeployment(d *appsv1.Deployment) (*autoscalingv2.HorizontalPod
 	if err != nil {
 		return nil, fmt.Errorf("parse metric specs from annotations failed: %v", err)
 	}
+	if err = validateContainerMetrics(w, metrics); err != nil {
+		return nil, err
+	}
+
+	behavior, err := parseBehavior(annotations)
+	if err != nil {
+		return nil, fmt.Errorf("parse behavior from annotations failed: %v", err)
+	}
+
+	hpaAnnotations := map[string]string{}
+	schedules, err := ParseSchedules(annotations)
+	if err != nil {
+		return nil, fmt.Errorf("parse schedules from annotations failed: %v", err)
+	}
+	if active := ActiveSchedule(schedules, now); active != nil {
+		minReplicas, maxReplicas = active.Replicas(minReplicas, maxReplicas)
+		hpaAnnotations[ActiveScheduleAnnotation] = active.Name
+	}
+
+	externalRules, err := parseExternalRules(annotations)
+	if err != nil {
+		return nil, fmt.Errorf("parse external rules from annotations failed: %v", err)
+	}
+	if len(externalRules) != 0 {
+		data, err := json.Marshal(externalRules)
+		if err != nil {
+			return nil, err
+		}
+		hpaAnnotations[ExternalRulesAnnotation] = string(data)
+	}
+
+	return newHPAForWorkload(w, autoscalingv2.HorizontalPodAutoscalerSpec{
+		MinReplicas: utilpointer.Int32Ptr(minReplicas),
+		MaxReplicas: maxReplicas,
+		Metrics:     metrics,
+		Behavior:    behavior,
+	}, hpaAnnotations), nil
+}
+
+// IsWorkloadControlHPA returns true if the annotations ask for an HPA, that is
+// at least one metric annotation is set.
+func IsWorkloadControlHPA(annotations map[string]string) bool {
+	items := NewItems()
+	for annotation := range annotations {
+		_, found := items[annotation]
+		if found || IsContainerMetric(annotation) || IsPrometheusMetric(annotation) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateWorkload validates the hpa annotations of the workload by the same
+// parsing as CreateHPAFromWorkload, the workloads without HPA are always valid.
+func ValidateWorkload(w *Workload) error {
+	if !IsWorkloadControlHPA(w.GetAnnotations()) {
+		return nil
+	}
+	// 校验与时间无关，定时生效期间的副本数范围均已校验
+	_, err := CreateHPAFromWorkload(w, time.Now())
+	return err
+}
+
+// newHPAForWorkload creates the HPA of the workload with the given spec, the
+// scale target, name, labels and owner reference are derived from the workload.
+func newHPAForWorkload(w *Workload, spec autoscalingv2.HorizontalPodAutoscalerSpec, hpaAnnotations map[string]string) *autoscalingv2.HorizontalPodAutoscaler {
+	name := w.GetName()
+	namespace := w.GetNamespace()
+	uid := w.GetUID()
+	apiVersion := w.APIVersion
+	kind := w.Kind
 
 	controller := true
 	blockOwnerDeletion := true
@@ -116,19 +204,24 @@ func CreateHPAFromDeployment(d *appsv1.Deployment) (*autoscalingv2.HorizontalPod
 		BlockOwnerDeletion: &blockOwnerDeletion,
 	}
 
-	spec := autoscalingv2.HorizontalPodAutoscalerSpec{
-		MinReplicas: utilpointer.Int32Ptr(minReplicas),
-		MaxReplicas: maxReplicas,
-		ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
-			APIVersion: apiVersion,
-			Kind:       kind,
-			Name:       name,
-		},
-		Metrics: metrics,
+	spec.ScaleTargetRef = autoscalingv2.CrossVersionObjectReference{
+		APIVersion: apiVersion,
+		Kind:       kind,
+		Name:       name,
 	}
 
-	// 生成名称后缀
-	hpaNameHash := computeHash(d.Name)
+	// 生成名称后缀，Deployment 保持原有的命名方式，以兼容已创建的 HPA
+	hashSeed := name
+	if kind != Deployment {
+		hashSeed = kind + PixiuSeparator + name
+	}
+	hpaNameHash := computeHash(hashSeed)
+
+	// 拷贝 selector 标签，避免修改工作负载本身
+	hpaLabels := make(map[string]string, len(w.Selector))
+	for k, v := range w.Selector {
+		hpaLabels[k] = v
+	}
 
 	return &autoscalingv2.HorizontalPodAutoscaler{
 		TypeMeta: metav1.TypeMeta{
@@ -136,15 +229,16 @@ func CreateHPAFromDeployment(d *appsv1.Deployment) (*autoscalingv2.HorizontalPod
 			APIVersion: AutoscalingAPIVersion,
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      d.Name + "-" + hpaNameHash,
+			Name:      name + "-" + hpaNameHash,
 			Namespace: namespace,
 			OwnerReferences: []metav1.OwnerReference{
 				ownerReference,
 			},
-			Labels: d.Spec.Selector.MatchLabels,
+			Labels:      hpaLabels,
+			Annotations: hpaAnnotations,
 		},
 		Spec: spec,
-	}, nil
+	}
 }
 
 func computeHash(objectToWrite string) string {
@@ -162,7 +256,7 @@ func getMetricTarget(metricName string) (string, string, error) {
 		return "", "", fmt.Errorf("invalied metric item %s", metricName)
 	}
 	metricType := metricTypeSlice[0]
-	if metricType != cpu && metricType != memory && metricType != prometheus {
+	if metricType != cpu && metricType != memory && metricType != prometheus && metricType != pods && metricType != object {
 		return "", "", fmt.Errorf("unsupprted metric resource name: %s", metricType)
 	}
 
@@ -177,19 +271,25 @@ func getMetricTarget(metricName string) (string, string, error) {
 func parseMetricSpecs(annotations map[string]string) ([]autoscalingv2.MetricSpec, error) {
 	metricSpecs := make([]autoscalingv2.MetricSpec, 0)
 
-	for metricName, metricValue := range annotations {
+	// 按注释排序解析，保证生成的 metrics 顺序稳定，避免每次同步都判定 HPA 发生变化
+	for _, metricName := range sortedKeys(annotations) {
+		metricValue := annotations[metricName]
 		// let it go if annotation item are not the target
-		if !strings.Contains(metricName, PixiuDot+PixiuRootPrefix) {
+		if !strings.Contains(metricName, PixiuDot+PixiuRootPrefix) || IsScheduleAnnotation(metricName) {
 			continue
 		}
 		metricType, target, err := getMetricTarget(metricName)
 		if err != nil {
 			return nil, err
 		}
+		// 具名 prometheus 指标的属性注释在解析 target 时读取，此处跳过
+		if metricType == prometheus && isPrometheusAttribute(target) {
+			continue
+		}
 
 		metricSpec, err := parseMetricSpec(target, metricType, metricValue, annotations)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("invalid %s: %v", metricName, err)
 		}
 		metricSpecs = append(metricSpecs, metricSpec)
 	}
@@ -202,8 +302,16 @@ func parseMetricSpecs(annotations map[string]string) ([]autoscalingv2.MetricSpec
 }
 
 func parseMetricSpec(target string, metricType string, metricValue string, annotations map[string]string) (autoscalingv2.MetricSpec, error) {
-	if metricType == prometheus {
+	if containerName, containerTarget, ok := splitContainerTarget(target); ok {
+		return parseMetricSpecForContainer(containerName, containerTarget, metricType, metricValue)
+	}
+	switch metricType {
+	case prometheus:
 		return parseMetricSpecForPrometheus(target, metricType, metricValue, annotations)
+	case pods:
+		return parseMetricSpecForPods(target, metricType, metricValue, annotations)
+	case object:
+		return parseMetricSpecForObject(target, metricType, metricValue, annotations)
 	}
 
 	return parseMetricSpecFor(target, metricType, metricValue, annotations)
@@ -226,7 +334,7 @@ func parseMetricSpecFor(target string, metricType string, metricValue string, an
 			},
 		}
 	case targetAverageValue:
-		averageValue, err := resource.ParseQuantity(metricValue)
+		averageValue, err := parseTargetQuantity(metricValue)
 		if err != nil {
 			return autoscalingv2.MetricSpec{}, err
 		}
@@ -235,6 +343,8 @@ func parseMetricSpecFor(target string, metricType string, metricValue string, an
 				Type: autoscalingv2.AverageValueMetricType, AverageValue: &averageValue,
 			},
 		}
+	default:
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("unsupported %s metric target: %s, only %s and %s are allowed", metricType, target, targetAverageUtilization, targetAverageValue)
 	}
 
 	switch metricType {
@@ -247,14 +357,129 @@ func parseMetricSpecFor(target string, metricType string, metricValue string, an
 	return metricSpec, nil
 }
 
+// splitContainerTarget splits the target in the format of container.<name>.<target>
+func splitContainerTarget(target string) (string, string, bool) {
+	if !strings.HasPrefix(target, containerPrefix) {
+		return "", "", false
+	}
+	index := strings.LastIndex(target, PixiuDot)
+	if index <= len(containerPrefix) {
+		return "", "", false
+	}
+
+	return target[len(containerPrefix):index], target[index+1:], true
+}
+
+// IsContainerMetric returns true if the annotation is a container resource metric.
+func IsContainerMetric(annotation string) bool {
+	if !strings.Contains(annotation, PixiuDot+PixiuRootPrefix) {
+		return false
+	}
+	metricType, target, err := getMetricTarget(annotation)
+	if err != nil || (metricType != cpu && metricType != memory) {
+		return false
+	}
+	_, _, ok := splitContainerTarget(target)
+	return ok
+}
+
+func parseMetricSpecForContainer(containerName string, target string, metricType string, metricValue string) (autoscalingv2.MetricSpec, error) {
+	var resourceName v1.ResourceName
+	switch metricType {
+	case cpu:
+		resourceName = v1.ResourceCPU
+	case memory:
+		resourceName = v1.ResourceMemory
+	default:
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("unsupported container metric resource name: %s", metricType)
+	}
+
+	metricSpec := autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ContainerResourceMetricSourceType,
+		ContainerResource: &autoscalingv2.ContainerResourceMetricSource{
+			Name:      resourceName,
+			Container: containerName,
+		},
+	}
+
+	switch target {
+	case targetAverageUtilization:
+		averageUtilization, err := extractAverageUtilization(metricValue)
+		if err != nil {
+			return autoscalingv2.MetricSpec{}, err
+		}
+		metricSpec.ContainerResource.Target = autoscalingv2.MetricTarget{
+			Type: autoscalingv2.UtilizationMetricType, AverageUtilization: utilpointer.Int32Ptr(averageUtilization),
+		}
+	case targetAverageValue:
+		averageValue, err := parseTargetQuantity(metricValue)
+		if err != nil {
+			return autoscalingv2.MetricSpec{}, err
+		}
+		metricSpec.ContainerResource.Target = autoscalingv2.MetricTarget{
+			Type: autoscalingv2.AverageValueMetricType, AverageValue: &averageValue,
+		}
+	default:
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("unsupported container metric target: %s", target)
+	}
+
+	return metricSpec, nil
+}
+
+// ContainerNotFoundError is returned when a container resource metric refers
+// to a container which does not exist in the workload's pod template.
+type ContainerNotFoundError struct {
+	Container string
+}
+
+func (e *ContainerNotFoundError) Error() string {
+	return fmt.Sprintf("container %s referenced by container resource metric is not found in pod template", e.Container)
+}
+
+// IsContainerNotFound returns true if the error is a ContainerNotFoundError.
+func IsContainerNotFound(err error) bool {
+	_, ok := err.(*ContainerNotFoundError)
+	return ok
+}
+
+// validateContainerMetrics checks that the containers referenced by the container
+// resource metrics exist in the pod template, workloads without pod template are skipped.
+func validateContainerMetrics(w *Workload, metrics []autoscalingv2.MetricSpec) error {
+	if w.Template == nil {
+		return nil
+	}
+
+	containers := make(map[string]Empty)
+	for _, c := range w.Template.Spec.Containers {
+		containers[c.Name] = Empty{}
+	}
+	for _, metric := range metrics {
+		if metric.ContainerResource == nil {
+			continue
+		}
+		if _, found := containers[metric.ContainerResource.Container]; !found {
+			return &ContainerNotFoundError{Container: metric.ContainerResource.Container}
+		}
+	}
+
+	return nil
+}
+
 func parseMetricSpecForPrometheus(target string, metricType string, metricValue string, annotations map[string]string) (autoscalingv2.MetricSpec, error) {
+	if alias, namedTarget, ok := splitNamedTarget(target); ok {
+		return parseMetricSpecForNamedPrometheus(alias, namedTarget, metricValue, annotations)
+	}
+
 	name, ok := annotations[PrometheusCustomMetric]
 	if !ok {
 		return autoscalingv2.MetricSpec{}, fmt.Errorf("failed to get targetCustomMetric from annotations")
 	}
-	averageValue, err := resource.ParseQuantity(metricValue)
+	if target != targetAverageValue {
+		return autoscalingv2.MetricSpec{}, unsupportedPrometheusTarget(target)
+	}
+	averageValue, err := parseTargetQuantity(metricValue)
 	if err != nil {
-		return autoscalingv2.MetricSpec{}, nil
+		return autoscalingv2.MetricSpec{}, err
 	}
 
 	metricSpec := autoscalingv2.MetricSpec{
@@ -264,21 +489,442 @@ func parseMetricSpecForPrometheus(target string, metricType string, metricValue
 				Name: name,
 			},
 			Target: autoscalingv2.MetricTarget{
+				Type:         autoscalingv2.AverageValueMetricType,
 				AverageValue: &averageValue,
 			},
 		},
 	}
 
+	return metricSpec, nil
+}
+
+// unsupportedPrometheusTarget returns the error of an unsupported prometheus
+// metric target, the external metrics have no utilization.
+func unsupportedPrometheusTarget(target string) error {
+	if target == targetAverageUtilization {
+		return fmt.Errorf("%s is not supported by prometheus metrics, use %s or %s instead", targetAverageUtilization, targetAverageValue, targetValue)
+	}
+	return fmt.Errorf("unsupported prometheus metric target: %s", target)
+}
+
+// splitNamedTarget splits the target in the format of <alias>.<target>
+func splitNamedTarget(target string) (string, string, bool) {
+	index := strings.LastIndex(target, PixiuDot)
+	if index <= 0 || index == len(target)-1 {
+		return "", "", false
+	}
+
+	return target[:index], target[index+1:], true
+}
+
+func isPrometheusAttribute(target string) bool {
+	_, field, ok := splitNamedTarget(target)
+	if !ok {
+		return false
+	}
+	switch field {
+	case prometheusMetric, prometheusSeriesQuery, prometheusMetricsQuery, prometheusNameMatches, prometheusNameAs, prometheusSelector:
+		return true
+	}
+	return false
+}
+
+// prometheusMetricName returns the metric name of the named prometheus metric,
+// which is the name exposed by prometheus adapter.
+func prometheusMetricName(alias string, annotations map[string]string) string {
+	if name, ok := annotations[prometheusMetricKey(alias, prometheusMetric)]; ok {
+		return name
+	}
+	// nameAs 中可能引用 nameMatches 的分组，此时无法确定最终的指标名称
+	if nameAs, ok := annotations[prometheusMetricKey(alias, prometheusNameAs)]; ok && !strings.Contains(nameAs, "$") {
+		return nameAs
+	}
+	return alias
+}
+
+func prometheusMetricKey(alias string, field string) string {
+	return prometheus + PixiuDot + PixiuRootPrefix + PixiuSeparator + alias + PixiuDot + field
+}
+
+// IsPrometheusMetric returns true if the annotation is the target of a named prometheus metric.
+func IsPrometheusMetric(annotation string) bool {
+	if !strings.HasPrefix(annotation, prometheus+PixiuDot+PixiuRootPrefix+PixiuSeparator) {
+		return false
+	}
+	_, target, err := getMetricTarget(annotation)
+	if err != nil || isPrometheusAttribute(target) {
+		return false
+	}
+	_, _, ok := splitNamedTarget(target)
+	return ok
+}
+
+func parseMetricSpecForNamedPrometheus(alias string, target string, metricValue string, annotations map[string]string) (autoscalingv2.MetricSpec, error) {
+	if target != targetAverageValue && target != targetValue {
+		return autoscalingv2.MetricSpec{}, unsupportedPrometheusTarget(target)
+	}
+	value, err := parseTargetQuantity(metricValue)
+	if err != nil {
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("invalid value of prometheus metric %s: %v", alias, err)
+	}
+
+	metricSpec := autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ExternalMetricSourceType,
+		External: &autoscalingv2.ExternalMetricSource{
+			Metric: autoscalingv2.MetricIdentifier{
+				Name: prometheusMetricName(alias, annotations),
+			},
+		},
+	}
+	if selector, ok := annotations[prometheusMetricKey(alias, prometheusSelector)]; ok {
+		labelSelector, err := metav1.ParseToLabelSelector(selector)
+		if err != nil {
+			return autoscalingv2.MetricSpec{}, fmt.Errorf("invalid selector of prometheus metric %s: %v", alias, err)
+		}
+		metricSpec.External.Metric.Selector = labelSelector
+	}
+
 	switch target {
-	case targetAverageUtilization:
-		metricSpec.External.Target.Type = autoscalingv2.UtilizationMetricType
 	case targetAverageValue:
-		metricSpec.External.Target.Type = autoscalingv2.AverageValueMetricType
+		metricSpec.External.Target = autoscalingv2.MetricTarget{
+			Type: autoscalingv2.AverageValueMetricType, AverageValue: &value,
+		}
+	case targetValue:
+		metricSpec.External.Target = autoscalingv2.MetricTarget{
+			Type: autoscalingv2.ValueMetricType, Value: &value,
+		}
+	default:
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("unsupported target %s of prometheus metric %s", target, alias)
 	}
 
 	return metricSpec, nil
 }
 
+// parseExternalRules builds the prometheus adapter external rules for the
+// prometheus metrics in annotations.
+func parseExternalRules(annotations map[string]string) ([]ExternalRule, error) {
+	var externalRules []ExternalRule
+	for key := range annotations {
+		if !IsPrometheusMetric(key) {
+			continue
+		}
+		_, target, _ := getMetricTarget(key)
+		alias, _, _ := splitNamedTarget(target)
+
+		externalRule := externalRuleFor(alias, annotations)
+		if err := ValidateExternalRule(externalRule); err != nil {
+			return nil, fmt.Errorf("invalid prometheus metric %s: %v", alias, err)
+		}
+		externalRules = append(externalRules, externalRule)
+	}
+
+	// 兼容单个 prometheus 指标的注释
+	if name, ok := annotations[PrometheusCustomMetric]; ok {
+		externalRules = append(externalRules, NewExternalRule(name))
+	}
+
+	return UniqueExternalRules(externalRules), nil
+}
+
+// externalRuleFor builds the external rule of the named prometheus metric.
+func externalRuleFor(alias string, annotations map[string]string) ExternalRule {
+	seriesQuery, ok := annotations[prometheusMetricKey(alias, prometheusSeriesQuery)]
+	if !ok {
+		seriesQuery = prometheusMetricName(alias, annotations)
+	}
+	externalRule := NewExternalRule(seriesQuery)
+	if metricsQuery, ok := annotations[prometheusMetricKey(alias, prometheusMetricsQuery)]; ok {
+		externalRule.MetricsQuery = metricsQuery
+	}
+	externalRule.Name.Matches = annotations[prometheusMetricKey(alias, prometheusNameMatches)]
+	externalRule.Name.As = annotations[prometheusMetricKey(alias, prometheusNameAs)]
+
+	return externalRule
+}
+
+// ValidateExternalRule validates the series query, the name matches regexp and
+// the metrics query template of the external rule, the template is executed
+// with the same data that prometheus adapter uses.
+func ValidateExternalRule(rule ExternalRule) error {
+	if len(strings.TrimSpace(rule.SeriesQuery)) == 0 {
+		return fmt.Errorf("seriesQuery should not be empty")
+	}
+	if len(rule.Name.Matches) != 0 {
+		if _, err := regexp.Compile(rule.Name.Matches); err != nil {
+			return fmt.Errorf("invalid nameMatches %q: %v", rule.Name.Matches, err)
+		}
+	}
+	if len(rule.Name.As) != 0 && len(rule.Name.Matches) == 0 && strings.Contains(rule.Name.As, "$") {
+		return fmt.Errorf("nameAs %q refers to capture groups but nameMatches is not set", rule.Name.As)
+	}
+
+	tpl, err := template.New("metricsQuery").Delims("<<", ">>").Parse(rule.MetricsQuery)
+	if err != nil {
+		return fmt.Errorf("invalid metricsQuery %q: %v", rule.MetricsQuery, err)
+	}
+	queryData := struct {
+		Series            string
+		LabelMatchers     string
+		LabelValuesByName map[string][]string
+		GroupBy           string
+		GroupBySlice      []string
+	}{
+		Series:            "series",
+		LabelMatchers:     `namespace="default"`,
+		LabelValuesByName: map[string][]string{"namespace": {"default"}},
+		GroupBy:           "namespace",
+		GroupBySlice:      []string{"namespace"},
+	}
+	if err = tpl.Execute(io.Discard, queryData); err != nil {
+		return fmt.Errorf("invalid metricsQuery %q: %v", rule.MetricsQuery, err)
+	}
+
+	return nil
+}
+
+// parseBehavior parses the scaleUp and scaleDown rules from annotations, it
+// returns nil if none of the behavior annotations is set.
+func parseBehavior(annotations map[string]string) (*autoscalingv2.HorizontalPodAutoscalerBehavior, error) {
+	scaleUpRules, err := parseScalingRules(annotations, scaleUp)
+	if err != nil {
+		return nil, err
+	}
+	scaleDownRules, err := parseScalingRules(annotations, scaleDown)
+	if err != nil {
+		return nil, err
+	}
+	if scaleUpRules == nil && scaleDownRules == nil {
+		return nil, nil
+	}
+
+	// apiserver 会为 behavior 中未设置的字段填充默认值，此处保持一致，避免每次同步都判定 HPA 发生变化
+	return &autoscalingv2.HorizontalPodAutoscalerBehavior{
+		ScaleUp:   generateScaleUpRules(scaleUpRules),
+		ScaleDown: generateScaleDownRules(scaleDownRules),
+	}, nil
+}
+
+func parseScalingRules(annotations map[string]string, direction string) (*autoscalingv2.HPAScalingRules, error) {
+	prefix := PixiuRootPrefix + PixiuSeparator + direction + PixiuDot
+
+	var rules *autoscalingv2.HPAScalingRules
+	if value, ok := annotations[prefix+stabilizationWindowSeconds]; ok {
+		window, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", prefix+stabilizationWindowSeconds, err)
+		}
+		if window < 0 || window > 3600 {
+			return nil, fmt.Errorf("%s should be range 0 between 3600", prefix+stabilizationWindowSeconds)
+		}
+		rules = &autoscalingv2.HPAScalingRules{}
+		rules.StabilizationWindowSeconds = utilpointer.Int32Ptr(int32(window))
+	}
+
+	if value, ok := annotations[prefix+selectPolicy]; ok {
+		policy := autoscalingv2.ScalingPolicySelect(value)
+		switch policy {
+		case autoscalingv2.MaxChangePolicySelect, autoscalingv2.MinChangePolicySelect, autoscalingv2.DisabledPolicySelect:
+		default:
+			return nil, fmt.Errorf("unsupported %s: %s", prefix+selectPolicy, value)
+		}
+		if rules == nil {
+			rules = &autoscalingv2.HPAScalingRules{}
+		}
+		rules.SelectPolicy = &policy
+	}
+
+	if value, ok := annotations[prefix+policies]; ok {
+		scalingPolicies, err := parseScalingPolicies(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", prefix+policies, err)
+		}
+		if rules == nil {
+			rules = &autoscalingv2.HPAScalingRules{}
+		}
+		rules.Policies = scalingPolicies
+	}
+
+	return rules, nil
+}
+
+// parseScalingPolicies parses the policies in the format of type:value:periodSeconds,
+// multiple policies are separated by comma, such as Pods:4:60,Percent:100:15
+func parseScalingPolicies(value string) ([]autoscalingv2.HPAScalingPolicy, error) {
+	var scalingPolicies []autoscalingv2.HPAScalingPolicy
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("policy %q should be in the format of type:value:periodSeconds", item)
+		}
+
+		policyType := autoscalingv2.HPAScalingPolicyType(parts[0])
+		if policyType != autoscalingv2.PodsScalingPolicy && policyType != autoscalingv2.PercentScalingPolicy {
+			return nil, fmt.Errorf("unsupported policy type %s", parts[0])
+		}
+		policyValue, err := strconv.ParseInt(parts[1], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if policyValue <= 0 {
+			return nil, fmt.Errorf("policy value should be greater than 0")
+		}
+		periodSeconds, err := strconv.ParseInt(parts[2], 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		if periodSeconds <= 0 || periodSeconds > 1800 {
+			return nil, fmt.Errorf("policy periodSeconds should be range 1 between 1800")
+		}
+
+		scalingPolicies = append(scalingPolicies, autoscalingv2.HPAScalingPolicy{
+			Type:          policyType,
+			Value:         int32(policyValue),
+			PeriodSeconds: int32(periodSeconds),
+		})
+	}
+
+	return scalingPolicies, nil
+}
+
+// generateScaleUpRules fills the unset fields with the defaults of apiserver.
+func generateScaleUpRules(rules *autoscalingv2.HPAScalingRules) *autoscalingv2.HPAScalingRules {
+	maxPolicy := autoscalingv2.MaxChangePolicySelect
+	defaultRules := &autoscalingv2.HPAScalingRules{
+		StabilizationWindowSeconds: utilpointer.Int32Ptr(0),
+		SelectPolicy:               &maxPolicy,
+		Policies: []autoscalingv2.HPAScalingPolicy{
+			{Type: autoscalingv2.PodsScalingPolicy, Value: 4, PeriodSeconds: 15},
+			{Type: autoscalingv2.PercentScalingPolicy, Value: 100, PeriodSeconds: 15},
+		},
+	}
+	return copyScalingRules(rules, defaultRules)
+}
+
+// generateScaleDownRules fills the unset fields with the defaults of apiserver.
+func generateScaleDownRules(rules *autoscalingv2.HPAScalingRules) *autoscalingv2.HPAScalingRules {
+	maxPolicy := autoscalingv2.MaxChangePolicySelect
+	defaultRules := &autoscalingv2.HPAScalingRules{
+		StabilizationWindowSeconds: nil,
+		SelectPolicy:               &maxPolicy,
+		Policies: []autoscalingv2.HPAScalingPolicy{
+			{Type: autoscalingv2.PercentScalingPolicy, Value: 100, PeriodSeconds: 15},
+		},
+	}
+	return copyScalingRules(rules, defaultRules)
+}
+
+func copyScalingRules(from, to *autoscalingv2.HPAScalingRules) *autoscalingv2.HPAScalingRules {
+	if from == nil {
+		return to
+	}
+	if from.SelectPolicy != nil {
+		to.SelectPolicy = from.SelectPolicy
+	}
+	if from.StabilizationWindowSeconds != nil {
+		to.StabilizationWindowSeconds = from.StabilizationWindowSeconds
+	}
+	if from.Policies != nil {
+		to.Policies = from.Policies
+	}
+	return to
+}
+
+func parseMetricSpecForPods(target string, metricType string, metricValue string, annotations map[string]string) (autoscalingv2.MetricSpec, error) {
+	name, ok := annotations[PodsCustomMetric]
+	if !ok {
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("failed to get targetPodsMetric from annotations")
+	}
+	if target != targetAverageValue {
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("unsupported pods metric target: %s, only targetAverageValue is allowed", target)
+	}
+	averageValue, err := parseTargetQuantity(metricValue)
+	if err != nil {
+		return autoscalingv2.MetricSpec{}, err
+	}
+
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.PodsMetricSourceType,
+		Pods: &autoscalingv2.PodsMetricSource{
+			Metric: autoscalingv2.MetricIdentifier{
+				Name: name,
+			},
+			Target: autoscalingv2.MetricTarget{
+				Type:         autoscalingv2.AverageValueMetricType,
+				AverageValue: &averageValue,
+			},
+		},
+	}, nil
+}
+
+func parseMetricSpecForObject(target string, metricType string, metricValue string, annotations map[string]string) (autoscalingv2.MetricSpec, error) {
+	name, ok := annotations[ObjectCustomMetric]
+	if !ok {
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("failed to get targetObjectMetric from annotations")
+	}
+	describedObject, ok := annotations[ObjectCustomTarget]
+	if !ok {
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("failed to get targetObject from annotations")
+	}
+	objectReference, err := parseObjectReference(describedObject)
+	if err != nil {
+		return autoscalingv2.MetricSpec{}, err
+	}
+	value, err := parseTargetQuantity(metricValue)
+	if err != nil {
+		return autoscalingv2.MetricSpec{}, err
+	}
+
+	metricSpec := autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ObjectMetricSourceType,
+		Object: &autoscalingv2.ObjectMetricSource{
+			DescribedObject: objectReference,
+			Metric: autoscalingv2.MetricIdentifier{
+				Name: name,
+			},
+		},
+	}
+
+	switch target {
+	case targetValue:
+		metricSpec.Object.Target = autoscalingv2.MetricTarget{
+			Type: autoscalingv2.ValueMetricType, Value: &value,
+		}
+	case targetAverageValue:
+		metricSpec.Object.Target = autoscalingv2.MetricTarget{
+			Type: autoscalingv2.AverageValueMetricType, AverageValue: &value,
+		}
+	default:
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("unsupported object metric target: %s", target)
+	}
+
+	return metricSpec, nil
+}
+
+// parseObjectReference parses the described object in the format of <apiVersion>/<kind>/<name>,
+// the apiVersion is required since the adapter rule and the HPA both need the group of the object.
+func parseObjectReference(describedObject string) (autoscalingv2.CrossVersionObjectReference, error) {
+	parts := strings.Split(describedObject, PixiuSeparator)
+	// apiVersion 为 <version> 或 <group>/<version>
+	if len(parts) != 3 && len(parts) != 4 {
+		return autoscalingv2.CrossVersionObjectReference{}, fmt.Errorf("invalid targetObject %s, it should be in the format of <apiVersion>/<kind>/<name>", describedObject)
+	}
+
+	reference := autoscalingv2.CrossVersionObjectReference{
+		APIVersion: strings.Join(parts[:len(parts)-2], PixiuSeparator),
+		Kind:       parts[len(parts)-2],
+		Name:       parts[len(parts)-1],
+	}
+	if len(reference.Kind) == 0 || len(reference.Name) == 0 {
+		return autoscalingv2.CrossVersionObjectReference{}, fmt.Errorf("invalid targetObject %s, it should be in the format of <apiVersion>/<kind>/<name>", describedObject)
+	}
+	gv, err := schema.ParseGroupVersion(reference.APIVersion)
+	if err != nil || len(gv.Version) == 0 || (len(parts) == 4 && len(gv.Group) == 0) {
+		return autoscalingv2.CrossVersionObjectReference{}, fmt.Errorf("invalid targetObject %s, the apiVersion %q is invalid", describedObject, reference.APIVersion)
+	}
+
+	return reference, nil
+}
+
 func IsOwnerReference(uid types.UID, ownerReferences []metav1.OwnerReference) bool {
 	var isOwnerRef bool
 	for _, ownerReference := range ownerReferences {
@@ -290,12 +936,18 @@ func IsOwnerReference(uid types.UID, ownerReferences []metav1.OwnerReference) bo
 	return isOwnerRef
 }
 
+// ManageByPixiuController returns true if the HPA is applied by controller
+// with the field manager PixiuManager, or is created by the previous releases
+// with the legacy field managers.
 func ManageByPixiuController(hpa *autoscalingv2.HorizontalPodAutoscaler) bool {
 	for _, managedField := range hpa.ManagedFields {
-		if managedField.APIVersion == AutoscalingAPIVersion &&
-			(managedField.Manager == PixiuManager ||
-				// This condition used for local run
-				managedField.Manager == PixiuMain) {
+		if managedField.APIVersion != AutoscalingAPIVersion {
+			continue
+		}
+		if managedField.Manager == PixiuManager && managedField.Operation == metav1.ManagedFieldsOperationApply {
+			return true
+		}
+		if isLegacyManagedField(managedField) {
 			return true
 		}
 	}
@@ -303,6 +955,36 @@ func ManageByPixiuController(hpa *autoscalingv2.HorizontalPodAutoscaler) bool {
 	return false
 }
 
+// HasLegacyManagedFields returns true if any field of the HPA is still owned by
+// the legacy field managers of controller.
+func HasLegacyManagedFields(hpa *autoscalingv2.HorizontalPodAutoscaler) bool {
+	for _, managedField := range hpa.ManagedFields {
+		if isLegacyManagedField(managedField) {
+			return true
+		}
+	}
+	return false
+}
+
+// RemoveLegacyManagedFields returns the managed fields without the entries of
+// the legacy field managers of controller.
+func RemoveLegacyManagedFields(managedFields []metav1.ManagedFieldsEntry) []metav1.ManagedFieldsEntry {
+	var remained []metav1.ManagedFieldsEntry
+	for _, managedField := range managedFields {
+		if !isLegacyManagedField(managedField) {
+			remained = append(remained, managedField)
+		}
+	}
+	return remained
+}
+
+// isLegacyManagedField returns true if the entry is recorded by the create or
+// update requests of the previous releases.
+func isLegacyManagedField(managedField metav1.ManagedFieldsEntry) bool {
+	return (managedField.Manager == LegacyPixiuManager || managedField.Manager == LegacyPixiuMain) &&
+		managedField.Operation == metav1.ManagedFieldsOperationUpdate
+}
+
 func extractReplicas(annotations map[string]string, replicasType string) (int32, error) {
 	var (
 		Replicas string
@@ -317,7 +999,7 @@ func extractReplicas(annotations map[string]string, replicasType string) (int32,
 	case MaxReplicas:
 		Replicas, exists = annotations[MaxReplicas]
 		if !exists {
-			return 6, nil // Default maxReplicas is 6
+			return defaultMaxReplicas, nil // Default maxReplicas is 6
 		}
 	}
 
@@ -333,22 +1015,159 @@ func extractAverageUtilization(averageUtilization string) (int32, error) {
 	if err != nil {
 		return 0, err
 	}
-	if value64 <= 0 && value64 > 100 {
+	if value64 <= 0 || value64 > 100 {
 		return 0, fmt.Errorf("averageUtilization should be range 1 between 100")
 	}
 
 	return int32(value64), nil
 }
 
+// parseTargetQuantity parses the quantity of a metric target, which must be positive.
+func parseTargetQuantity(value string) (resource.Quantity, error) {
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return resource.Quantity{}, err
+	}
+	if quantity.Sign() <= 0 {
+		return resource.Quantity{}, fmt.Errorf("quantity %s should be greater than 0", value)
+	}
+	return quantity, nil
+}
+
+// sortedKeys returns the keys of the map in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 // Empty is public since it is used by some internal API objects for conversions between external
 // string arrays and internal sets, and conversion logic requires public types today.
 type Empty struct{}
 
 func NewItems() map[string]Empty {
 	items := make(map[string]Empty)
-	for _, k := range []string{cpuAverageUtilization, memoryAverageUtilization, prometheusAverageUtilization, cpuAverageValue, memoryAverageValue, prometheusAverageValue} {
+	for _, k := range []string{cpuAverageUtilization, memoryAverageUtilization, prometheusAverageUtilization, cpuAverageValue, memoryAverageValue, prometheusAverageValue,
+		podsAverageValue, objectValue, objectAverageValue} {
 		items[k] = Empty{}
 	}
 
 	return items
 }
+
+// NewPodsRule builds the prometheus adapter custom metrics rule for a Pods metric,
+// the series are expected to be labelled with namespace and pod.
+func NewPodsRule(source *autoscalingv2.PodsMetricSource) Rule {
+	return Rule{
+		MetricsQuery: "sum(<<.Series>>{<<.LabelMatchers>>}) by (<<.GroupBy>>)",
+		Resources: ResourceMap{
+			Overrides: map[string]ResourceOverride{
+				"namespace": {Resource: "namespace"},
+				"pod":       {Resource: "pod"},
+			},
+		},
+		SeriesQuery: fmt.Sprintf(`%s{namespace!="",pod!=""}`, source.Metric.Name),
+	}
+}
+
+// NewObjectRule builds the prometheus adapter custom metrics rule for an Object metric,
+// the series are expected to be labelled with namespace and the lower-cased kind of
+// the described object, such as service or ingress.
+func NewObjectRule(source *autoscalingv2.ObjectMetricSource) Rule {
+	gv, _ := schema.ParseGroupVersion(source.DescribedObject.APIVersion)
+	label := strings.ToLower(source.DescribedObject.Kind)
+
+	return Rule{
+		MetricsQuery: "sum(<<.Series>>{<<.LabelMatchers>>}) by (<<.GroupBy>>)",
+		Resources: ResourceMap{
+			Overrides: map[string]ResourceOverride{
+				"namespace": {Resource: "namespace"},
+				label:       {Group: gv.Group, Resource: label},
+			},
+		},
+		SeriesQuery: fmt.Sprintf(`%s{namespace!="",%s!=""}`, source.Metric.Name, label),
+	}
+}
+
+// UniqueRules removes the duplicated rules and sorts them, so that the
+// generated adapter config is stable.
+func UniqueRules(rules []Rule) []Rule {
+	externalRules := make([]ExternalRule, 0, len(rules))
+	for _, rule := range rules {
+		externalRules = append(externalRules, ExternalRule(rule))
+	}
+
+	var unique []Rule
+	for _, externalRule := range UniqueExternalRules(externalRules) {
+		unique = append(unique, Rule(externalRule))
+	}
+	return unique
+}
+
+// NewExternalRule builds the prometheus adapter external rule for the series query.
+func NewExternalRule(seriesQuery string) ExternalRule {
+	return ExternalRule{
+		MetricsQuery: DefaultMetricsQuery,
+		Name: RuleName{
+			As:      "",
+			Matches: "",
+		},
+		Resources: ResourceMap{
+			Overrides: map[string]ResourceOverride{
+				"namespace": {Resource: "namespace"},
+			},
+		},
+		SeriesQuery: seriesQuery,
+	}
+}
+
+// ExternalRulesForHPA returns the external rules required by the HPA, which are
+// recorded in its annotations. HPAs created by older versions have no such
+// annotation, the rules are built from the external metrics in spec instead.
+func ExternalRulesForHPA(hpa *autoscalingv2.HorizontalPodAutoscaler) ([]ExternalRule, error) {
+	if data, ok := hpa.Annotations[ExternalRulesAnnotation]; ok {
+		var externalRules []ExternalRule
+		if err := json.Unmarshal([]byte(data), &externalRules); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal %s of hpa(%s): %v", ExternalRulesAnnotation, hpa.Name, err)
+		}
+		return externalRules, nil
+	}
+
+	var externalRules []ExternalRule
+	for _, metric := range hpa.Spec.Metrics {
+		if metric.Type == autoscalingv2.ExternalMetricSourceType && metric.External != nil {
+			externalRules = append(externalRules, NewExternalRule(metric.External.Metric.Name))
+		}
+	}
+	return externalRules, nil
+}
+
+// UniqueExternalRules removes the duplicated rules and sorts them, so that the
+// generated adapter config is stable.
+func UniqueExternalRules(externalRules []ExternalRule) []ExternalRule {
+	if len(externalRules) == 0 {
+		return nil
+	}
+
+	seen := make(map[string]ExternalRule)
+	keys := make([]string, 0)
+	for _, rule := range externalRules {
+		data, _ := json.Marshal(rule)
+		key := string(data)
+		if _, found := seen[key]; found {
+			continue
+		}
+		seen[key] = rule
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	unique := make([]ExternalRule, 0, len(keys))
+	for _, key := range keys {
+		unique = append(unique, seen[key])
+	}
+	return unique
+}
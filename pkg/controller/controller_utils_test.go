@@ -0,0 +1,917 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilpointer "k8s.io/utils/pointer"
+)
+
+func TestManageByPixiuController(t *testing.T) {
+	tests := []struct {
+		name         string
+		managedField metav1.ManagedFieldsEntry
+		managed      bool
+		legacy       bool
+	}{
+		{
+			name:         "applied by controller",
+			managedField: metav1.ManagedFieldsEntry{Manager: PixiuManager, Operation: metav1.ManagedFieldsOperationApply, APIVersion: AutoscalingAPIVersion},
+			managed:      true,
+		},
+		{
+			name:         "updated by legacy controller",
+			managedField: metav1.ManagedFieldsEntry{Manager: LegacyPixiuManager, Operation: metav1.ManagedFieldsOperationUpdate, APIVersion: AutoscalingAPIVersion},
+			managed:      true,
+			legacy:       true,
+		},
+		{
+			name:         "updated by legacy controller running locally",
+			managedField: metav1.ManagedFieldsEntry{Manager: LegacyPixiuMain, Operation: metav1.ManagedFieldsOperationUpdate, APIVersion: AutoscalingAPIVersion},
+			managed:      true,
+			legacy:       true,
+		},
+		{
+			name:         "updated by other manager",
+			managedField: metav1.ManagedFieldsEntry{Manager: "kubectl-edit", Operation: metav1.ManagedFieldsOperationUpdate, APIVersion: AutoscalingAPIVersion},
+		},
+		{
+			name:         "other api version",
+			managedField: metav1.ManagedFieldsEntry{Manager: PixiuManager, Operation: metav1.ManagedFieldsOperationApply, APIVersion: "autoscaling/v1"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hpa := &autoscalingv2.HorizontalPodAutoscaler{
+				ObjectMeta: metav1.ObjectMeta{ManagedFields: []metav1.ManagedFieldsEntry{test.managedField}},
+			}
+			if managed := ManageByPixiuController(hpa); managed != test.managed {
+				t.Errorf("expected managed %v, got %v", test.managed, managed)
+			}
+			if legacy := HasLegacyManagedFields(hpa); legacy != test.legacy {
+				t.Errorf("expected legacy %v, got %v", test.legacy, legacy)
+			}
+		})
+	}
+}
+
+func TestRemoveLegacyManagedFields(t *testing.T) {
+	managedFields := []metav1.ManagedFieldsEntry{
+		{Manager: LegacyPixiuManager, Operation: metav1.ManagedFieldsOperationUpdate, APIVersion: AutoscalingAPIVersion},
+		{Manager: PixiuManager, Operation: metav1.ManagedFieldsOperationApply, APIVersion: AutoscalingAPIVersion},
+		{Manager: "kube-controller-manager", Operation: metav1.ManagedFieldsOperationUpdate, APIVersion: AutoscalingAPIVersion, Subresource: "status"},
+	}
+
+	remained := RemoveLegacyManagedFields(managedFields)
+	if len(remained) != 2 || remained[0].Manager != PixiuManager || remained[1].Manager != "kube-controller-manager" {
+		t.Errorf("expected the managed fields of %s and kube-controller-manager, got %v", PixiuManager, remained)
+	}
+}
+
+func TestParseBehavior(t *testing.T) {
+	maxPolicy := autoscalingv2.MaxChangePolicySelect
+	minPolicy := autoscalingv2.MinChangePolicySelect
+	// apiserver 为 behavior 填充的默认值
+	defaultScaleUp := func() *autoscalingv2.HPAScalingRules {
+		return &autoscalingv2.HPAScalingRules{
+			StabilizationWindowSeconds: utilpointer.Int32Ptr(0),
+			SelectPolicy:               &maxPolicy,
+			Policies: []autoscalingv2.HPAScalingPolicy{
+				{Type: autoscalingv2.PodsScalingPolicy, Value: 4, PeriodSeconds: 15},
+				{Type: autoscalingv2.PercentScalingPolicy, Value: 100, PeriodSeconds: 15},
+			},
+		}
+	}
+	defaultScaleDown := func() *autoscalingv2.HPAScalingRules {
+		return &autoscalingv2.HPAScalingRules{
+			SelectPolicy: &maxPolicy,
+			Policies: []autoscalingv2.HPAScalingPolicy{
+				{Type: autoscalingv2.PercentScalingPolicy, Value: 100, PeriodSeconds: 15},
+			},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    *autoscalingv2.HorizontalPodAutoscalerBehavior
+		expectErr   bool
+	}{
+		{
+			name: "no behavior",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+		},
+		{
+			name: "scaleDown window fills the defaults",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/scaleDown.stabilizationWindowSeconds": "300",
+			},
+			expected: &autoscalingv2.HorizontalPodAutoscalerBehavior{
+				ScaleUp: defaultScaleUp(),
+				ScaleDown: &autoscalingv2.HPAScalingRules{
+					StabilizationWindowSeconds: utilpointer.Int32Ptr(300),
+					SelectPolicy:               &maxPolicy,
+					Policies: []autoscalingv2.HPAScalingPolicy{
+						{Type: autoscalingv2.PercentScalingPolicy, Value: 100, PeriodSeconds: 15},
+					},
+				},
+			},
+		},
+		{
+			name: "scaleUp policies and select policy",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/scaleUp.selectPolicy": "Min",
+				"hpa.caoyingjunz.io/scaleUp.policies":     "Pods:2:60, Percent:50:30",
+			},
+			expected: &autoscalingv2.HorizontalPodAutoscalerBehavior{
+				ScaleUp: &autoscalingv2.HPAScalingRules{
+					StabilizationWindowSeconds: utilpointer.Int32Ptr(0),
+					SelectPolicy:               &minPolicy,
+					Policies: []autoscalingv2.HPAScalingPolicy{
+						{Type: autoscalingv2.PodsScalingPolicy, Value: 2, PeriodSeconds: 60},
+						{Type: autoscalingv2.PercentScalingPolicy, Value: 50, PeriodSeconds: 30},
+					},
+				},
+				ScaleDown: defaultScaleDown(),
+			},
+		},
+		{
+			name:        "window out of range",
+			annotations: map[string]string{"hpa.caoyingjunz.io/scaleUp.stabilizationWindowSeconds": "3601"},
+			expectErr:   true,
+		},
+		{
+			name:        "unsupported select policy",
+			annotations: map[string]string{"hpa.caoyingjunz.io/scaleDown.selectPolicy": "Avg"},
+			expectErr:   true,
+		},
+		{
+			name:        "policy without period",
+			annotations: map[string]string{"hpa.caoyingjunz.io/scaleDown.policies": "Pods:2"},
+			expectErr:   true,
+		},
+		{
+			name:        "unsupported policy type",
+			annotations: map[string]string{"hpa.caoyingjunz.io/scaleDown.policies": "Replicas:2:60"},
+			expectErr:   true,
+		},
+		{
+			name:        "policy period out of range",
+			annotations: map[string]string{"hpa.caoyingjunz.io/scaleDown.policies": "Percent:10:1801"},
+			expectErr:   true,
+		},
+		{
+			name:        "policy value not positive",
+			annotations: map[string]string{"hpa.caoyingjunz.io/scaleDown.policies": "Percent:0:60"},
+			expectErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			behavior, err := parseBehavior(test.annotations)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if !reflect.DeepEqual(behavior, test.expected) {
+				t.Errorf("expected behavior %+v, got %+v", test.expected, behavior)
+			}
+		})
+	}
+}
+
+// newTestWorkload returns the workload of a deployment with the annotations,
+// the pod template holds the given containers.
+func newTestWorkload(annotations map[string]string, containers ...string) *Workload {
+	d := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "test1", Namespace: "default", Annotations: annotations},
+	}
+	for _, container := range containers {
+		d.Spec.Template.Spec.Containers = append(d.Spec.Template.Spec.Containers, v1.Container{Name: container, Image: container})
+	}
+	return NewWorkloadFromDeployment(d)
+}
+
+func TestContainerMetrics(t *testing.T) {
+	sixtyMi := resource.MustParse("60Mi")
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		// generic 为 true 时工作负载没有 pod 模板，例如通过 metadata informer 获取的对象
+		generic           bool
+		expected          []autoscalingv2.MetricSpec
+		containerNotFound bool
+		expectErr         bool
+	}{
+		{
+			name: "container utilization",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/container.nginx.targetAverageUtilization": "60",
+			},
+			expected: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.ContainerResourceMetricSourceType,
+				ContainerResource: &autoscalingv2.ContainerResourceMetricSource{
+					Name:      v1.ResourceCPU,
+					Container: "nginx",
+					Target:    autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: utilpointer.Int32Ptr(60)},
+				},
+			}},
+		},
+		{
+			name: "container average value with dotted name",
+			annotations: map[string]string{
+				"memory.hpa.caoyingjunz.io/container.envoy.v2.targetAverageValue": "60Mi",
+			},
+			expected: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.ContainerResourceMetricSourceType,
+				ContainerResource: &autoscalingv2.ContainerResourceMetricSource{
+					Name:      v1.ResourceMemory,
+					Container: "envoy.v2",
+					Target:    autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &sixtyMi},
+				},
+			}},
+		},
+		{
+			name: "container not found",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/container.sidecar.targetAverageUtilization": "60",
+			},
+			containerNotFound: true,
+			expectErr:         true,
+		},
+		{
+			name: "container not checked without pod template",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/container.sidecar.targetAverageUtilization": "60",
+			},
+			generic: true,
+			expected: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.ContainerResourceMetricSourceType,
+				ContainerResource: &autoscalingv2.ContainerResourceMetricSource{
+					Name:      v1.ResourceCPU,
+					Container: "sidecar",
+					Target:    autoscalingv2.MetricTarget{Type: autoscalingv2.UtilizationMetricType, AverageUtilization: utilpointer.Int32Ptr(60)},
+				},
+			}},
+		},
+		{
+			name: "unsupported container target",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/container.nginx.targetValue": "1",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := newTestWorkload(test.annotations, "nginx", "envoy.v2")
+			if test.generic {
+				w.Template = nil
+			}
+
+			hpa, err := CreateHPAFromWorkload(w, time.Now())
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if IsContainerNotFound(err) != test.containerNotFound {
+				t.Errorf("expected container not found %v, got %v", test.containerNotFound, err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(hpa.Spec.Metrics, test.expected) {
+				t.Errorf("expected metrics %+v, got %+v", test.expected, hpa.Spec.Metrics)
+			}
+		})
+	}
+}
+
+func TestIsContainerMetric(t *testing.T) {
+	for annotation, expected := range map[string]bool{
+		"cpu.hpa.caoyingjunz.io/container.nginx.targetAverageUtilization": true,
+		"memory.hpa.caoyingjunz.io/container.nginx.targetAverageValue":    true,
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization":                 false,
+		"prometheus.hpa.caoyingjunz.io/container.targetAverageValue":      false,
+		"cpu.hpa.caoyingjunz.io/container.targetAverageUtilization":       false,
+	} {
+		if IsContainerMetric(annotation) != expected {
+			t.Errorf("expected IsContainerMetric(%s) %v", annotation, expected)
+		}
+	}
+}
+
+func TestParseObjectReference(t *testing.T) {
+	tests := []struct {
+		name            string
+		describedObject string
+		expected        autoscalingv2.CrossVersionObjectReference
+		expectErr       bool
+	}{
+		{
+			name:            "group version",
+			describedObject: "networking.k8s.io/v1/Ingress/main-route",
+			expected:        autoscalingv2.CrossVersionObjectReference{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Name: "main-route"},
+		},
+		{
+			name:            "core version",
+			describedObject: "v1/Service/queue",
+			expected:        autoscalingv2.CrossVersionObjectReference{APIVersion: "v1", Kind: "Service", Name: "queue"},
+		},
+		{
+			// 省略 apiVersion 时无法确定对象的 group
+			name:            "without apiVersion",
+			describedObject: "Service/queue",
+			expectErr:       true,
+		},
+		{
+			name:            "empty group",
+			describedObject: "/v1/Service/queue",
+			expectErr:       true,
+		},
+		{
+			name:            "empty version",
+			describedObject: "networking.k8s.io//Ingress/main-route",
+			expectErr:       true,
+		},
+		{
+			name:            "too many parts",
+			describedObject: "networking.k8s.io/v1/extra/Ingress/main-route",
+			expectErr:       true,
+		},
+		{
+			name:            "only name",
+			describedObject: "queue",
+			expectErr:       true,
+		},
+		{
+			name:            "empty kind",
+			describedObject: "v1//queue",
+			expectErr:       true,
+		},
+		{
+			name:            "empty name",
+			describedObject: "Service/",
+			expectErr:       true,
+		},
+		{
+			name:            "empty apiVersion",
+			describedObject: "/Service/queue",
+			expectErr:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reference, err := parseObjectReference(test.describedObject)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if reference != test.expected {
+				t.Errorf("expected reference %+v, got %+v", test.expected, reference)
+			}
+		})
+	}
+}
+
+func TestPodsAndObjectMetrics(t *testing.T) {
+	hundred := resource.MustParse("100")
+	tenK := resource.MustParse("10k")
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    []autoscalingv2.MetricSpec
+		// expectedRules 为根据指标生成的 prometheus-adapter 配置
+		expectedRules string
+		expectErr     bool
+	}{
+		{
+			name: "pods metric",
+			annotations: map[string]string{
+				"pods.hpa.caoyingjunz.io/targetAverageValue": "100",
+				PodsCustomMetric: "http_requests_per_second",
+			},
+			expected: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.PodsMetricSourceType,
+				Pods: &autoscalingv2.PodsMetricSource{
+					Metric: autoscalingv2.MetricIdentifier{Name: "http_requests_per_second"},
+					Target: autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &hundred},
+				},
+			}},
+			expectedRules: `rules:
+  - metricsQuery: sum(<<.Series>>{<<.LabelMatchers>>}) by (<<.GroupBy>>)
+    name:
+      as: ""
+      matches: ""
+    resources:
+      overrides:
+        namespace:
+          resource: namespace
+        pod:
+          resource: pod
+    seriesQuery: http_requests_per_second{namespace!="",pod!=""}
+`,
+		},
+		{
+			name: "object metric value",
+			annotations: map[string]string{
+				"object.hpa.caoyingjunz.io/targetValue": "10k",
+				ObjectCustomMetric:                      "requests_per_second",
+				ObjectCustomTarget:                      "networking.k8s.io/v1/Ingress/main-route",
+			},
+			expected: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.ObjectMetricSourceType,
+				Object: &autoscalingv2.ObjectMetricSource{
+					DescribedObject: autoscalingv2.CrossVersionObjectReference{APIVersion: "networking.k8s.io/v1", Kind: "Ingress", Name: "main-route"},
+					Metric:          autoscalingv2.MetricIdentifier{Name: "requests_per_second"},
+					Target:          autoscalingv2.MetricTarget{Type: autoscalingv2.ValueMetricType, Value: &tenK},
+				},
+			}},
+			expectedRules: `rules:
+  - metricsQuery: sum(<<.Series>>{<<.LabelMatchers>>}) by (<<.GroupBy>>)
+    name:
+      as: ""
+      matches: ""
+    resources:
+      overrides:
+        ingress:
+          group: networking.k8s.io
+          resource: ingress
+        namespace:
+          resource: namespace
+    seriesQuery: requests_per_second{namespace!="",ingress!=""}
+`,
+		},
+		{
+			name: "object metric average value of core object",
+			annotations: map[string]string{
+				"object.hpa.caoyingjunz.io/targetAverageValue": "100",
+				ObjectCustomMetric: "queue_length",
+				ObjectCustomTarget: "v1/Service/queue",
+			},
+			expected: []autoscalingv2.MetricSpec{{
+				Type: autoscalingv2.ObjectMetricSourceType,
+				Object: &autoscalingv2.ObjectMetricSource{
+					DescribedObject: autoscalingv2.CrossVersionObjectReference{APIVersion: "v1", Kind: "Service", Name: "queue"},
+					Metric:          autoscalingv2.MetricIdentifier{Name: "queue_length"},
+					Target:          autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &hundred},
+				},
+			}},
+			expectedRules: `rules:
+  - metricsQuery: sum(<<.Series>>{<<.LabelMatchers>>}) by (<<.GroupBy>>)
+    name:
+      as: ""
+      matches: ""
+    resources:
+      overrides:
+        namespace:
+          resource: namespace
+        service:
+          resource: service
+    seriesQuery: queue_length{namespace!="",service!=""}
+`,
+		},
+		{
+			name: "object metric without apiVersion",
+			annotations: map[string]string{
+				"object.hpa.caoyingjunz.io/targetAverageValue": "100",
+				ObjectCustomMetric: "queue_length",
+				ObjectCustomTarget: "Ingress/main-route",
+			},
+			expectErr: true,
+		},
+		{
+			name: "pods metric without name",
+			annotations: map[string]string{
+				"pods.hpa.caoyingjunz.io/targetAverageValue": "100",
+			},
+			expectErr: true,
+		},
+		{
+			name: "pods metric value",
+			annotations: map[string]string{
+				"pods.hpa.caoyingjunz.io/targetValue": "100",
+				PodsCustomMetric:                      "http_requests_per_second",
+			},
+			expectErr: true,
+		},
+		{
+			name: "object metric without target",
+			annotations: map[string]string{
+				"object.hpa.caoyingjunz.io/targetValue": "10k",
+				ObjectCustomMetric:                      "requests_per_second",
+			},
+			expectErr: true,
+		},
+		{
+			name: "object metric utilization",
+			annotations: map[string]string{
+				"object.hpa.caoyingjunz.io/targetAverageUtilization": "60",
+				ObjectCustomMetric: "requests_per_second",
+				ObjectCustomTarget: "v1/Service/queue",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metricSpecs, err := parseMetricSpecs(test.annotations)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(metricSpecs, test.expected) {
+				t.Fatalf("expected metrics %+v, got %+v", test.expected, metricSpecs)
+			}
+
+			var rules []Rule
+			for _, metricSpec := range metricSpecs {
+				switch metricSpec.Type {
+				case autoscalingv2.PodsMetricSourceType:
+					rules = append(rules, NewPodsRule(metricSpec.Pods))
+				case autoscalingv2.ObjectMetricSourceType:
+					rules = append(rules, NewObjectRule(metricSpec.Object))
+				}
+			}
+			adapterConfig, err := ParseAdapterConfig("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = adapterConfig.SetRules(RulesKey, nil, UniqueRules(rules)); err != nil {
+				t.Fatal(err)
+			}
+			data, err := adapterConfig.String()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data != test.expectedRules {
+				t.Errorf("expected adapter config:\n%s\ngot:\n%s", test.expectedRules, data)
+			}
+		})
+	}
+}
+
+func TestNamedPrometheusMetrics(t *testing.T) {
+	hundred := resource.MustParse("100")
+	thirty := resource.MustParse("30")
+	tenK := resource.MustParse("10k")
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    []autoscalingv2.MetricSpec
+		// expectedRules 为根据指标生成的 prometheus-adapter 配置
+		expectedRules string
+		expectErr     bool
+	}{
+		{
+			name: "multiple named metrics",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/rps.targetAverageValue": "100",
+				"prometheus.hpa.caoyingjunz.io/queue.targetValue":      "30",
+				"prometheus.hpa.caoyingjunz.io/queue.metric":           "rabbitmq_queue_messages",
+			},
+			expected: []autoscalingv2.MetricSpec{
+				{
+					Type: autoscalingv2.ExternalMetricSourceType,
+					External: &autoscalingv2.ExternalMetricSource{
+						Metric: autoscalingv2.MetricIdentifier{Name: "rabbitmq_queue_messages"},
+						Target: autoscalingv2.MetricTarget{Type: autoscalingv2.ValueMetricType, Value: &thirty},
+					},
+				},
+				{
+					Type: autoscalingv2.ExternalMetricSourceType,
+					External: &autoscalingv2.ExternalMetricSource{
+						Metric: autoscalingv2.MetricIdentifier{Name: "rps"},
+						Target: autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &hundred},
+					},
+				},
+			},
+			expectedRules: `externalRules:
+  - metricsQuery: <<.Series>>
+    name:
+      as: ""
+      matches: ""
+    resources:
+      overrides:
+        namespace:
+          resource: namespace
+    seriesQuery: rabbitmq_queue_messages
+  - metricsQuery: <<.Series>>
+    name:
+      as: ""
+      matches: ""
+    resources:
+      overrides:
+        namespace:
+          resource: namespace
+    seriesQuery: rps
+`,
+		},
+		{
+			name: "named metric with legacy metric",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/rps.targetAverageValue": "100",
+				"prometheus.hpa.caoyingjunz.io/targetAverageValue":     "10k",
+				PrometheusCustomMetric:                                 "http_requests_total",
+			},
+			expected: []autoscalingv2.MetricSpec{
+				{
+					Type: autoscalingv2.ExternalMetricSourceType,
+					External: &autoscalingv2.ExternalMetricSource{
+						Metric: autoscalingv2.MetricIdentifier{Name: "rps"},
+						Target: autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &hundred},
+					},
+				},
+				{
+					Type: autoscalingv2.ExternalMetricSourceType,
+					External: &autoscalingv2.ExternalMetricSource{
+						Metric: autoscalingv2.MetricIdentifier{Name: "http_requests_total"},
+						Target: autoscalingv2.MetricTarget{Type: autoscalingv2.AverageValueMetricType, AverageValue: &tenK},
+					},
+				},
+			},
+			expectedRules: `externalRules:
+  - metricsQuery: <<.Series>>
+    name:
+      as: ""
+      matches: ""
+    resources:
+      overrides:
+        namespace:
+          resource: namespace
+    seriesQuery: http_requests_total
+  - metricsQuery: <<.Series>>
+    name:
+      as: ""
+      matches: ""
+    resources:
+      overrides:
+        namespace:
+          resource: namespace
+    seriesQuery: rps
+`,
+		},
+		{
+			name: "named metric utilization",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/rps.targetAverageUtilization": "60",
+			},
+			expectErr: true,
+		},
+		{
+			name: "named metric invalid value",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/rps.targetValue": "ten",
+			},
+			expectErr: true,
+		},
+		{
+			name: "only attributes of named metric",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/rps.metric": "http_requests_per_second",
+			},
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metricSpecs, err := parseMetricSpecs(test.annotations)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(metricSpecs, test.expected) {
+				t.Fatalf("expected metrics %+v, got %+v", test.expected, metricSpecs)
+			}
+
+			externalRules, err := parseExternalRules(test.annotations)
+			if err != nil {
+				t.Fatal(err)
+			}
+			adapterConfig, err := ParseAdapterConfig("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = adapterConfig.SetRules(ExternalRulesKey, nil, externalRules); err != nil {
+				t.Fatal(err)
+			}
+			data, err := adapterConfig.String()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data != test.expectedRules {
+				t.Errorf("expected adapter config:\n%s\ngot:\n%s", test.expectedRules, data)
+			}
+		})
+	}
+}
+
+func TestExternalRuleFor(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    ExternalRule
+		// expectedSelector 为 HPA 中外部指标的标签选择器
+		expectedSelector *metav1.LabelSelector
+		expectErr        bool
+		// expectRuleErr 为生成 prometheus-adapter 规则时是否校验失败
+		expectRuleErr bool
+	}{
+		{
+			name: "default rule",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/rps.targetAverageValue": "100",
+			},
+			expected: NewExternalRule("rps"),
+		},
+		{
+			name: "full query",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/rps.targetAverageValue": "100",
+				"prometheus.hpa.caoyingjunz.io/rps.seriesQuery":        `http_requests_total{namespace!=""}`,
+				"prometheus.hpa.caoyingjunz.io/rps.metricsQuery":       "sum(rate(<<.Series>>{<<.LabelMatchers>>}[2m])) by (<<.GroupBy>>)",
+				"prometheus.hpa.caoyingjunz.io/rps.nameMatches":        "^(.*)_total$",
+				"prometheus.hpa.caoyingjunz.io/rps.nameAs":             "${1}_per_second",
+				"prometheus.hpa.caoyingjunz.io/rps.metric":             "http_requests_per_second",
+				"prometheus.hpa.caoyingjunz.io/rps.selector":           "service=api",
+			},
+			expected: ExternalRule{
+				MetricsQuery: "sum(rate(<<.Series>>{<<.LabelMatchers>>}[2m])) by (<<.GroupBy>>)",
+				Name:         RuleName{As: "${1}_per_second", Matches: "^(.*)_total$"},
+				Resources:    ResourceMap{Overrides: map[string]ResourceOverride{"namespace": {Resource: "namespace"}}},
+				SeriesQuery:  `http_requests_total{namespace!=""}`,
+			},
+			expectedSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"service": "api"}},
+		},
+		{
+			name: "series query from nameAs",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/rps.targetValue": "100",
+				"prometheus.hpa.caoyingjunz.io/rps.nameAs":      "http_requests_per_second",
+				"prometheus.hpa.caoyingjunz.io/rps.selector":    "service in (api,web)",
+			},
+			expected: ExternalRule{
+				MetricsQuery: DefaultMetricsQuery,
+				Name:         RuleName{As: "http_requests_per_second"},
+				Resources:    ResourceMap{Overrides: map[string]ResourceOverride{"namespace": {Resource: "namespace"}}},
+				SeriesQuery:  "http_requests_per_second",
+			},
+			expectedSelector: &metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "service", Operator: metav1.LabelSelectorOpIn, Values: []string{"api", "web"}}},
+			},
+		},
+		{
+			name: "invalid selector",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/rps.targetAverageValue": "100",
+				"prometheus.hpa.caoyingjunz.io/rps.selector":           "service in api",
+			},
+			expectErr: true,
+		},
+		{
+			name: "invalid template",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/rps.targetAverageValue": "100",
+				"prometheus.hpa.caoyingjunz.io/rps.metricsQuery":       "sum(<<.Series)",
+			},
+			// 模板仅在生成 prometheus-adapter 规则时校验
+			expected: ExternalRule{
+				MetricsQuery: "sum(<<.Series)",
+				Resources:    ResourceMap{Overrides: map[string]ResourceOverride{"namespace": {Resource: "namespace"}}},
+				SeriesQuery:  "rps",
+			},
+			expectRuleErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metricSpecs, err := parseMetricSpecs(test.annotations)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if selector := metricSpecs[0].External.Metric.Selector; !apiequality.Semantic.DeepEqual(selector, test.expectedSelector) {
+				t.Errorf("expected selector %+v, got %+v", test.expectedSelector, selector)
+			}
+			if rule := externalRuleFor("rps", test.annotations); !reflect.DeepEqual(rule, test.expected) {
+				t.Errorf("expected rule %+v, got %+v", test.expected, rule)
+			}
+			if _, err = parseExternalRules(test.annotations); (err != nil) != test.expectRuleErr {
+				t.Errorf("expected rule error %v, got %v", test.expectRuleErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateExternalRule(t *testing.T) {
+	newRule := func(mutate func(rule *ExternalRule)) ExternalRule {
+		rule := NewExternalRule(`http_requests_total{namespace!=""}`)
+		mutate(&rule)
+		return rule
+	}
+
+	tests := []struct {
+		name      string
+		rule      ExternalRule
+		expectErr bool
+	}{
+		{
+			name: "default rule",
+			rule: newRule(func(rule *ExternalRule) {}),
+		},
+		{
+			name: "full query",
+			rule: newRule(func(rule *ExternalRule) {
+				rule.MetricsQuery = `sum(rate(<<.Series>>{<<.LabelMatchers>>}[2m])) by (<<.GroupBy>>)`
+				rule.Name = RuleName{Matches: "^(.*)_total$", As: "${1}_per_second"}
+			}),
+		},
+		{
+			name: "range of group by slice",
+			rule: newRule(func(rule *ExternalRule) {
+				rule.MetricsQuery = `sum(<<.Series>>{<<.LabelMatchers>>}) by (<<range .GroupBySlice>><<.>><<end>>)`
+			}),
+		},
+		{
+			name: "empty series query",
+			rule: newRule(func(rule *ExternalRule) {
+				rule.SeriesQuery = " "
+			}),
+			expectErr: true,
+		},
+		{
+			name: "invalid name matches",
+			rule: newRule(func(rule *ExternalRule) {
+				rule.Name.Matches = "^(.*_total$"
+			}),
+			expectErr: true,
+		},
+		{
+			name: "capture group without name matches",
+			rule: newRule(func(rule *ExternalRule) {
+				rule.Name.As = "${1}_per_second"
+			}),
+			expectErr: true,
+		},
+		{
+			name: "unclosed template",
+			rule: newRule(func(rule *ExternalRule) {
+				rule.MetricsQuery = "sum(<<.Series)"
+			}),
+			expectErr: true,
+		},
+		{
+			name: "unknown template field",
+			rule: newRule(func(rule *ExternalRule) {
+				rule.MetricsQuery = "<<.Metric>>"
+			}),
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ValidateExternalRule(test.rule); (err != nil) != test.expectErr {
+				t.Errorf("expected error %v, got %v", test.expectErr, err)
+			}
+		})
+	}
+}
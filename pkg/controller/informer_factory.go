@@ -18,6 +18,7 @@ package controller
 
 import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/metadata/metadatainformer"
 )
@@ -54,3 +55,17 @@ func NewInformerFactory(typedInformerFactory informers.SharedInformerFactory, me
 		metadataInformerFactory: metadataInformerFactory,
 	}
 }
+
+type dynamicInformerFactory struct {
+	dynamicinformer.DynamicSharedInformerFactory
+}
+
+func (i *dynamicInformerFactory) ForResource(resource schema.GroupVersionResource) (informers.GenericInformer, error) {
+	return i.DynamicSharedInformerFactory.ForResource(resource), nil
+}
+
+// NewDynamicInformerFactory creates a new InformerFactory which works with the
+// dynamic resources
+func NewDynamicInformerFactory(factory dynamicinformer.DynamicSharedInformerFactory) InformerFactory {
+	return &dynamicInformerFactory{DynamicSharedInformerFactory: factory}
+}
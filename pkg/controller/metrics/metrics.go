@@ -0,0 +1,129 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"sync"
+
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	// 注册 workqueue 的指标
+	_ "k8s.io/component-base/metrics/prometheus/workqueue"
+)
+
+const (
+	// PixiuSubsystem is the subsystem of all the pixiu autoscaler metrics.
+	PixiuSubsystem = "pixiu_autoscaler"
+
+	// The results of the syncs and operations.
+	Success = "success"
+	Error   = "error"
+)
+
+var (
+	// DryRunActions counts the create, update, delete and patch actions which
+	// are intended but not persisted in dry-run mode.
+	DryRunActions = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      PixiuSubsystem,
+			Name:           "dry_run_actions_total",
+			Help:           "Number of actions intended in dry-run mode, by resource and action.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"resource", "action"},
+	)
+
+	// SyncDuration observes the duration of syncAutoscalers and syncConfigMaps.
+	SyncDuration = metrics.NewHistogramVec(
+		&metrics.HistogramOpts{
+			Subsystem:      PixiuSubsystem,
+			Name:           "sync_duration_seconds",
+			Help:           "Duration in seconds of syncing a workqueue item, by handler and result.",
+			Buckets:        metrics.ExponentialBuckets(0.001, 2, 15),
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"handler", "result"},
+	)
+
+	// HPAOperations counts the create, update and delete requests of HPAs.
+	HPAOperations = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      PixiuSubsystem,
+			Name:           "hpa_operations_total",
+			Help:           "Number of HPA create, update and delete requests, by operation and result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"operation", "result"},
+	)
+
+	// AdapterNotifies counts the notifications sent to the prometheus adapter
+	// after its config changed.
+	AdapterNotifies = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      PixiuSubsystem,
+			Name:           "adapter_notifies_total",
+			Help:           "Number of notifications to the prometheus adapter after its config changed, by result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"result"},
+	)
+
+	// ParseFailures counts the failures of parsing the hpa annotations.
+	ParseFailures = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      PixiuSubsystem,
+			Name:           "annotation_parse_failures_total",
+			Help:           "Number of hpa annotation parse failures, by reason.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"reason"},
+	)
+
+	// ManagedWorkloads is the number of workloads autoscaled by the HPAs
+	// applied by controller.
+	ManagedWorkloads = metrics.NewGaugeVec(
+		&metrics.GaugeOpts{
+			Subsystem:      PixiuSubsystem,
+			Name:           "managed_workloads",
+			Help:           "Number of workloads autoscaled by the HPAs of controller, by kind.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"kind"},
+	)
+)
+
+// Result returns the result label of the error.
+func Result(err error) string {
+	if err != nil {
+		return Error
+	}
+	return Success
+}
+
+var registerMetrics sync.Once
+
+// Register registers the pixiu autoscaler metrics to the legacy registry.
+func Register() {
+	registerMetrics.Do(func() {
+		legacyregistry.MustRegister(DryRunActions)
+		legacyregistry.MustRegister(SyncDuration)
+		legacyregistry.MustRegister(HPAOperations)
+		legacyregistry.MustRegister(AdapterNotifies)
+		legacyregistry.MustRegister(ParseFailures)
+		legacyregistry.MustRegister(ManagedWorkloads)
+	})
+}
@@ -0,0 +1,427 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	autoscalinginformers "k8s.io/client-go/informers/autoscaling/v2"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	autoscalinglisters "k8s.io/client-go/listers/autoscaling/v2"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var errReadOnlyIndexer = fmt.Errorf("the indexer of multiple namespaces is read only")
+
+type multiNamespaceInformerFactory struct {
+	factories map[string]InformerFactory
+
+	lock      sync.Mutex
+	informers map[schema.GroupVersionResource]informers.GenericInformer
+}
+
+// NewMultiNamespaceInformerFactory creates an InformerFactory which combines the
+// informer factories limited to each namespace, so that the objects out of the
+// namespaces are never cached. The only factory is returned as it is.
+func NewMultiNamespaceInformerFactory(factories map[string]InformerFactory) InformerFactory {
+	if len(factories) == 1 {
+		for _, factory := range factories {
+			return factory
+		}
+	}
+	return &multiNamespaceInformerFactory{
+		factories: factories,
+		informers: make(map[schema.GroupVersionResource]informers.GenericInformer),
+	}
+}
+
+func (f *multiNamespaceInformerFactory) ForResource(resource schema.GroupVersionResource) (informers.GenericInformer, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if informer, ok := f.informers[resource]; ok {
+		return informer, nil
+	}
+	namespaced := make(map[string]cache.SharedIndexInformer, len(f.factories))
+	for namespace, factory := range f.factories {
+		informer, err := factory.ForResource(resource)
+		if err != nil {
+			return nil, err
+		}
+		namespaced[namespace] = informer.Informer()
+	}
+	informer := &genericInformer{
+		informer: newMultiNamespaceInformer(namespaced),
+		resource: resource.GroupResource(),
+	}
+	f.informers[resource] = informer
+	return informer, nil
+}
+
+func (f *multiNamespaceInformerFactory) Start(stopCh <-chan struct{}) {
+	for _, factory := range f.factories {
+		factory.Start(stopCh)
+	}
+}
+
+type genericInformer struct {
+	informer cache.SharedIndexInformer
+	resource schema.GroupResource
+}
+
+func (i *genericInformer) Informer() cache.SharedIndexInformer {
+	return i.informer
+}
+
+func (i *genericInformer) Lister() cache.GenericLister {
+	return cache.NewGenericLister(i.informer.GetIndexer(), i.resource)
+}
+
+// multiNamespaceInformer combines the informers of each namespace, the event
+// handlers are added to all of them.
+type multiNamespaceInformer struct {
+	informers map[string]cache.SharedIndexInformer
+	indexer   *multiNamespaceIndexer
+}
+
+func newMultiNamespaceInformer(informers map[string]cache.SharedIndexInformer) *multiNamespaceInformer {
+	indexers := make(map[string]cache.Indexer, len(informers))
+	for namespace, informer := range informers {
+		indexers[namespace] = informer.GetIndexer()
+	}
+	return &multiNamespaceInformer{
+		informers: informers,
+		indexer:   &multiNamespaceIndexer{indexers: indexers, namespaces: sets.StringKeySet(indexers).List()},
+	}
+}
+
+func (i *multiNamespaceInformer) AddEventHandler(handler cache.ResourceEventHandler) {
+	for _, informer := range i.informers {
+		informer.AddEventHandler(handler)
+	}
+}
+
+func (i *multiNamespaceInformer) AddEventHandlerWithResyncPeriod(handler cache.ResourceEventHandler, resyncPeriod time.Duration) {
+	for _, informer := range i.informers {
+		informer.AddEventHandlerWithResyncPeriod(handler, resyncPeriod)
+	}
+}
+
+func (i *multiNamespaceInformer) GetStore() cache.Store {
+	return i.indexer
+}
+
+func (i *multiNamespaceInformer) GetController() cache.Controller {
+	return i
+}
+
+func (i *multiNamespaceInformer) Run(stopCh <-chan struct{}) {
+	var wg wait.Group
+	for _, informer := range i.informers {
+		wg.StartWithChannel(stopCh, informer.Run)
+	}
+	wg.Wait()
+}
+
+func (i *multiNamespaceInformer) HasSynced() bool {
+	for _, informer := range i.informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// LastSyncResourceVersion returns empty, the resource versions of the
+// informers are not comparable.
+func (i *multiNamespaceInformer) LastSyncResourceVersion() string {
+	return ""
+}
+
+func (i *multiNamespaceInformer) SetWatchErrorHandler(handler cache.WatchErrorHandler) error {
+	for _, informer := range i.informers {
+		if err := informer.SetWatchErrorHandler(handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (i *multiNamespaceInformer) AddIndexers(indexers cache.Indexers) error {
+	return i.indexer.AddIndexers(indexers)
+}
+
+func (i *multiNamespaceInformer) GetIndexer() cache.Indexer {
+	return i.indexer
+}
+
+// multiNamespaceIndexer reads the objects from the indexers of each namespace,
+// it is written by the informers only.
+type multiNamespaceIndexer struct {
+	indexers map[string]cache.Indexer
+	// namespaces 为排序后的命名空间，保证 List 的顺序稳定
+	namespaces []string
+}
+
+// indexersFor returns the indexers which may contain the objects in the namespace,
+// the cluster scoped objects are looked up in all of them.
+func (i *multiNamespaceIndexer) indexersFor(namespace string) []cache.Indexer {
+	if indexer, ok := i.indexers[namespace]; ok {
+		return []cache.Indexer{indexer}
+	}
+	if namespace != metav1.NamespaceAll {
+		return nil
+	}
+	indexers := make([]cache.Indexer, 0, len(i.namespaces))
+	for _, ns := range i.namespaces {
+		indexers = append(indexers, i.indexers[ns])
+	}
+	return indexers
+}
+
+func (i *multiNamespaceIndexer) Add(obj interface{}) error {
+	return errReadOnlyIndexer
+}
+
+func (i *multiNamespaceIndexer) Update(obj interface{}) error {
+	return errReadOnlyIndexer
+}
+
+func (i *multiNamespaceIndexer) Delete(obj interface{}) error {
+	return errReadOnlyIndexer
+}
+
+func (i *multiNamespaceIndexer) Replace(list []interface{}, resourceVersion string) error {
+	return errReadOnlyIndexer
+}
+
+func (i *multiNamespaceIndexer) Resync() error {
+	return errReadOnlyIndexer
+}
+
+func (i *multiNamespaceIndexer) List() []interface{} {
+	var items []interface{}
+	for _, indexer := range i.indexersFor(metav1.NamespaceAll) {
+		items = append(items, indexer.List()...)
+	}
+	return items
+}
+
+func (i *multiNamespaceIndexer) ListKeys() []string {
+	var keys []string
+	for _, indexer := range i.indexersFor(metav1.NamespaceAll) {
+		keys = append(keys, indexer.ListKeys()...)
+	}
+	return keys
+}
+
+func (i *multiNamespaceIndexer) Get(obj interface{}) (interface{}, bool, error) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return nil, false, err
+	}
+	return i.GetByKey(key)
+}
+
+func (i *multiNamespaceIndexer) GetByKey(key string) (interface{}, bool, error) {
+	namespace, _, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	for _, indexer := range i.indexersFor(namespace) {
+		item, exists, err := indexer.GetByKey(key)
+		if err != nil || exists {
+			return item, exists, err
+		}
+	}
+	return nil, false, nil
+}
+
+func (i *multiNamespaceIndexer) Index(indexName string, obj interface{}) ([]interface{}, error) {
+	namespace := metav1.NamespaceAll
+	if indexName == cache.NamespaceIndex {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		namespace = accessor.GetNamespace()
+	}
+
+	var items []interface{}
+	for _, indexer := range i.indexersFor(namespace) {
+		objs, err := indexer.Index(indexName, obj)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, objs...)
+	}
+	return items, nil
+}
+
+func (i *multiNamespaceIndexer) IndexKeys(indexName, indexedValue string) ([]string, error) {
+	var keys []string
+	for _, indexer := range i.indexersForIndex(indexName, indexedValue) {
+		k, err := indexer.IndexKeys(indexName, indexedValue)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+	}
+	return keys, nil
+}
+
+func (i *multiNamespaceIndexer) ListIndexFuncValues(indexName string) []string {
+	values := sets.NewString()
+	for _, indexer := range i.indexersFor(metav1.NamespaceAll) {
+		values.Insert(indexer.ListIndexFuncValues(indexName)...)
+	}
+	return values.List()
+}
+
+func (i *multiNamespaceIndexer) ByIndex(indexName, indexedValue string) ([]interface{}, error) {
+	var items []interface{}
+	for _, indexer := range i.indexersForIndex(indexName, indexedValue) {
+		objs, err := indexer.ByIndex(indexName, indexedValue)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, objs...)
+	}
+	return items, nil
+}
+
+// indexersForIndex returns the indexers which may contain the objects of the indexed value.
+func (i *multiNamespaceIndexer) indexersForIndex(indexName, indexedValue string) []cache.Indexer {
+	if indexName == cache.NamespaceIndex {
+		return i.indexersFor(indexedValue)
+	}
+	return i.indexersFor(metav1.NamespaceAll)
+}
+
+func (i *multiNamespaceIndexer) GetIndexers() cache.Indexers {
+	for _, indexer := range i.indexersFor(metav1.NamespaceAll) {
+		return indexer.GetIndexers()
+	}
+	return cache.Indexers{}
+}
+
+func (i *multiNamespaceIndexer) AddIndexers(indexers cache.Indexers) error {
+	for _, indexer := range i.indexersFor(metav1.NamespaceAll) {
+		if err := indexer.AddIndexers(indexers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type deploymentInformer struct {
+	informer cache.SharedIndexInformer
+}
+
+func (i *deploymentInformer) Informer() cache.SharedIndexInformer {
+	return i.informer
+}
+
+func (i *deploymentInformer) Lister() appslisters.DeploymentLister {
+	return appslisters.NewDeploymentLister(i.informer.GetIndexer())
+}
+
+// DeploymentInformerFor returns the deployment informer of the factory.
+func DeploymentInformerFor(f InformerFactory) (appsinformers.DeploymentInformer, error) {
+	informer, err := f.ForResource(appsv1.SchemeGroupVersion.WithResource("deployments"))
+	if err != nil {
+		return nil, err
+	}
+	return &deploymentInformer{informer: informer.Informer()}, nil
+}
+
+type statefulSetInformer struct {
+	informer cache.SharedIndexInformer
+}
+
+func (i *statefulSetInformer) Informer() cache.SharedIndexInformer {
+	return i.informer
+}
+
+func (i *statefulSetInformer) Lister() appslisters.StatefulSetLister {
+	return appslisters.NewStatefulSetLister(i.informer.GetIndexer())
+}
+
+// StatefulSetInformerFor returns the statefulset informer of the factory.
+func StatefulSetInformerFor(f InformerFactory) (appsinformers.StatefulSetInformer, error) {
+	informer, err := f.ForResource(appsv1.SchemeGroupVersion.WithResource("statefulsets"))
+	if err != nil {
+		return nil, err
+	}
+	return &statefulSetInformer{informer: informer.Informer()}, nil
+}
+
+type hpaInformer struct {
+	informer cache.SharedIndexInformer
+}
+
+func (i *hpaInformer) Informer() cache.SharedIndexInformer {
+	return i.informer
+}
+
+func (i *hpaInformer) Lister() autoscalinglisters.HorizontalPodAutoscalerLister {
+	return autoscalinglisters.NewHorizontalPodAutoscalerLister(i.informer.GetIndexer())
+}
+
+// HPAInformerFor returns the horizontal pod autoscaler informer of the factory.
+func HPAInformerFor(f InformerFactory) (autoscalinginformers.HorizontalPodAutoscalerInformer, error) {
+	informer, err := f.ForResource(autoscalingv2.SchemeGroupVersion.WithResource("horizontalpodautoscalers"))
+	if err != nil {
+		return nil, err
+	}
+	return &hpaInformer{informer: informer.Informer()}, nil
+}
+
+type namespaceInformer struct {
+	informer cache.SharedIndexInformer
+}
+
+func (i *namespaceInformer) Informer() cache.SharedIndexInformer {
+	return i.informer
+}
+
+func (i *namespaceInformer) Lister() corelisters.NamespaceLister {
+	return corelisters.NewNamespaceLister(i.informer.GetIndexer())
+}
+
+// NamespaceInformerFor returns the namespace informer of the factory.
+func NamespaceInformerFor(f InformerFactory) (coreinformers.NamespaceInformer, error) {
+	informer, err := f.ForResource(v1.SchemeGroupVersion.WithResource("namespaces"))
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceInformer{informer: informer.Informer()}, nil
+}
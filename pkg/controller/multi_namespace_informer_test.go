@@ -0,0 +1,157 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMultiNamespaceInformerFactory(t *testing.T) {
+	// fake 客户端不支持 field selector，因此仅创建包含的命名空间
+	objects := []runtime.Object{
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+	}
+	for _, namespace := range []string{"team-a", "team-b", "team-c"} {
+		objects = append(objects, &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: namespace}})
+	}
+	client := fake.NewSimpleClientset(objects...)
+
+	scope, err := NewScope([]string{"team-a", "team-b"}, nil, "", "")
+	if err != nil {
+		t.Fatalf("failed to create scope: %v", err)
+	}
+	workloadInformers := make(map[string]InformerFactory)
+	namespaceInformers := make(map[string]InformerFactory)
+	for _, namespace := range scope.Namespaces() {
+		s := scope.ForNamespace(namespace)
+		workloadInformers[namespace] = informers.NewSharedInformerFactoryWithOptions(client, 0,
+			informers.WithNamespace(s.Namespace()),
+			informers.WithTweakListOptions(s.TweakWorkloadListOptions),
+		)
+		namespaceInformers[namespace] = informers.NewSharedInformerFactoryWithOptions(client, 0,
+			informers.WithTweakListOptions(s.TweakNamespaceListOptions),
+		)
+	}
+	workloadFactory := NewMultiNamespaceInformerFactory(workloadInformers)
+	namespaceFactory := NewMultiNamespaceInformerFactory(namespaceInformers)
+
+	dInformer, err := DeploymentInformerFor(workloadFactory)
+	if err != nil {
+		t.Fatalf("failed to get deployment informer: %v", err)
+	}
+	nsInformer, err := NamespaceInformerFor(namespaceFactory)
+	if err != nil {
+		t.Fatalf("failed to get namespace informer: %v", err)
+	}
+
+	var lock sync.Mutex
+	var added []string
+	dInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			lock.Lock()
+			defer lock.Unlock()
+			key, _ := cache.MetaNamespaceKeyFunc(obj)
+			added = append(added, key)
+		},
+	})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	workloadFactory.Start(stopCh)
+	namespaceFactory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, dInformer.Informer().HasSynced, nsInformer.Informer().HasSynced) {
+		t.Fatalf("failed to sync informers")
+	}
+
+	// 仅缓存包含的命名空间中的对象
+	deployments, err := dInformer.Lister().List(labels.Everything())
+	if err != nil {
+		t.Fatalf("failed to list deployments: %v", err)
+	}
+	var keys []string
+	for _, d := range deployments {
+		keys = append(keys, d.Namespace+"/"+d.Name)
+	}
+	sort.Strings(keys)
+	if expected := []string{"team-a/nginx", "team-b/nginx"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected deployments %v, got %v", expected, keys)
+	}
+	lock.Lock()
+	sort.Strings(added)
+	if expected := []string{"team-a/nginx", "team-b/nginx"}; !reflect.DeepEqual(added, expected) {
+		t.Errorf("expected added deployments %v, got %v", expected, added)
+	}
+	lock.Unlock()
+
+	tests := []struct {
+		namespace    string
+		expectExists bool
+	}{
+		{namespace: "team-a", expectExists: true},
+		{namespace: "team-b", expectExists: true},
+		{namespace: "team-c"},
+	}
+	for _, test := range tests {
+		t.Run(test.namespace, func(t *testing.T) {
+			_, err := dInformer.Lister().Deployments(test.namespace).Get("nginx")
+			if exists := err == nil; exists != test.expectExists {
+				t.Errorf("expected deployment exists %v, got error %v", test.expectExists, err)
+			}
+			if err != nil && !errors.IsNotFound(err) {
+				t.Errorf("expected not found error, got %v", err)
+			}
+			list, err := dInformer.Lister().Deployments(test.namespace).List(labels.Everything())
+			if err != nil {
+				t.Fatalf("failed to list deployments: %v", err)
+			}
+			if exists := len(list) == 1; exists != test.expectExists {
+				t.Errorf("expected deployment listed %v, got %d deployments", test.expectExists, len(list))
+			}
+
+			// 集群级别的对象在所有命名空间的缓存中查找
+			_, err = nsInformer.Lister().Get(test.namespace)
+			if exists := err == nil; exists != test.expectExists {
+				t.Errorf("expected namespace exists %v, got error %v", test.expectExists, err)
+			}
+		})
+	}
+
+	if err := dInformer.Informer().GetIndexer().Add(&appsv1.Deployment{}); err == nil {
+		t.Errorf("expected the indexer to be read only")
+	}
+}
+
+func TestNewMultiNamespaceInformerFactory(t *testing.T) {
+	factory := informers.NewSharedInformerFactory(fake.NewSimpleClientset(), 0)
+	if f := NewMultiNamespaceInformerFactory(map[string]InformerFactory{metav1.NamespaceAll: factory}); f != factory {
+		t.Errorf("expected the only factory to be returned, got %T", f)
+	}
+}
@@ -0,0 +1,274 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller/metrics"
+)
+
+const (
+	// Restart restarts the prometheus adapter once the config is changed.
+	Restart string = "restart"
+	// Debounce merges the config changes within a window into one restart.
+	Debounce string = "debounce"
+	// None does nothing, the prometheus adapter is expected to reload its config by itself.
+	None string = "none"
+
+	// DefaultDebounceWindow is the default window of the debounce strategy.
+	DefaultDebounceWindow = 30 * time.Second
+
+	// RestartAtAnnotation 为重启 prometheus-adapter 时写入 pod 模板的注释
+	RestartAtAnnotation = "deployment.pixiu.io/restartAt"
+)
+
+// Notifier notifies the prometheus adapter that its config has been changed.
+type Notifier interface {
+	// Notify is called after the adapter config has been updated.
+	Notify(ctx context.Context) error
+	// Run starts the background work of the notifier until ctx is cancelled.
+	Run(ctx context.Context)
+}
+
+// New creates a notifier of the given strategy, the adapter is not restarted
+// actually in dry-run mode.
+func New(strategy string, client clientset.Interface, adapter controller.PrometheusAdapter, window time.Duration, dryRun bool) (Notifier, error) {
+	metrics.Register()
+
+	switch strategy {
+	case Restart, "":
+		return NewRestartNotifier(client, adapter, dryRun), nil
+	case Debounce:
+		if window <= 0 {
+			return nil, fmt.Errorf("the debounce window should be positive, got %v", window)
+		}
+		return NewDebounceNotifier(NewRestartNotifier(client, adapter, dryRun), window), nil
+	case None:
+		return &noopNotifier{notifier: NewRestartNotifier(client, adapter, dryRun)}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported adapter notify strategy %q, must be one of %s, %s and %s", strategy, Restart, Debounce, None)
+}
+
+// RestartNotifier restarts the prometheus adapter deployment by patching an
+// annotation onto its pod template.
+type RestartNotifier struct {
+	client  clientset.Interface
+	adapter controller.PrometheusAdapter
+
+	// dryRun 为 true 时 patch 请求仅通过 apiserver 的 dry-run 校验
+	dryRun bool
+}
+
+// NewRestartNotifier creates a notifier which restarts the adapter immediately.
+func NewRestartNotifier(client clientset.Interface, adapter controller.PrometheusAdapter, dryRun bool) *RestartNotifier {
+	return &RestartNotifier{
+		client:  client,
+		adapter: adapter,
+		dryRun:  dryRun,
+	}
+}
+
+func (r *RestartNotifier) Notify(ctx context.Context) error {
+	err := r.restart(ctx)
+	metrics.AdapterNotifies.WithLabelValues(metrics.Result(err)).Inc()
+	return err
+}
+
+// restart restarts the prometheus adapter, and clears the pending notification
+// recorded on the adapter configmap once the restart succeeds.
+func (r *RestartNotifier) restart(ctx context.Context) error {
+	// 重启之前读取待通知的记录，重启期间新增的记录不会被清除
+	pending, found, err := r.pending(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 仅修改 restartAt 注释，保留 pod 模板上的其他注释
+	patchPayload, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{
+						RestartAtAnnotation: metav1.Now().Format("2006-01-02T15:04:05Z"),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = r.client.AppsV1().Deployments(r.adapter.Namespace).Patch(ctx, r.adapter.DeploymentName, types.StrategicMergePatchType, patchPayload, metav1.PatchOptions{DryRun: r.dryRunOption()}); err != nil {
+		return fmt.Errorf("failed to restart prometheus-adapter: %v", err)
+	}
+	if r.dryRun {
+		klog.InfoS("Dry run", "action", "patch", "resource", "deployments", "object", klog.KRef(r.adapter.Namespace, r.adapter.DeploymentName), "patch", string(patchPayload))
+		metrics.DryRunActions.WithLabelValues("deployments", "patch").Inc()
+	} else {
+		klog.V(2).Infof("prometheus-adapter %s/%s restarted", r.adapter.Namespace, r.adapter.DeploymentName)
+	}
+
+	if found {
+		r.clearPending(ctx, pending)
+	}
+	return nil
+}
+
+// pending returns the pending notification recorded on the adapter configmap.
+func (r *RestartNotifier) pending(ctx context.Context) (string, bool, error) {
+	cm, err := r.client.CoreV1().ConfigMaps(r.adapter.Namespace).Get(ctx, r.adapter.ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to get configmap %s/%s: %v", r.adapter.Namespace, r.adapter.ConfigMapName, err)
+	}
+	pending, found := cm.Annotations[controller.NotifyPending]
+	return pending, found, nil
+}
+
+// clearPending clears the pending notification on the adapter configmap if it
+// is not changed since read, a newer one is kept for the next notification.
+func (r *RestartNotifier) clearPending(ctx context.Context, pending string) {
+	path := "/metadata/annotations/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(controller.NotifyPending)
+	patchPayload, err := json.Marshal([]map[string]interface{}{
+		{"op": "test", "path": path, "value": pending},
+		{"op": "remove", "path": path},
+	})
+	if err != nil {
+		klog.Warningf("Failed to clear the pending notification: %v", err)
+		return
+	}
+	// 清除失败时仅会在下次同步或控制器启动时多通知一次，不影响本次结果
+	if _, err = r.client.CoreV1().ConfigMaps(r.adapter.Namespace).Patch(ctx, r.adapter.ConfigMapName, types.JSONPatchType, patchPayload, metav1.PatchOptions{DryRun: r.dryRunOption()}); err != nil {
+		klog.Warningf("Failed to clear the pending notification of configmap %s/%s: %v", r.adapter.Namespace, r.adapter.ConfigMapName, err)
+	}
+}
+
+func (r *RestartNotifier) dryRunOption() []string {
+	if r.dryRun {
+		return []string{metav1.DryRunAll}
+	}
+	return nil
+}
+
+func (r *RestartNotifier) Run(ctx context.Context) {}
+
+// DebounceNotifier merges the notifications within a window into one. The
+// pending notification recorded on the adapter configmap by the controller is
+// kept until the restart, so that it is not lost if the controller stops within
+// the window.
+type DebounceNotifier struct {
+	notifier *RestartNotifier
+	window   time.Duration
+
+	// queue 仅包含一个元素，延迟加入时已等待的元素会被合并
+	queue workqueue.RateLimitingInterface
+}
+
+// debounceKey is the only item of the debounce queue.
+const debounceKey = "notify"
+
+// NewDebounceNotifier creates a notifier which delays the notification by the
+// window, the notifications happened in the meantime are merged.
+func NewDebounceNotifier(notifier *RestartNotifier, window time.Duration) *DebounceNotifier {
+	return &DebounceNotifier{
+		notifier: notifier,
+		window:   window,
+		queue:    workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "pixiu-notifier"),
+	}
+}
+
+func (d *DebounceNotifier) Notify(ctx context.Context) error {
+	// 窗口从第一次变更开始计算，窗口内的后续变更不会推迟通知
+	d.queue.AddAfter(debounceKey, d.window)
+	return nil
+}
+
+func (d *DebounceNotifier) Run(ctx context.Context) {
+	defer utilruntime.HandleCrash()
+	// 退出时丢弃窗口内的通知，其记录仍保留在 configmap 上
+	defer d.queue.ShutDown()
+
+	go d.notifier.Run(ctx)
+	go wait.UntilWithContext(ctx, d.worker, time.Second)
+
+	<-ctx.Done()
+}
+
+func (d *DebounceNotifier) worker(ctx context.Context) {
+	for d.processNextWorkItem(ctx) {
+	}
+}
+
+func (d *DebounceNotifier) processNextWorkItem(ctx context.Context) bool {
+	key, quit := d.queue.Get()
+	if quit {
+		return false
+	}
+	defer d.queue.Done(key)
+
+	// 仅统计真正执行的通知
+	err := d.notifier.restart(ctx)
+	metrics.AdapterNotifies.WithLabelValues(metrics.Result(err)).Inc()
+	if err != nil {
+		klog.Errorf("failed to notify prometheus-adapter: %v", err)
+		d.queue.AddRateLimited(key)
+		return true
+	}
+
+	d.queue.Forget(key)
+	return true
+}
+
+// noopNotifier is used when the prometheus adapter watches its own config.
+type noopNotifier struct {
+	// notifier 仅用于清除待通知的记录
+	notifier *RestartNotifier
+}
+
+func (n *noopNotifier) Notify(ctx context.Context) error {
+	klog.V(2).Infof("prometheus-adapter config changed, waiting for the adapter to reload it")
+	pending, found, err := n.notifier.pending(ctx)
+	if err != nil {
+		metrics.AdapterNotifies.WithLabelValues(metrics.Result(err)).Inc()
+		return err
+	}
+	if found {
+		n.notifier.clearPending(ctx, pending)
+	}
+	metrics.AdapterNotifies.WithLabelValues(metrics.Success).Inc()
+	return nil
+}
+
+func (n *noopNotifier) Run(ctx context.Context) {}
@@ -0,0 +1,273 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	"k8s.io/component-base/metrics/testutil"
+
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller"
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller/metrics"
+)
+
+var testAdapter = controller.PrometheusAdapter{
+	Namespace:      "pixiu-system",
+	ConfigMapName:  "prometheus-adapter",
+	ConfigMapKey:   "config.yaml",
+	DeploymentName: "prometheus-adapter",
+}
+
+// newTestClient returns a client with the prometheus adapter, the pending
+// notification is recorded on its configmap by the controller if pending is true.
+func newTestClient(pending bool) *fake.Clientset {
+	cm := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: testAdapter.ConfigMapName, Namespace: testAdapter.Namespace}}
+	if pending {
+		cm.Annotations = map[string]string{controller.NotifyPending: "2021-10-18T09:00:00Z"}
+	}
+	return fake.NewSimpleClientset(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: testAdapter.DeploymentName, Namespace: testAdapter.Namespace}},
+		cm,
+	)
+}
+
+// patches returns the number of patches of the resource.
+func patches(client *fake.Clientset, resource string) int {
+	var count int
+	for _, action := range client.Actions() {
+		if action.Matches("patch", resource) {
+			count++
+		}
+	}
+	return count
+}
+
+// pending returns true if the pending notification is recorded on the adapter configmap.
+func pending(t *testing.T, client *fake.Clientset) bool {
+	cm, err := client.CoreV1().ConfigMaps(testAdapter.Namespace).Get(context.TODO(), testAdapter.ConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get configmap: %v", err)
+	}
+	_, ok := cm.Annotations[controller.NotifyPending]
+	return ok
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  string
+		window    time.Duration
+		expected  Notifier
+		expectErr bool
+	}{
+		{
+			name:     "default",
+			expected: &RestartNotifier{},
+		},
+		{
+			name:     "restart",
+			strategy: Restart,
+			expected: &RestartNotifier{},
+		},
+		{
+			name:     "debounce",
+			strategy: Debounce,
+			window:   DefaultDebounceWindow,
+			expected: &DebounceNotifier{},
+		},
+		{
+			name:      "debounce without window",
+			strategy:  Debounce,
+			expectErr: true,
+		},
+		{
+			name:     "none",
+			strategy: None,
+			expected: &noopNotifier{},
+		},
+		{
+			name:      "unknown",
+			strategy:  "reload",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n, err := New(test.strategy, newTestClient(false), testAdapter, test.window, false)
+			if (err != nil) != test.expectErr {
+				t.Fatalf("expected error %v, got %v", test.expectErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if reflect.TypeOf(n) != reflect.TypeOf(test.expected) {
+				t.Errorf("expected notifier %T, got %T", test.expected, n)
+			}
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	const window = 50 * time.Millisecond
+
+	tests := []struct {
+		name     string
+		strategy string
+		// noPending 为 true 时 configmap 上没有待通知的记录
+		noPending bool
+		// failures 为重启 prometheus-adapter 时失败的次数
+		failures int
+		// shutdown 为 true 时在窗口结束前停止 notifier
+		shutdown        bool
+		expectRestarts  int
+		expectClears    int
+		expectPending   bool
+		expectSuccesses float64
+		expectFailures  float64
+	}{
+		{
+			name:            "restart",
+			strategy:        Restart,
+			expectRestarts:  3,
+			expectClears:    1,
+			expectSuccesses: 3,
+		},
+		{
+			name:            "restart without pending",
+			strategy:        Restart,
+			noPending:       true,
+			expectRestarts:  3,
+			expectSuccesses: 3,
+		},
+		{
+			name:           "restart failure keeps pending",
+			strategy:       Restart,
+			failures:       3,
+			expectRestarts: 3,
+			// 待通知的记录保留在 configmap 上，由下次同步重新通知
+			expectPending:  true,
+			expectFailures: 3,
+		},
+		{
+			name:            "debounce",
+			strategy:        Debounce,
+			expectRestarts:  1,
+			expectClears:    1,
+			expectSuccesses: 1,
+		},
+		{
+			name:            "debounce retries the failed restart",
+			strategy:        Debounce,
+			failures:        1,
+			expectRestarts:  2,
+			expectClears:    1,
+			expectSuccesses: 1,
+			expectFailures:  1,
+		},
+		{
+			name:     "debounce shutdown within window",
+			strategy: Debounce,
+			shutdown: true,
+			// 待通知的记录保留在 configmap 上，由新的 leader 继续完成
+			expectPending: true,
+		},
+		{
+			name:            "none",
+			strategy:        None,
+			expectClears:    1,
+			expectSuccesses: 3,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(!test.noPending)
+			failures := test.failures
+			client.PrependReactor("patch", "deployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if failures == 0 {
+					return false, nil, nil
+				}
+				failures--
+				return true, nil, fmt.Errorf("the server is currently unable to handle the request")
+			})
+			n, err := New(test.strategy, client, testAdapter, window, false)
+			if err != nil {
+				t.Fatalf("failed to create notifier: %v", err)
+			}
+
+			succeeded := metrics.AdapterNotifies.WithLabelValues(metrics.Success)
+			failed := metrics.AdapterNotifies.WithLabelValues(metrics.Error)
+			successesBefore, _ := testutil.GetCounterMetricValue(succeeded)
+			failuresBefore, _ := testutil.GetCounterMetricValue(failed)
+
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				n.Run(ctx)
+			}()
+
+			for i := 0; i < 3; i++ {
+				if err = n.Notify(ctx); err != nil && test.failures == 0 {
+					t.Fatalf("failed to notify: %v", err)
+				}
+			}
+			if test.shutdown {
+				cancel()
+				<-stopped
+			}
+
+			// 等待窗口结束，以及失败后的重试
+			err = wait.PollImmediate(10*time.Millisecond, time.Second, func() (bool, error) {
+				successes, _ := testutil.GetCounterMetricValue(succeeded)
+				failures, _ := testutil.GetCounterMetricValue(failed)
+				return successes-successesBefore == test.expectSuccesses && failures-failuresBefore == test.expectFailures, nil
+			})
+			if err != nil {
+				successes, _ := testutil.GetCounterMetricValue(succeeded)
+				failures, _ := testutil.GetCounterMetricValue(failed)
+				t.Fatalf("expected %v successful and %v failed notifications, got %v and %v",
+					test.expectSuccesses, test.expectFailures, successes-successesBefore, failures-failuresBefore)
+			}
+			// 窗口内的多次通知仅重启一次
+			time.Sleep(2 * window)
+			if count := patches(client, "deployments"); count != test.expectRestarts {
+				t.Errorf("expected %d restarts, got %d", test.expectRestarts, count)
+			}
+			// 仅在存在待通知的记录时清除
+			if count := patches(client, "configmaps"); count != test.expectClears {
+				t.Errorf("expected %d clears, got %d", test.expectClears, count)
+			}
+			if p := pending(t, client); p != test.expectPending {
+				t.Errorf("expected pending %v, got %v", test.expectPending, p)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+)
+
+// IsPaused returns true if the autoscaling is paused at now, the returned time
+// is when it resumes automatically, which is zero if no expiry is set.
+func IsPaused(annotations map[string]string, now time.Time) (bool, time.Time, error) {
+	value, ok := annotations[Paused]
+	if !ok {
+		return false, time.Time{}, nil
+	}
+	paused, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid %s: %v", Paused, err)
+	}
+	if !paused {
+		return false, time.Time{}, nil
+	}
+
+	value, ok = annotations[PausedUntil]
+	if !ok {
+		return true, time.Time{}, nil
+	}
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return false, time.Time{}, fmt.Errorf("invalid %s: %v", PausedUntil, err)
+	}
+	// 到期后自动恢复
+	if !now.Before(until) {
+		return false, time.Time{}, nil
+	}
+	return true, until, nil
+}
+
+// IsHPAPaused returns true if the HPA is frozen by FreezeHPA.
+func IsHPAPaused(hpa *autoscalingv2.HorizontalPodAutoscaler) bool {
+	return hpa.Annotations[Paused] == "true"
+}
+
+// FreezeHPA freezes the HPA at the replicas by setting both minReplicas and
+// maxReplicas to it, the HPA is marked paused.
+func FreezeHPA(hpa *autoscalingv2.HorizontalPodAutoscaler, replicas int32) {
+	// minReplicas 不能为 0，工作负载缩容到 0 时 HPA 本身也不再伸缩
+	if replicas < 1 {
+		replicas = 1
+	}
+	hpa.Spec.MinReplicas = &replicas
+	hpa.Spec.MaxReplicas = replicas
+
+	if hpa.Annotations == nil {
+		hpa.Annotations = make(map[string]string)
+	}
+	hpa.Annotations[Paused] = "true"
+}
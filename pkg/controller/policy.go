@@ -0,0 +1,128 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilpointer "k8s.io/utils/pointer"
+
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/apis/pixiu/v1alpha1"
+)
+
+// CreateHPAFromPolicy creates the HPA of the workload from the AutoscalingPolicy,
+// the HPA is the same as the one created from annotations except its spec.
+func CreateHPAFromPolicy(w *Workload, policy *v1alpha1.AutoscalingPolicy) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	spec := policy.Spec.DeepCopy()
+
+	minReplicas := int32(1)
+	if spec.MinReplicas != nil {
+		minReplicas = *spec.MinReplicas
+	}
+	if minReplicas < 1 {
+		return nil, fmt.Errorf("minReplicas should be greater than 0")
+	}
+	if spec.MaxReplicas < minReplicas {
+		return nil, fmt.Errorf("maxReplicas should be greater than or equal to minReplicas")
+	}
+	if len(spec.Metrics) == 0 {
+		return nil, fmt.Errorf("at least one metric is required")
+	}
+	if err := validateContainerMetrics(w, spec.Metrics); err != nil {
+		return nil, err
+	}
+
+	behavior := spec.Behavior
+	if behavior != nil {
+		// 与注释方式一致，按 apiserver 的规则填充默认值
+		behavior = &autoscalingv2.HorizontalPodAutoscalerBehavior{
+			ScaleUp:   generateScaleUpRules(behavior.ScaleUp),
+			ScaleDown: generateScaleDownRules(behavior.ScaleDown),
+		}
+	}
+
+	hpa := newHPAForWorkload(w, autoscalingv2.HorizontalPodAutoscalerSpec{
+		MinReplicas: utilpointer.Int32Ptr(minReplicas),
+		MaxReplicas: spec.MaxReplicas,
+		Metrics:     spec.Metrics,
+		Behavior:    behavior,
+	}, map[string]string{})
+	if IsCustomMetricSpec(hpa.Spec.Metrics) {
+		hpa.Labels[PrometheusCustomMetric] = "true"
+	}
+
+	return hpa, nil
+}
+
+// IsCustomMetricSpec returns true if any of the metrics is served by the prometheus adapter.
+func IsCustomMetricSpec(metrics []autoscalingv2.MetricSpec) bool {
+	for _, metric := range metrics {
+		switch metric.Type {
+		case autoscalingv2.PodsMetricSourceType, autoscalingv2.ObjectMetricSourceType, autoscalingv2.ExternalMetricSourceType:
+			return true
+		}
+	}
+	return false
+}
+
+// PolicyTargetGroupKind returns the group kind of the workload targeted by the policy.
+func PolicyTargetGroupKind(policy *v1alpha1.AutoscalingPolicy) schema.GroupKind {
+	return schema.FromAPIVersionAndKind(policy.Spec.TargetRef.APIVersion, policy.Spec.TargetRef.Kind).GroupKind()
+}
+
+// SortPolicies sorts the policies by creation time, the oldest one takes effect
+// if several policies target the same workload.
+func SortPolicies(policies []*v1alpha1.AutoscalingPolicy) {
+	sort.SliceStable(policies, func(i, j int) bool {
+		if policies[i].CreationTimestamp.Equal(&policies[j].CreationTimestamp) {
+			return policies[i].Name < policies[j].Name
+		}
+		return policies[i].CreationTimestamp.Before(&policies[j].CreationTimestamp)
+	})
+}
+
+// ConvertToPolicy converts the object listed by the dynamic informer to AutoscalingPolicy.
+func ConvertToPolicy(obj interface{}) (*v1alpha1.AutoscalingPolicy, error) {
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("unexpected object type %T, expected *unstructured.Unstructured", obj)
+	}
+
+	policy := &v1alpha1.AutoscalingPolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// ConvertFromPolicy converts the AutoscalingPolicy to unstructured, which could be
+// updated by the dynamic client.
+func ConvertFromPolicy(policy *v1alpha1.AutoscalingPolicy) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(policy)
+	if err != nil {
+		return nil, err
+	}
+
+	u := &unstructured.Unstructured{Object: content}
+	u.SetGroupVersionKind(v1alpha1.SchemeGroupVersion.WithKind("AutoscalingPolicy"))
+	return u, nil
+}
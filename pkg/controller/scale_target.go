@@ -0,0 +1,78 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/informers"
+)
+
+const (
+	scaleSubresource = "scale"
+)
+
+// ScaleTarget describes an extra workload resource, such as a CRD, which is
+// scaled by HPA through its scale subresource.
+type ScaleTarget struct {
+	Resource schema.GroupVersionResource
+	Kind     schema.GroupVersionKind
+
+	// Informer 为该资源的 informer，对于非内置资源，通常为 metadata informer
+	Informer informers.GenericInformer
+}
+
+// ResolveScaleTarget parses the resource arg in the format of resource.version.group
+// (or resource.group), resolves its kind through the RESTMapper and verifies
+// through discovery that the resource exposes the scale subresource.
+func ResolveScaleTarget(discoveryClient discovery.DiscoveryInterface, mapper meta.RESTMapper, arg string) (schema.GroupVersionResource, schema.GroupVersionKind, error) {
+	var gvr schema.GroupVersionResource
+
+	fullySpecified, gr := schema.ParseResourceArg(arg)
+	if fullySpecified != nil {
+		if _, err := mapper.KindFor(*fullySpecified); err == nil {
+			gvr = *fullySpecified
+		}
+	}
+	if gvr.Empty() {
+		resolved, err := mapper.ResourceFor(gr.WithVersion(""))
+		if err != nil {
+			return schema.GroupVersionResource{}, schema.GroupVersionKind{}, fmt.Errorf("failed to resolve resource %q: %v", arg, err)
+		}
+		gvr = resolved
+	}
+
+	gvk, err := mapper.KindFor(gvr)
+	if err != nil {
+		return schema.GroupVersionResource{}, schema.GroupVersionKind{}, fmt.Errorf("failed to resolve kind for %s: %v", gvr.String(), err)
+	}
+
+	resources, err := discoveryClient.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+	if err != nil {
+		return schema.GroupVersionResource{}, schema.GroupVersionKind{}, fmt.Errorf("failed to discover resources for %s: %v", gvr.GroupVersion().String(), err)
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == gvr.Resource+PixiuSeparator+scaleSubresource {
+			return gvr, gvk, nil
+		}
+	}
+
+	return schema.GroupVersionResource{}, schema.GroupVersionKind{}, fmt.Errorf("resource %s does not expose the scale subresource", gvr.String())
+}
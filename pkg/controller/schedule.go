@@ -0,0 +1,228 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// schedulePrefix 为定时副本数注释的前缀
+const schedulePrefix = schedule + PixiuDot + PixiuRootPrefix + PixiuSeparator
+
+// Schedule overrides the replicas bounds of the HPA while it is active, it is
+// active from each start time until the following end time.
+type Schedule struct {
+	Name string
+
+	Start    cron.Schedule
+	End      cron.Schedule
+	Location *time.Location
+
+	// MinReplicas and MaxReplicas override the bounds of the annotations if set.
+	MinReplicas *int32
+	MaxReplicas *int32
+}
+
+// IsActive returns true if the schedule is active at the given time.
+func (s *Schedule) IsActive(now time.Time) bool {
+	t := now.In(s.Location)
+	start, end := s.Start.Next(t), s.End.Next(t)
+	// 下一次结束早于下一次开始，说明当前处于生效期间；Next 返回零值表示不会再触发
+	return !end.IsZero() && (start.IsZero() || end.Before(start))
+}
+
+// NextBoundary returns the next time the schedule starts or ends, it returns
+// false if the schedule never fires again.
+func (s *Schedule) NextBoundary(now time.Time) (time.Time, bool) {
+	t := now.In(s.Location)
+	start, end := s.Start.Next(t), s.End.Next(t)
+	switch {
+	case start.IsZero() && end.IsZero():
+		return time.Time{}, false
+	case start.IsZero():
+		return end, true
+	case end.IsZero() || start.Before(end):
+		return start, true
+	}
+	return end, true
+}
+
+// Replicas returns the bounds overridden by the schedule.
+func (s *Schedule) Replicas(minReplicas, maxReplicas int32) (int32, int32) {
+	if s.MinReplicas != nil {
+		minReplicas = *s.MinReplicas
+	}
+	if s.MaxReplicas != nil {
+		maxReplicas = *s.MaxReplicas
+	}
+	return minReplicas, maxReplicas
+}
+
+// ParseSchedules parses the schedules from annotations, sorted by name.
+func ParseSchedules(annotations map[string]string) ([]*Schedule, error) {
+	schedules, errs := parseSchedules(annotations, field.NewPath("metadata", "annotations"))
+	return schedules, errs.ToAggregate()
+}
+
+// ActiveSchedule returns the schedule which is active at the given time, the
+// first one by name wins if more than one schedule is active.
+func ActiveSchedule(schedules []*Schedule, now time.Time) *Schedule {
+	for _, s := range schedules {
+		if s.IsActive(now) {
+			return s
+		}
+	}
+	return nil
+}
+
+// NextScheduleBoundary returns the earliest time any of the schedules starts
+// or ends, the workload should be resynced at that time.
+func NextScheduleBoundary(schedules []*Schedule, now time.Time) (time.Time, bool) {
+	var next time.Time
+	for _, s := range schedules {
+		boundary, ok := s.NextBoundary(now)
+		if ok && (next.IsZero() || boundary.Before(next)) {
+			next = boundary
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// IsScheduleAnnotation returns true if the annotation belongs to a schedule.
+func IsScheduleAnnotation(annotation string) bool {
+	return strings.HasPrefix(annotation, schedulePrefix)
+}
+
+func scheduleKey(name string, field string) string {
+	return schedulePrefix + name + PixiuDot + field
+}
+
+func parseSchedules(annotations map[string]string, fldPath *field.Path) ([]*Schedule, field.ErrorList) {
+	allErrs := field.ErrorList{}
+
+	// 按名称归类每个定时的属性
+	fields := make(map[string]map[string]string)
+	for _, key := range sortedKeys(annotations) {
+		if !IsScheduleAnnotation(key) {
+			continue
+		}
+		name, attr, ok := splitNamedTarget(strings.TrimPrefix(key, schedulePrefix))
+		if !ok {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(key), annotations[key],
+				fmt.Sprintf("should be in the format of %s<name>.<field>", schedulePrefix)))
+			continue
+		}
+		switch attr {
+		case scheduleStart, scheduleEnd, scheduleTimeZone, scheduleMinReplicas, scheduleMaxReplicas:
+		default:
+			allErrs = append(allErrs, field.NotSupported(fldPath.Key(key), attr,
+				[]string{scheduleStart, scheduleEnd, scheduleTimeZone, scheduleMinReplicas, scheduleMaxReplicas}))
+			continue
+		}
+		if fields[name] == nil {
+			fields[name] = make(map[string]string)
+		}
+		fields[name][attr] = annotations[key]
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var schedules []*Schedule
+	for _, name := range names {
+		s, errs := parseSchedule(name, fields[name], fldPath)
+		if len(errs) != 0 {
+			allErrs = append(allErrs, errs...)
+			continue
+		}
+		schedules = append(schedules, s)
+	}
+
+	return schedules, allErrs
+}
+
+func parseSchedule(name string, fields map[string]string, fldPath *field.Path) (*Schedule, field.ErrorList) {
+	allErrs := field.ErrorList{}
+	s := &Schedule{Name: name, Location: time.UTC}
+
+	var err error
+	for _, attr := range []string{scheduleStart, scheduleEnd} {
+		path := fldPath.Key(scheduleKey(name, attr))
+		value, ok := fields[attr]
+		if !ok {
+			allErrs = append(allErrs, field.Required(path, fmt.Sprintf("schedule %s should have both %s and %s", name, scheduleStart, scheduleEnd)))
+			continue
+		}
+		// 时区统一由 timeZone 指定
+		if strings.HasPrefix(value, "TZ=") || strings.HasPrefix(value, "CRON_TZ=") {
+			allErrs = append(allErrs, field.Invalid(path, value, fmt.Sprintf("use %s to set the time zone", scheduleKey(name, scheduleTimeZone))))
+			continue
+		}
+		cronSchedule, err := cron.ParseStandard(value)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(path, value, err.Error()))
+			continue
+		}
+		if attr == scheduleStart {
+			s.Start = cronSchedule
+		} else {
+			s.End = cronSchedule
+		}
+	}
+
+	if tz, ok := fields[scheduleTimeZone]; ok {
+		if s.Location, err = time.LoadLocation(tz); err != nil || tz == "Local" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(scheduleKey(name, scheduleTimeZone)), tz, "unknown time zone"))
+		}
+	}
+
+	for _, attr := range []string{scheduleMinReplicas, scheduleMaxReplicas} {
+		value, ok := fields[attr]
+		if !ok {
+			continue
+		}
+		replicas, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || replicas < 1 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(scheduleKey(name, attr)), value, "must be an integer greater than 0"))
+			continue
+		}
+		r := int32(replicas)
+		if attr == scheduleMinReplicas {
+			s.MinReplicas = &r
+		} else {
+			s.MaxReplicas = &r
+		}
+	}
+	_, hasMin := fields[scheduleMinReplicas]
+	_, hasMax := fields[scheduleMaxReplicas]
+	if !hasMin && !hasMax {
+		allErrs = append(allErrs, field.Required(fldPath.Key(scheduleKey(name, scheduleMinReplicas)),
+			fmt.Sprintf("schedule %s should override %s or %s", name, scheduleMinReplicas, scheduleMaxReplicas)))
+	}
+
+	return s, allErrs
+}
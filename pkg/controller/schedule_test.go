@@ -0,0 +1,246 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+var shanghai = mustLoadLocation("Asia/Shanghai")
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+func workdayAnnotations() map[string]string {
+	return map[string]string{
+		"hpa.caoyingjunz.io/minReplicas":                  "2",
+		"hpa.caoyingjunz.io/maxReplicas":                  "10",
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+		"schedule.hpa.caoyingjunz.io/workday.start":       "0 9 * * 1-5",
+		"schedule.hpa.caoyingjunz.io/workday.end":         "0 18 * * 1-5",
+		"schedule.hpa.caoyingjunz.io/workday.timeZone":    "Asia/Shanghai",
+		"schedule.hpa.caoyingjunz.io/workday.minReplicas": "5",
+	}
+}
+
+func TestScheduleBoundaries(t *testing.T) {
+	schedules, err := ParseSchedules(workdayAnnotations())
+	if err != nil {
+		t.Fatalf("failed to parse schedules: %v", err)
+	}
+
+	// 2021-10-18 为周一
+	fakeClock := testingclock.NewFakeClock(time.Date(2021, 10, 18, 8, 0, 0, 0, shanghai))
+	steps := []struct {
+		step   time.Duration
+		active string
+		next   time.Time
+	}{
+		{step: 0, next: time.Date(2021, 10, 18, 9, 0, 0, 0, shanghai)},
+		{step: time.Hour, active: "workday", next: time.Date(2021, 10, 18, 18, 0, 0, 0, shanghai)},
+		{step: 8*time.Hour + 59*time.Minute, active: "workday", next: time.Date(2021, 10, 18, 18, 0, 0, 0, shanghai)},
+		{step: time.Minute, next: time.Date(2021, 10, 19, 9, 0, 0, 0, shanghai)},
+		// 周五 18:00 之后，下一次为周一 09:00
+		{step: 4 * 24 * time.Hour, next: time.Date(2021, 10, 25, 9, 0, 0, 0, shanghai)},
+	}
+
+	for i, s := range steps {
+		fakeClock.Step(s.step)
+		now := fakeClock.Now()
+
+		var active string
+		if schedule := ActiveSchedule(schedules, now); schedule != nil {
+			active = schedule.Name
+		}
+		if active != s.active {
+			t.Errorf("step %d at %v: expected active schedule %q, got %q", i, now, s.active, active)
+		}
+		next, ok := NextScheduleBoundary(schedules, now)
+		if !ok || !next.Equal(s.next) {
+			t.Errorf("step %d at %v: expected next boundary %v, got %v", i, now, s.next, next)
+		}
+	}
+}
+
+func TestScheduleTimeZone(t *testing.T) {
+	annotations := map[string]string{
+		"schedule.hpa.caoyingjunz.io/utc.start":       "0 9 * * *",
+		"schedule.hpa.caoyingjunz.io/utc.end":         "0 18 * * *",
+		"schedule.hpa.caoyingjunz.io/utc.minReplicas": "3",
+	}
+	schedules, err := ParseSchedules(annotations)
+	if err != nil {
+		t.Fatalf("failed to parse schedules: %v", err)
+	}
+
+	// 上海时间 10:00 为 UTC 02:00，未设置 timeZone 时按 UTC 计算
+	fakeClock := testingclock.NewFakeClock(time.Date(2021, 10, 18, 10, 0, 0, 0, shanghai))
+	if ActiveSchedule(schedules, fakeClock.Now()) != nil {
+		t.Errorf("expected no active schedule at %v", fakeClock.Now().UTC())
+	}
+	fakeClock.Step(8 * time.Hour)
+	if ActiveSchedule(schedules, fakeClock.Now()) == nil {
+		t.Errorf("expected schedule utc is active at %v", fakeClock.Now().UTC())
+	}
+}
+
+func TestCreateHPAFromDeploymentWithSchedules(t *testing.T) {
+	annotations := workdayAnnotations()
+	annotations["schedule.hpa.caoyingjunz.io/campaign.start"] = "0 0 11 11 *"
+	annotations["schedule.hpa.caoyingjunz.io/campaign.end"] = "0 0 12 11 *"
+	annotations["schedule.hpa.caoyingjunz.io/campaign.timeZone"] = "Asia/Shanghai"
+	annotations["schedule.hpa.caoyingjunz.io/campaign.minReplicas"] = "20"
+	annotations["schedule.hpa.caoyingjunz.io/campaign.maxReplicas"] = "50"
+
+	d := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test1",
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{Containers: []v1.Container{{Name: "nginx", Image: "nginx"}}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		now         time.Time
+		minReplicas int32
+		maxReplicas int32
+		active      string
+	}{
+		{
+			name:        "no active schedule",
+			now:         time.Date(2021, 10, 16, 12, 0, 0, 0, shanghai),
+			minReplicas: 2,
+			maxReplicas: 10,
+		},
+		{
+			name:        "workday",
+			now:         time.Date(2021, 10, 18, 12, 0, 0, 0, shanghai),
+			minReplicas: 5,
+			maxReplicas: 10,
+			active:      "workday",
+		},
+		{
+			// 2021-11-11 为周四，两个定时同时生效时按名称取第一个
+			name:        "campaign overlaps workday",
+			now:         time.Date(2021, 11, 11, 12, 0, 0, 0, shanghai),
+			minReplicas: 20,
+			maxReplicas: 50,
+			active:      "campaign",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fakeClock := testingclock.NewFakeClock(test.now)
+			hpa, err := CreateHPAFromDeployment(d, fakeClock.Now())
+			if err != nil {
+				t.Fatalf("failed to create hpa: %v", err)
+			}
+			if *hpa.Spec.MinReplicas != test.minReplicas || hpa.Spec.MaxReplicas != test.maxReplicas {
+				t.Errorf("expected replicas [%d, %d], got [%d, %d]", test.minReplicas, test.maxReplicas, *hpa.Spec.MinReplicas, hpa.Spec.MaxReplicas)
+			}
+			if active := hpa.Annotations[ActiveScheduleAnnotation]; active != test.active {
+				t.Errorf("expected active schedule %q, got %q", test.active, active)
+			}
+		})
+	}
+}
+
+func TestValidateSchedules(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		errors      []string
+	}{
+		{
+			name:        "valid",
+			annotations: workdayAnnotations(),
+		},
+		{
+			name: "invalid cron and time zone",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+				"schedule.hpa.caoyingjunz.io/night.start":         "0 25 * * *",
+				"schedule.hpa.caoyingjunz.io/night.end":           "CRON_TZ=UTC 0 6 * * *",
+				"schedule.hpa.caoyingjunz.io/night.timeZone":      "Mars/Olympus",
+				"schedule.hpa.caoyingjunz.io/night.maxReplicas":   "3",
+			},
+			errors: []string{
+				"FieldValueInvalid metadata.annotations[schedule.hpa.caoyingjunz.io/night.start]",
+				"FieldValueInvalid metadata.annotations[schedule.hpa.caoyingjunz.io/night.end]",
+				"FieldValueInvalid metadata.annotations[schedule.hpa.caoyingjunz.io/night.timeZone]",
+			},
+		},
+		{
+			name: "missing end and replicas",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+				"schedule.hpa.caoyingjunz.io/night.start":         "0 0 * * *",
+				"schedule.hpa.caoyingjunz.io/night.replicas":      "3",
+			},
+			errors: []string{
+				"FieldValueNotSupported metadata.annotations[schedule.hpa.caoyingjunz.io/night.replicas]",
+				"FieldValueRequired metadata.annotations[schedule.hpa.caoyingjunz.io/night.end]",
+				"FieldValueRequired metadata.annotations[schedule.hpa.caoyingjunz.io/night.minReplicas]",
+			},
+		},
+		{
+			name: "minReplicas exceeds maxReplicas while active",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/maxReplicas":                  "4",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+				"schedule.hpa.caoyingjunz.io/peak.start":          "0 9 * * *",
+				"schedule.hpa.caoyingjunz.io/peak.end":            "0 18 * * *",
+				"schedule.hpa.caoyingjunz.io/peak.minReplicas":    "5",
+			},
+			errors: []string{"FieldValueInvalid metadata.annotations[schedule.hpa.caoyingjunz.io/peak.minReplicas]"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := ValidateAnnotations(test.annotations)
+			got := describeErrors(errs)
+			if len(got) != len(test.errors) {
+				t.Fatalf("expected errors %v, got %v", test.errors, errs)
+			}
+			for i := range got {
+				if got[i] != test.errors[i] {
+					t.Errorf("expected errors %v, got %v", test.errors, errs)
+				}
+			}
+		})
+	}
+}
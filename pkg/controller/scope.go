@@ -0,0 +1,154 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// Scope limits the namespaces and the workloads which are cached and reconciled
+// by the controller. The zero Scope contains everything.
+type Scope struct {
+	// IncludeNamespaces 为空时包含所有命名空间
+	IncludeNamespaces sets.String
+	// ExcludeNamespaces 优先于 IncludeNamespaces
+	ExcludeNamespaces sets.String
+	// NamespaceSelector 为 nil 时选择所有命名空间
+	NamespaceSelector labels.Selector
+	// WorkloadSelector 为 nil 时选择所有工作负载
+	WorkloadSelector labels.Selector
+}
+
+// NewScope creates a scope from the namespace lists and the label selectors.
+func NewScope(includeNamespaces, excludeNamespaces []string, namespaceSelector, workloadSelector string) (*Scope, error) {
+	scope := &Scope{
+		IncludeNamespaces: sets.NewString(includeNamespaces...),
+		ExcludeNamespaces: sets.NewString(excludeNamespaces...),
+	}
+
+	var err error
+	if len(namespaceSelector) != 0 {
+		if scope.NamespaceSelector, err = labels.Parse(namespaceSelector); err != nil {
+			return nil, err
+		}
+	}
+	if len(workloadSelector) != 0 {
+		if scope.WorkloadSelector, err = labels.Parse(workloadSelector); err != nil {
+			return nil, err
+		}
+	}
+	return scope, nil
+}
+
+// Namespace returns the only namespace in scope, it is metav1.NamespaceAll
+// unless exactly one namespace is included.
+func (s *Scope) Namespace() string {
+	if s.IncludeNamespaces.Len() == 1 {
+		return s.IncludeNamespaces.List()[0]
+	}
+	return metav1.NamespaceAll
+}
+
+// Namespaces returns the namespaces which are watched by separate informers,
+// it is [metav1.NamespaceAll] unless namespaces are included.
+func (s *Scope) Namespaces() []string {
+	if s.IncludeNamespaces.Len() == 0 {
+		return []string{metav1.NamespaceAll}
+	}
+	return s.IncludeNamespaces.Difference(s.ExcludeNamespaces).List()
+}
+
+// ForNamespace returns the scope of the informers which watch the namespace,
+// the namespace is one of those returned by Namespaces.
+func (s *Scope) ForNamespace(namespace string) *Scope {
+	if namespace == metav1.NamespaceAll {
+		return s
+	}
+	scope := *s
+	scope.IncludeNamespaces = sets.NewString(namespace)
+	return &scope
+}
+
+// ContainsNamespace returns true if the namespace is included and not excluded,
+// the namespace selector is not considered.
+func (s *Scope) ContainsNamespace(namespace string) bool {
+	if s.ExcludeNamespaces.Has(namespace) {
+		return false
+	}
+	return s.IncludeNamespaces.Len() == 0 || s.IncludeNamespaces.Has(namespace)
+}
+
+// SelectsNamespaces returns true if the namespaces are selected by labels.
+func (s *Scope) SelectsNamespaces() bool {
+	return s.NamespaceSelector != nil && !s.NamespaceSelector.Empty()
+}
+
+// MatchesNamespace returns true if the namespace labels are selected by the
+// namespace selector, the namespace name is checked by ContainsNamespace.
+func (s *Scope) MatchesNamespace(namespaceLabels map[string]string) bool {
+	return !s.SelectsNamespaces() || s.NamespaceSelector.Matches(labels.Set(namespaceLabels))
+}
+
+// MatchesWorkload returns true if the workload labels are selected by the
+// workload selector.
+func (s *Scope) MatchesWorkload(workloadLabels map[string]string) bool {
+	return s.WorkloadSelector == nil || s.WorkloadSelector.Empty() || s.WorkloadSelector.Matches(labels.Set(workloadLabels))
+}
+
+// TweakListOptions limits the namespaced objects to the namespaces in scope,
+// it is used together with the namespace returned by Namespace. Multiple
+// included namespaces are limited by the scopes returned by ForNamespace.
+func (s *Scope) TweakListOptions(options *metav1.ListOptions) {
+	// 唯一的命名空间已经由 informer 的 namespace 限定
+	if s.Namespace() != metav1.NamespaceAll || s.ExcludeNamespaces.Len() == 0 {
+		return
+	}
+	options.FieldSelector = s.excludeSelector("metadata.namespace").String()
+}
+
+// TweakWorkloadListOptions limits the workloads to the namespaces in scope
+// and the workload selector.
+func (s *Scope) TweakWorkloadListOptions(options *metav1.ListOptions) {
+	s.TweakListOptions(options)
+	if s.WorkloadSelector != nil && !s.WorkloadSelector.Empty() {
+		options.LabelSelector = s.WorkloadSelector.String()
+	}
+}
+
+// TweakNamespaceListOptions limits the namespaces by their names and the
+// namespace selector.
+func (s *Scope) TweakNamespaceListOptions(options *metav1.ListOptions) {
+	if namespace := s.Namespace(); namespace != metav1.NamespaceAll {
+		options.FieldSelector = fields.OneTermEqualSelector("metadata.name", namespace).String()
+	} else if s.ExcludeNamespaces.Len() != 0 {
+		options.FieldSelector = s.excludeSelector("metadata.name").String()
+	}
+	if s.SelectsNamespaces() {
+		options.LabelSelector = s.NamespaceSelector.String()
+	}
+}
+
+func (s *Scope) excludeSelector(field string) fields.Selector {
+	selectors := make([]fields.Selector, 0, s.ExcludeNamespaces.Len())
+	for _, namespace := range s.ExcludeNamespaces.List() {
+		selectors = append(selectors, fields.OneTermNotEqualSelector(field, namespace))
+	}
+	return fields.AndSelectors(selectors...)
+}
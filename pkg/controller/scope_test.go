@@ -0,0 +1,224 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestScopeListOptions(t *testing.T) {
+	tests := []struct {
+		name              string
+		includeNamespaces []string
+		excludeNamespaces []string
+		namespaceSelector string
+		workloadSelector  string
+		expectNamespace   string
+		expectNamespaces  []string
+		expectOptions     metav1.ListOptions
+		expectWorkload    metav1.ListOptions
+		expectNSOptions   metav1.ListOptions
+	}{
+		{
+			name:             "everything",
+			expectNamespaces: []string{""},
+		},
+		{
+			name:              "one namespace",
+			includeNamespaces: []string{"team-a"},
+			excludeNamespaces: []string{"kube-system"},
+			workloadSelector:  "app=nginx",
+			expectNamespace:   "team-a",
+			expectNamespaces:  []string{"team-a"},
+			expectWorkload:    metav1.ListOptions{LabelSelector: "app=nginx"},
+			expectNSOptions:   metav1.ListOptions{FieldSelector: "metadata.name=team-a"},
+		},
+		{
+			name:              "excluded namespaces",
+			excludeNamespaces: []string{"kube-system", "kube-public"},
+			namespaceSelector: "tenant=team-a",
+			expectNamespaces:  []string{""},
+			expectOptions:     metav1.ListOptions{FieldSelector: "metadata.namespace!=kube-public,metadata.namespace!=kube-system"},
+			expectWorkload:    metav1.ListOptions{FieldSelector: "metadata.namespace!=kube-public,metadata.namespace!=kube-system"},
+			expectNSOptions:   metav1.ListOptions{FieldSelector: "metadata.name!=kube-public,metadata.name!=kube-system", LabelSelector: "tenant=team-a"},
+		},
+		{
+			// 多个命名空间由 ForNamespace 返回的范围分别限定
+			name:              "multiple namespaces",
+			includeNamespaces: []string{"team-a", "team-b"},
+			expectNamespaces:  []string{"team-a", "team-b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scope, err := NewScope(test.includeNamespaces, test.excludeNamespaces, test.namespaceSelector, test.workloadSelector)
+			if err != nil {
+				t.Fatalf("failed to create scope: %v", err)
+			}
+			if namespace := scope.Namespace(); namespace != test.expectNamespace {
+				t.Errorf("expected namespace %q, got %q", test.expectNamespace, namespace)
+			}
+			if namespaces := scope.Namespaces(); !reflect.DeepEqual(namespaces, test.expectNamespaces) {
+				t.Errorf("expected namespaces %q, got %q", test.expectNamespaces, namespaces)
+			}
+
+			options := metav1.ListOptions{}
+			scope.TweakListOptions(&options)
+			if options != test.expectOptions {
+				t.Errorf("expected list options %+v, got %+v", test.expectOptions, options)
+			}
+			options = metav1.ListOptions{}
+			scope.TweakWorkloadListOptions(&options)
+			if options != test.expectWorkload {
+				t.Errorf("expected workload list options %+v, got %+v", test.expectWorkload, options)
+			}
+			options = metav1.ListOptions{}
+			scope.TweakNamespaceListOptions(&options)
+			if options != test.expectNSOptions {
+				t.Errorf("expected namespace list options %+v, got %+v", test.expectNSOptions, options)
+			}
+		})
+	}
+}
+
+func TestScopeForNamespace(t *testing.T) {
+	scope, err := NewScope([]string{"team-a", "team-b", "team-c"}, []string{"team-c"}, "tenant=pixiu", "app=nginx")
+	if err != nil {
+		t.Fatalf("failed to create scope: %v", err)
+	}
+	if namespaces := scope.Namespaces(); !reflect.DeepEqual(namespaces, []string{"team-a", "team-b"}) {
+		t.Fatalf("expected namespaces [team-a team-b], got %q", namespaces)
+	}
+
+	tests := []struct {
+		namespace       string
+		expectWorkload  metav1.ListOptions
+		expectNSOptions metav1.ListOptions
+	}{
+		{
+			namespace:       "team-a",
+			expectWorkload:  metav1.ListOptions{LabelSelector: "app=nginx"},
+			expectNSOptions: metav1.ListOptions{FieldSelector: "metadata.name=team-a", LabelSelector: "tenant=pixiu"},
+		},
+		{
+			namespace:       "team-b",
+			expectWorkload:  metav1.ListOptions{LabelSelector: "app=nginx"},
+			expectNSOptions: metav1.ListOptions{FieldSelector: "metadata.name=team-b", LabelSelector: "tenant=pixiu"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.namespace, func(t *testing.T) {
+			s := scope.ForNamespace(test.namespace)
+			if namespace := s.Namespace(); namespace != test.namespace {
+				t.Errorf("expected namespace %q, got %q", test.namespace, namespace)
+			}
+
+			options := metav1.ListOptions{}
+			s.TweakListOptions(&options)
+			if options != (metav1.ListOptions{}) {
+				t.Errorf("expected empty list options, got %+v", options)
+			}
+			options = metav1.ListOptions{}
+			s.TweakWorkloadListOptions(&options)
+			if options != test.expectWorkload {
+				t.Errorf("expected workload list options %+v, got %+v", test.expectWorkload, options)
+			}
+			options = metav1.ListOptions{}
+			s.TweakNamespaceListOptions(&options)
+			if options != test.expectNSOptions {
+				t.Errorf("expected namespace list options %+v, got %+v", test.expectNSOptions, options)
+			}
+		})
+	}
+
+	// 原范围不受影响
+	if scope.Namespace() != metav1.NamespaceAll || scope.IncludeNamespaces.Len() != 3 {
+		t.Errorf("expected the scope unchanged, got %+v", scope)
+	}
+}
+
+func TestScopeContainsNamespace(t *testing.T) {
+	scope, err := NewScope([]string{"team-a", "team-b"}, []string{"team-b"}, "", "")
+	if err != nil {
+		t.Fatalf("failed to create scope: %v", err)
+	}
+	for namespace, expected := range map[string]bool{"team-a": true, "team-b": false, "default": false} {
+		if scope.ContainsNamespace(namespace) != expected {
+			t.Errorf("expected ContainsNamespace(%s) to be %v", namespace, expected)
+		}
+	}
+	if !(&Scope{}).ContainsNamespace("default") {
+		t.Errorf("expected the zero scope to contain all namespaces")
+	}
+}
+
+func TestScopeMatches(t *testing.T) {
+	tests := []struct {
+		name              string
+		namespaceSelector string
+		workloadSelector  string
+		labels            map[string]string
+		expectNamespace   bool
+		expectWorkload    bool
+	}{
+		{
+			name:            "no selectors",
+			labels:          map[string]string{"app": "redis"},
+			expectNamespace: true,
+			expectWorkload:  true,
+		},
+		{
+			name:              "selected",
+			namespaceSelector: "app=nginx",
+			workloadSelector:  "app=nginx",
+			labels:            map[string]string{"app": "nginx"},
+			expectNamespace:   true,
+			expectWorkload:    true,
+		},
+		{
+			name:              "not selected",
+			namespaceSelector: "app=nginx",
+			workloadSelector:  "app=nginx",
+			labels:            map[string]string{"app": "redis"},
+		},
+		{
+			name:              "without labels",
+			namespaceSelector: "app=nginx",
+			workloadSelector:  "app=nginx",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scope, err := NewScope(nil, nil, test.namespaceSelector, test.workloadSelector)
+			if err != nil {
+				t.Fatalf("failed to create scope: %v", err)
+			}
+			if matches := scope.MatchesNamespace(test.labels); matches != test.expectNamespace {
+				t.Errorf("expected MatchesNamespace %v, got %v", test.expectNamespace, matches)
+			}
+			if matches := scope.MatchesWorkload(test.labels); matches != test.expectWorkload {
+				t.Errorf("expected MatchesWorkload %v, got %v", test.expectWorkload, matches)
+			}
+		})
+	}
+}
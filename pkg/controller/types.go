@@ -17,8 +17,13 @@ limitations under the License.
 package controller
 
 const (
-	PixiuManager    string = "kubez-autoscaler-controller"
-	PixiuMain       string = "main" // Just for local test
+	// PixiuManager 为控制器 server-side apply 时使用的 field manager
+	PixiuManager string = "pixiu-autoscaler-controller"
+	// LegacyPixiuManager 和 LegacyPixiuMain 为旧版本控制器 create/update HPA 时的 field manager，
+	// 后者为本地运行时的 manager
+	LegacyPixiuManager string = "kubez-autoscaler-controller"
+	LegacyPixiuMain    string = "main"
+
 	PixiuRootPrefix string = "hpa.caoyingjunz.io"
 	PixiuSeparator  string = "/"
 	PixiuDot        string = "."
@@ -27,10 +32,13 @@ const (
 	MaxReplicas              string = "hpa.caoyingjunz.io/maxReplicas"
 	targetAverageUtilization string = "targetAverageUtilization"
 	targetAverageValue       string = "targetAverageValue"
+	targetValue              string = "targetValue"
 
 	cpu        string = "cpu"
 	memory     string = "memory"
 	prometheus string = "prometheus"
+	pods       string = "pods"
+	object     string = "object"
 
 	cpuAverageUtilization        = "cpu." + PixiuRootPrefix + PixiuSeparator + targetAverageUtilization
 	memoryAverageUtilization     = "memory." + PixiuRootPrefix + PixiuSeparator + targetAverageUtilization
@@ -39,11 +47,88 @@ const (
 	// PrometheusCustomMetric 指标来自 prometheus 时，需要指定指标名称
 	PrometheusCustomMetric = PixiuRootPrefix + PixiuSeparator + "targetCustomMetric"
 
+	// 具名的 prometheus 指标，同一个工作负载可以维护多个，格式为 prometheus.hpa.caoyingjunz.io/<alias>.<field>，例如:
+	// prometheus.hpa.caoyingjunz.io/http.targetAverageValue: "100"
+	// prometheus.hpa.caoyingjunz.io/http.metric: "http_requests_total"
+	// prometheus.hpa.caoyingjunz.io/http.seriesQuery: "http_requests_total{job=\"api\"}"
+	// metric 缺省时使用 nameAs 或 alias 作为指标名称，seriesQuery 缺省时使用指标名称
+	// 此外还支持完整的 prometheus-adapter 规则和指标的标签选择器:
+	// prometheus.hpa.caoyingjunz.io/http.metricsQuery: "sum(rate(<<.Series>>{<<.LabelMatchers>>}[2m])) by (<<.GroupBy>>)"
+	// prometheus.hpa.caoyingjunz.io/http.nameMatches: "^(.*)_total$"
+	// prometheus.hpa.caoyingjunz.io/http.nameAs: "http_requests_per_second"
+	// prometheus.hpa.caoyingjunz.io/http.selector: "service=api,method in (GET,POST)"
+	prometheusMetric       string = "metric"
+	prometheusSeriesQuery  string = "seriesQuery"
+	prometheusMetricsQuery string = "metricsQuery"
+	prometheusNameMatches  string = "nameMatches"
+	prometheusNameAs       string = "nameAs"
+	prometheusSelector     string = "selector"
+
+	// DefaultMetricsQuery 为 externalRules 默认的 metricsQuery
+	DefaultMetricsQuery string = "<<.Series>>"
+
+	// ExternalRulesAnnotation 记录 HPA 所需的 prometheus-adapter externalRules，由控制器写入 HPA
+	ExternalRulesAnnotation = PixiuRootPrefix + PixiuSeparator + "externalRules"
+
 	// CPU, in cores. (500m = .5 cores)
 	// Memory, in bytes. (500Gi = 500GiB = 500 * 1024 * 1024 * 1024)
 	cpuAverageValue        = "cpu." + PixiuRootPrefix + PixiuSeparator + targetAverageValue
 	memoryAverageValue     = "memory." + PixiuRootPrefix + PixiuSeparator + targetAverageValue
 	prometheusAverageValue = "prometheus." + PixiuRootPrefix + PixiuSeparator + targetAverageValue
+
+	// Pods 类型的指标，为每个 pod 的自定义指标（例如每秒请求数）的平均值，需要指定指标名称
+	podsAverageValue = "pods." + PixiuRootPrefix + PixiuSeparator + targetAverageValue
+	PodsCustomMetric = PixiuRootPrefix + PixiuSeparator + "targetPodsMetric"
+
+	// Object 类型的指标，描述集群中的某个对象（例如 Ingress 的请求数，Service 的队列长度），需要指定指标名称和对象
+	// 对象的格式为 <apiVersion>/<kind>/<name>，例如 networking.k8s.io/v1/Ingress/main-route，v1/Service/queue
+	objectValue        = "object." + PixiuRootPrefix + PixiuSeparator + targetValue
+	objectAverageValue = "object." + PixiuRootPrefix + PixiuSeparator + targetAverageValue
+	ObjectCustomMetric = PixiuRootPrefix + PixiuSeparator + "targetObjectMetric"
+	ObjectCustomTarget = PixiuRootPrefix + PixiuSeparator + "targetObject"
+
+	// 容器级别的资源指标，格式为 <cpu|memory>.hpa.caoyingjunz.io/container.<name>.<target>，例如:
+	// cpu.hpa.caoyingjunz.io/container.nginx.targetAverageUtilization: "60"
+	containerPrefix string = "container."
+
+	// 命名空间上的 hpa 注释作为其中所有工作负载的默认注释，工作负载自身的注释优先
+	// 工作负载的 InheritNamespaceDefaults 注释为 "false" 时，不继承命名空间的默认注释
+	InheritNamespaceDefaults = PixiuRootPrefix + PixiuSeparator + "inheritNamespaceDefaults"
+
+	// HPA 的伸缩行为，例如:
+	// hpa.caoyingjunz.io/scaleUp.stabilizationWindowSeconds: "60"
+	// hpa.caoyingjunz.io/scaleUp.selectPolicy: "Max"
+	// hpa.caoyingjunz.io/scaleUp.policies: "Pods:4:60,Percent:100:15"
+	scaleUp                    string = "scaleUp"
+	scaleDown                  string = "scaleDown"
+	stabilizationWindowSeconds string = "stabilizationWindowSeconds"
+	selectPolicy               string = "selectPolicy"
+	policies                   string = "policies"
+
+	// 定时的副本数范围，同一个工作负载可以维护多个，格式为 schedule.hpa.caoyingjunz.io/<name>.<field>，例如:
+	// schedule.hpa.caoyingjunz.io/workday.start: "0 9 * * 1-5"
+	// schedule.hpa.caoyingjunz.io/workday.end: "0 18 * * 1-5"
+	// schedule.hpa.caoyingjunz.io/workday.timeZone: "Asia/Shanghai"
+	// schedule.hpa.caoyingjunz.io/workday.minReplicas: "4"
+	// schedule.hpa.caoyingjunz.io/workday.maxReplicas: "20"
+	// start 和 end 为标准的 cron 表达式，timeZone 缺省时为 UTC，生效期间覆盖 minReplicas 和 maxReplicas
+	schedule            string = "schedule"
+	scheduleStart       string = "start"
+	scheduleEnd         string = "end"
+	scheduleTimeZone    string = "timeZone"
+	scheduleMinReplicas string = "minReplicas"
+	scheduleMaxReplicas string = "maxReplicas"
+
+	// ActiveScheduleAnnotation 记录 HPA 当前生效的定时副本数范围，由控制器写入 HPA
+	ActiveScheduleAnnotation = PixiuRootPrefix + PixiuSeparator + "activeSchedule"
+
+	// Paused 为 "true" 时暂停工作负载的自动伸缩，HPA 的 minReplicas 和 maxReplicas 固定为当前副本数，
+	// 移除注释后恢复。PausedUntil 为可选的 RFC3339 格式的时间，到期后自动恢复，例如:
+	// hpa.caoyingjunz.io/paused: "true"
+	// hpa.caoyingjunz.io/pausedUntil: "2021-10-18T12:00:00Z"
+	// 控制器同时在暂停的 HPA 上写入 Paused 注释
+	Paused      = PixiuRootPrefix + PixiuSeparator + "paused"
+	PausedUntil = PixiuRootPrefix + PixiuSeparator + "pausedUntil"
 )
 
 const (
@@ -51,40 +136,54 @@ const (
 	AutoscalingAPIVersion string = "autoscaling/v2"
 
 	Deployment              string = "Deployment"
+	StatefulSet             string = "StatefulSet"
 	HorizontalPodAutoscaler string = "HorizontalPodAutoscaler"
 
 	DesireConfigMapName string = "prometheus-adapter"
 	NotifyAt            string = PixiuRootPrefix + PixiuSeparator + "notifyAt"
+	// NotifyPending 记录尚未完成的 prometheus-adapter 通知，与规则一同写入，通知完成后清除；同步或控制器启动时重新通知
+	NotifyPending string = PixiuRootPrefix + PixiuSeparator + "notifyPending"
+
+	DefaultAdapterNamespace string = "pixiu-system"
+	DefaultAdapterConfigKey string = "config.yaml"
 )
 
-type PrometheusAdapterConfig struct {
-	Rules         []Rule         `yaml:"rules"`
-	ExternalRules []ExternalRule `yaml:"externalRules"`
+// PrometheusAdapter describes where the prometheus adapter and its config are deployed.
+type PrometheusAdapter struct {
+	// Namespace is the namespace of the prometheus adapter.
+	Namespace string
+	// ConfigMapName is the name of the configmap which holds the adapter config.
+	ConfigMapName string
+	// ConfigMapKey is the data key of the adapter config in the configmap.
+	ConfigMapKey string
+	// DeploymentName is the name of the prometheus adapter deployment.
+	DeploymentName string
 }
 
 type Rule struct {
-	MetricsQuery string      `yaml:"metricsQuery"`
-	Name         RuleName    `yaml:"name"`
-	Resources    ResourceMap `yaml:"resources"`
-	SeriesQuery  string      `yaml:"seriesQuery"`
+	MetricsQuery string      `yaml:"metricsQuery" json:"metricsQuery"`
+	Name         RuleName    `yaml:"name" json:"name"`
+	Resources    ResourceMap `yaml:"resources" json:"resources"`
+	SeriesQuery  string      `yaml:"seriesQuery" json:"seriesQuery"`
 }
 
 type ExternalRule struct {
-	MetricsQuery string      `yaml:"metricsQuery"`
-	Name         RuleName    `yaml:"name"`
-	Resources    ResourceMap `yaml:"resources"`
-	SeriesQuery  string      `yaml:"seriesQuery"`
+	MetricsQuery string      `yaml:"metricsQuery" json:"metricsQuery"`
+	Name         RuleName    `yaml:"name" json:"name"`
+	Resources    ResourceMap `yaml:"resources" json:"resources"`
+	SeriesQuery  string      `yaml:"seriesQuery" json:"seriesQuery"`
 }
 
 type RuleName struct {
-	As      string `yaml:"as"`
-	Matches string `yaml:"matches"`
+	As      string `yaml:"as" json:"as"`
+	Matches string `yaml:"matches" json:"matches"`
 }
 
 type ResourceMap struct {
-	Overrides map[string]ResourceOverride `yaml:"overrides"`
+	Overrides map[string]ResourceOverride `yaml:"overrides" json:"overrides"`
 }
 
 type ResourceOverride struct {
-	Resource string `yaml:"resource"`
+	Group    string `yaml:"group,omitempty" json:"group,omitempty"`
+	Resource string `yaml:"resource" json:"resource"`
 }
@@ -0,0 +1,284 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// defaultMaxReplicas 为未设置 maxReplicas 注释时的默认值
+const defaultMaxReplicas = 6
+
+var (
+	supportedMetricTypes = []string{cpu, memory, prometheus, pods, object}
+
+	// knownAnnotations 为 hpa.caoyingjunz.io/<name> 格式的合法注释
+	knownAnnotations = map[string]Empty{
+		MinReplicas:              {},
+		MaxReplicas:              {},
+		PrometheusCustomMetric:   {},
+		PodsCustomMetric:         {},
+		ObjectCustomMetric:       {},
+		ObjectCustomTarget:       {},
+		InheritNamespaceDefaults: {},
+		Paused:                   {},
+		PausedUntil:              {},
+	}
+)
+
+// ValidateAnnotations validates the hpa annotations of a workload against the
+// annotation grammar. Unlike the parsing in CreateHPAFromWorkload, which stops
+// at the first error, all the problems are collected.
+func ValidateAnnotations(annotations map[string]string) field.ErrorList {
+	fldPath := field.NewPath("metadata", "annotations")
+
+	allErrs := validateReplicas(annotations, fldPath)
+	allErrs = append(allErrs, validateMetrics(annotations, fldPath)...)
+	allErrs = append(allErrs, validateSchedules(annotations, fldPath)...)
+	allErrs = append(allErrs, validatePause(annotations, fldPath)...)
+	for _, key := range sortedKeys(annotations) {
+		if !IsPixiuAnnotation(key) || !strings.HasPrefix(key, PixiuRootPrefix+PixiuSeparator) {
+			continue
+		}
+		allErrs = append(allErrs, validateRootAnnotation(key, annotations[key], fldPath.Key(key))...)
+	}
+
+	return allErrs
+}
+
+func validateReplicas(annotations map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	minReplicas, err := extractReplicas(annotations, MinReplicas)
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Key(MinReplicas), annotations[MinReplicas], "must be an integer"))
+	} else if minReplicas < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Key(MinReplicas), annotations[MinReplicas], "must be greater than 0"))
+	}
+	maxReplicas, err := extractReplicas(annotations, MaxReplicas)
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Key(MaxReplicas), annotations[MaxReplicas], "must be an integer"))
+	} else if maxReplicas < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Key(MaxReplicas), annotations[MaxReplicas], "must be greater than 0"))
+	}
+	if len(allErrs) != 0 || maxReplicas >= minReplicas {
+		return allErrs
+	}
+
+	if _, ok := annotations[MaxReplicas]; ok {
+		allErrs = append(allErrs, field.Invalid(fldPath.Key(MaxReplicas), annotations[MaxReplicas],
+			fmt.Sprintf("must be greater than or equal to %s(%d)", MinReplicas, minReplicas)))
+	} else {
+		allErrs = append(allErrs, field.Invalid(fldPath.Key(MinReplicas), annotations[MinReplicas],
+			fmt.Sprintf("must be less than or equal to the default %s(%d)", MaxReplicas, defaultMaxReplicas)))
+	}
+	return allErrs
+}
+
+func validateMetrics(annotations map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	// 记录每个指标对应的注释，用于检查重复的指标
+	seen := make(map[string]string)
+	for _, key := range sortedKeys(annotations) {
+		if !strings.Contains(key, PixiuDot+PixiuRootPrefix+PixiuSeparator) || IsScheduleAnnotation(key) {
+			continue
+		}
+		value := annotations[key]
+		path := fldPath.Key(key)
+
+		metricType, target, err := getMetricTarget(key)
+		if err != nil {
+			allErrs = append(allErrs, field.NotSupported(path, metricTypeOf(key), supportedMetricTypes))
+			continue
+		}
+		if metricType == prometheus && isPrometheusAttribute(target) {
+			allErrs = append(allErrs, validatePrometheusAttribute(key, target, annotations, path)...)
+			continue
+		}
+
+		metricSpec, err := parseMetricSpec(target, metricType, value, annotations)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(path, value, err.Error()))
+			continue
+		}
+		if metricType == prometheus {
+			if alias, _, ok := splitNamedTarget(target); ok {
+				if err = ValidateExternalRule(externalRuleFor(alias, annotations)); err != nil {
+					allErrs = append(allErrs, field.Invalid(path, value, fmt.Sprintf("invalid prometheus metric %s: %v", alias, err)))
+					continue
+				}
+			}
+		}
+
+		id := metricIdentity(metricSpec)
+		if previous, found := seen[id]; found {
+			allErrs = append(allErrs, field.Invalid(path, value, fmt.Sprintf("duplicates the metric of %s", previous)))
+			continue
+		}
+		seen[id] = key
+	}
+
+	return allErrs
+}
+
+// validateSchedules validates the schedules, the bounds overridden by each
+// schedule must be valid while it is active.
+func validateSchedules(annotations map[string]string, fldPath *field.Path) field.ErrorList {
+	schedules, allErrs := parseSchedules(annotations, fldPath)
+
+	minReplicas, minErr := extractReplicas(annotations, MinReplicas)
+	maxReplicas, maxErr := extractReplicas(annotations, MaxReplicas)
+	if minErr != nil || maxErr != nil {
+		// 错误已由 validateReplicas 报告
+		return allErrs
+	}
+	for _, s := range schedules {
+		effectiveMin, effectiveMax := s.Replicas(minReplicas, maxReplicas)
+		if effectiveMin <= effectiveMax {
+			continue
+		}
+		if s.MaxReplicas != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(scheduleKey(s.Name, scheduleMaxReplicas)), effectiveMax,
+				fmt.Sprintf("must be greater than or equal to the minReplicas(%d) while schedule %s is active", effectiveMin, s.Name)))
+		} else {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(scheduleKey(s.Name, scheduleMinReplicas)), effectiveMin,
+				fmt.Sprintf("must be less than or equal to the maxReplicas(%d) while schedule %s is active", effectiveMax, s.Name)))
+		}
+	}
+
+	return allErrs
+}
+
+func validatePause(annotations map[string]string, fldPath *field.Path) field.ErrorList {
+	allErrs := field.ErrorList{}
+
+	if value, ok := annotations[Paused]; ok {
+		if _, err := strconv.ParseBool(value); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(Paused), value, "must be a boolean"))
+		}
+	}
+	if value, ok := annotations[PausedUntil]; ok {
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(PausedUntil), value, "must be a RFC3339 time"))
+		}
+	}
+
+	return allErrs
+}
+
+// validatePrometheusAttribute checks that the named prometheus metric of the
+// attribute has a target.
+func validatePrometheusAttribute(key, target string, annotations map[string]string, path *field.Path) field.ErrorList {
+	alias, _, _ := splitNamedTarget(target)
+	for _, t := range []string{targetAverageValue, targetValue} {
+		if _, ok := annotations[prometheusMetricKey(alias, t)]; ok {
+			return nil
+		}
+	}
+
+	return field.ErrorList{field.Invalid(path, annotations[key], fmt.Sprintf("prometheus metric %s has neither %s nor %s", alias, targetAverageValue, targetValue))}
+}
+
+func validateRootAnnotation(key, value string, path *field.Path) field.ErrorList {
+	if _, found := knownAnnotations[key]; found {
+		return nil
+	}
+
+	name := strings.TrimPrefix(key, PixiuRootPrefix+PixiuSeparator)
+	for _, direction := range []string{scaleUp, scaleDown} {
+		if !strings.HasPrefix(name, direction+PixiuDot) {
+			continue
+		}
+		switch strings.TrimPrefix(name, direction+PixiuDot) {
+		case stabilizationWindowSeconds, selectPolicy, policies:
+		default:
+			return field.ErrorList{field.NotSupported(path, key, []string{stabilizationWindowSeconds, selectPolicy, policies})}
+		}
+		if _, err := parseScalingRules(map[string]string{key: value}, direction); err != nil {
+			return field.ErrorList{field.Invalid(path, value, err.Error())}
+		}
+		return nil
+	}
+
+	return field.ErrorList{field.Invalid(path, value, "unknown annotation")}
+}
+
+// metricTypeOf returns the metric type of the annotation for error messages.
+func metricTypeOf(key string) string {
+	return strings.SplitN(key, PixiuDot, 2)[0]
+}
+
+// metricIdentity identifies the metric of the metric spec regardless of its
+// target, two metric specs with the same identity are duplicated.
+func metricIdentity(metric autoscalingv2.MetricSpec) string {
+	switch metric.Type {
+	case autoscalingv2.ResourceMetricSourceType:
+		return strings.Join([]string{string(metric.Type), string(metric.Resource.Name)}, PixiuSeparator)
+	case autoscalingv2.ContainerResourceMetricSourceType:
+		return strings.Join([]string{string(metric.Type), string(metric.ContainerResource.Name), metric.ContainerResource.Container}, PixiuSeparator)
+	case autoscalingv2.PodsMetricSourceType:
+		return strings.Join([]string{string(metric.Type), metricIdentifier(metric.Pods.Metric)}, PixiuSeparator)
+	case autoscalingv2.ObjectMetricSourceType:
+		ref := metric.Object.DescribedObject
+		return strings.Join([]string{string(metric.Type), ref.APIVersion, ref.Kind, ref.Name, metricIdentifier(metric.Object.Metric)}, PixiuSeparator)
+	case autoscalingv2.ExternalMetricSourceType:
+		return strings.Join([]string{string(metric.Type), metricIdentifier(metric.External.Metric)}, PixiuSeparator)
+	}
+	return string(metric.Type)
+}
+
+func metricIdentifier(metric autoscalingv2.MetricIdentifier) string {
+	if metric.Selector == nil {
+		return metric.Name
+	}
+	return metric.Name + "{" + metav1.FormatLabelSelector(metric.Selector) + "}"
+}
+
+// ParseFailureReasons returns the reasons of the error returned by
+// CreateHPAFromWorkload, one for each failure. The reason of the annotation
+// validation error is its type, such as FieldValueInvalid.
+func ParseFailureReasons(err error) []string {
+	if err == nil {
+		return nil
+	}
+	if IsContainerNotFound(err) {
+		return []string{"ContainerNotFound"}
+	}
+
+	agg, ok := err.(utilerrors.Aggregate)
+	if !ok {
+		return []string{"Unknown"}
+	}
+	var reasons []string
+	for _, e := range agg.Errors() {
+		if fieldErr, ok := e.(*field.Error); ok {
+			reasons = append(reasons, string(fieldErr.Type))
+			continue
+		}
+		reasons = append(reasons, "Unknown")
+	}
+	return reasons
+}
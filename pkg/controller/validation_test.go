@@ -0,0 +1,271 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+func TestValidateAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		// errors 为期望的错误，格式为 <type> <field>
+		errors []string
+	}{
+		{
+			name: "valid resource metrics",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas":                  "2",
+				"hpa.caoyingjunz.io/maxReplicas":                  "10",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+				"memory.hpa.caoyingjunz.io/targetAverageValue":    "512Mi",
+				"app.kubernetes.io/name":                          "nginx",
+			},
+		},
+		{
+			name: "valid named prometheus metric and behavior",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/http.targetAverageValue":   "100",
+				"prometheus.hpa.caoyingjunz.io/http.seriesQuery":          `http_requests_total{namespace!=""}`,
+				"hpa.caoyingjunz.io/scaleDown.stabilizationWindowSeconds": "60",
+			},
+		},
+		{
+			name: "minReplicas greater than maxReplicas",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas":                  "5",
+				"hpa.caoyingjunz.io/maxReplicas":                  "2",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			errors: []string{"FieldValueInvalid metadata.annotations[hpa.caoyingjunz.io/maxReplicas]"},
+		},
+		{
+			name: "minReplicas greater than default maxReplicas",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas":                  "7",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			errors: []string{"FieldValueInvalid metadata.annotations[hpa.caoyingjunz.io/minReplicas]"},
+		},
+		{
+			name: "non-numeric replicas",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas":                  "one",
+				"hpa.caoyingjunz.io/maxReplicas":                  "0",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			errors: []string{
+				"FieldValueInvalid metadata.annotations[hpa.caoyingjunz.io/minReplicas]",
+				"FieldValueInvalid metadata.annotations[hpa.caoyingjunz.io/maxReplicas]",
+			},
+		},
+		{
+			name: "utilization out of range",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization":    "120",
+				"memory.hpa.caoyingjunz.io/targetAverageUtilization": "0",
+			},
+			errors: []string{
+				"FieldValueInvalid metadata.annotations[cpu.hpa.caoyingjunz.io/targetAverageUtilization]",
+				"FieldValueInvalid metadata.annotations[memory.hpa.caoyingjunz.io/targetAverageUtilization]",
+			},
+		},
+		{
+			name: "unknown target suffix",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverage": "70",
+			},
+			errors: []string{"FieldValueInvalid metadata.annotations[cpu.hpa.caoyingjunz.io/targetAverage]"},
+		},
+		{
+			name: "unknown metric type",
+			annotations: map[string]string{
+				"gpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			errors: []string{"FieldValueNotSupported metadata.annotations[gpu.hpa.caoyingjunz.io/targetAverageUtilization]"},
+		},
+		{
+			name: "unknown annotation",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+				"hpa.caoyingjunz.io/maxReplica":                   "3",
+				"hpa.caoyingjunz.io/scaleUp.window":               "30",
+			},
+			errors: []string{
+				"FieldValueInvalid metadata.annotations[hpa.caoyingjunz.io/maxReplica]",
+				"FieldValueNotSupported metadata.annotations[hpa.caoyingjunz.io/scaleUp.window]",
+			},
+		},
+		{
+			name: "invalid behavior",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+				"hpa.caoyingjunz.io/scaleUp.selectPolicy":         "Random",
+			},
+			errors: []string{"FieldValueInvalid metadata.annotations[hpa.caoyingjunz.io/scaleUp.selectPolicy]"},
+		},
+		{
+			name: "bad prometheus quantity",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/targetCustomMetric":            "http_requests",
+				"prometheus.hpa.caoyingjunz.io/targetAverageValue": "1x0",
+			},
+			errors: []string{"FieldValueInvalid metadata.annotations[prometheus.hpa.caoyingjunz.io/targetAverageValue]"},
+		},
+		{
+			name: "utilization on prometheus metrics",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/targetCustomMetric":                       "http_requests",
+				"prometheus.hpa.caoyingjunz.io/targetAverageUtilization":      "50",
+				"prometheus.hpa.caoyingjunz.io/http.targetAverageUtilization": "50",
+			},
+			errors: []string{
+				"FieldValueInvalid metadata.annotations[prometheus.hpa.caoyingjunz.io/http.targetAverageUtilization]",
+				"FieldValueInvalid metadata.annotations[prometheus.hpa.caoyingjunz.io/targetAverageUtilization]",
+			},
+		},
+		{
+			name: "prometheus attribute without target",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+				"prometheus.hpa.caoyingjunz.io/http.seriesQuery":  "http_requests_total",
+			},
+			errors: []string{"FieldValueInvalid metadata.annotations[prometheus.hpa.caoyingjunz.io/http.seriesQuery]"},
+		},
+		{
+			name: "invalid prometheus rule",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/http.targetValue": "100",
+				"prometheus.hpa.caoyingjunz.io/http.nameMatches": "^(.*",
+			},
+			errors: []string{"FieldValueInvalid metadata.annotations[prometheus.hpa.caoyingjunz.io/http.targetValue]"},
+		},
+		{
+			name: "duplicate resource metrics",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+				"cpu.hpa.caoyingjunz.io/targetAverageValue":       "500m",
+			},
+			errors: []string{"FieldValueInvalid metadata.annotations[cpu.hpa.caoyingjunz.io/targetAverageValue]"},
+		},
+		{
+			name: "duplicate prometheus metrics",
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/http.targetAverageValue": "100",
+				"prometheus.hpa.caoyingjunz.io/http.targetValue":        "1k",
+			},
+			errors: []string{"FieldValueInvalid metadata.annotations[prometheus.hpa.caoyingjunz.io/http.targetValue]"},
+		},
+		{
+			name: "container metrics are not duplicated with the resource metrics",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization":                 "70",
+				"cpu.hpa.caoyingjunz.io/container.nginx.targetAverageUtilization": "60",
+				"cpu.hpa.caoyingjunz.io/container.redis.targetAverageUtilization": "60",
+			},
+		},
+		{
+			name: "invalid pause",
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+				"hpa.caoyingjunz.io/paused":                       "yes",
+				"hpa.caoyingjunz.io/pausedUntil":                  "2021-10-18 12:00",
+			},
+			errors: []string{
+				"FieldValueInvalid metadata.annotations[hpa.caoyingjunz.io/paused]",
+				"FieldValueInvalid metadata.annotations[hpa.caoyingjunz.io/pausedUntil]",
+			},
+		},
+		{
+			name: "multiple errors are aggregated",
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas":                  "5",
+				"hpa.caoyingjunz.io/maxReplicas":                  "2",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "101",
+				"memory.hpa.caoyingjunz.io/targetValue":           "1Gi",
+			},
+			errors: []string{
+				"FieldValueInvalid metadata.annotations[hpa.caoyingjunz.io/maxReplicas]",
+				"FieldValueInvalid metadata.annotations[cpu.hpa.caoyingjunz.io/targetAverageUtilization]",
+				"FieldValueInvalid metadata.annotations[memory.hpa.caoyingjunz.io/targetValue]",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := ValidateAnnotations(test.annotations)
+			if got := describeErrors(errs); strings.Join(got, "\n") != strings.Join(test.errors, "\n") {
+				t.Errorf("expected errors %v, got %v", test.errors, errs)
+			}
+		})
+	}
+}
+
+func TestCreateHPAFromWorkloadReturnsAllErrors(t *testing.T) {
+	w := &Workload{}
+	w.Name = "test1"
+	w.Namespace = "default"
+	w.Annotations = map[string]string{
+		"hpa.caoyingjunz.io/minReplicas":                  "5",
+		"hpa.caoyingjunz.io/maxReplicas":                  "2",
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "101",
+	}
+
+	_, err := CreateHPAFromWorkload(w, time.Now())
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	for _, annotation := range []string{MaxReplicas, "cpu.hpa.caoyingjunz.io/targetAverageUtilization"} {
+		if !strings.Contains(err.Error(), annotation) {
+			t.Errorf("expected error containing %s, got %v", annotation, err)
+		}
+	}
+}
+
+func describeErrors(errs field.ErrorList) []string {
+	var described []string
+	for _, err := range errs {
+		described = append(described, string(err.Type)+" "+err.Field)
+	}
+	return described
+}
+
+func TestParseFailureReasons(t *testing.T) {
+	w := &Workload{}
+	w.Name = "test1"
+	w.Namespace = "default"
+	w.Annotations = map[string]string{
+		"hpa.caoyingjunz.io/minReplicas":                  "one",
+		"hpa.caoyingjunz.io/scaleUp.window":               "30",
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+	}
+
+	_, err := CreateHPAFromWorkload(w, time.Now())
+	reasons := ParseFailureReasons(err)
+	if expected := "FieldValueInvalid,FieldValueNotSupported"; strings.Join(reasons, ",") != expected {
+		t.Errorf("expected reasons %s, got %v", expected, reasons)
+	}
+	if reasons = ParseFailureReasons(&ContainerNotFoundError{Container: "nginx"}); len(reasons) != 1 || reasons[0] != "ContainerNotFound" {
+		t.Errorf("expected reason ContainerNotFound, got %v", reasons)
+	}
+}
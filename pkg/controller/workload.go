@@ -0,0 +1,211 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Workload describes an object which could be scaled by HPA, it hides the
+// differences between Deployment, StatefulSet and so on.
+type Workload struct {
+	metav1.TypeMeta
+	metav1.ObjectMeta
+
+	// Selector 为工作负载的 pod 选择标签，生成的 HPA 会继承该标签
+	Selector map[string]string
+	// Template 为工作负载的 pod 模板，可能为空
+	Template *v1.PodTemplateSpec
+	// Replicas 为工作负载期望的副本数，可能为空
+	Replicas *int32
+
+	// Object 为工作负载的原始对象，用于记录事件
+	Object runtime.Object
+}
+
+// GroupKind returns the group kind of the workload.
+func (w *Workload) GroupKind() schema.GroupKind {
+	return w.GroupVersionKind().GroupKind()
+}
+
+// NewWorkloadFromDeployment creates a workload from the given deployment.
+func NewWorkloadFromDeployment(d *appsv1.Deployment) *Workload {
+	w := &Workload{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: AppsAPIVersion,
+			Kind:       Deployment,
+		},
+		ObjectMeta: d.ObjectMeta,
+		Template:   &d.Spec.Template,
+		Replicas:   d.Spec.Replicas,
+		Object:     d,
+	}
+	if d.Spec.Selector != nil {
+		w.Selector = d.Spec.Selector.MatchLabels
+	}
+
+	return w
+}
+
+// NewWorkloadFromStatefulSet creates a workload from the given statefulset.
+func NewWorkloadFromStatefulSet(s *appsv1.StatefulSet) *Workload {
+	w := &Workload{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: AppsAPIVersion,
+			Kind:       StatefulSet,
+		},
+		ObjectMeta: s.ObjectMeta,
+		Template:   &s.Spec.Template,
+		Replicas:   s.Spec.Replicas,
+		Object:     s,
+	}
+	if s.Spec.Selector != nil {
+		w.Selector = s.Spec.Selector.MatchLabels
+	}
+
+	return w
+}
+
+// NewWorkloadFromObject creates a workload from a generic object, which is
+// usually a *metav1.PartialObjectMetadata listed by the metadata informer.
+func NewWorkloadFromObject(gvk schema.GroupVersionKind, obj runtime.Object) (*Workload, error) {
+	var objectMeta metav1.ObjectMeta
+	if m, ok := obj.(*metav1.PartialObjectMetadata); ok {
+		// 元数据对象不含类型信息，补全后用于记录事件
+		m = m.DeepCopy()
+		m.SetGroupVersionKind(gvk)
+		objectMeta = m.ObjectMeta
+		obj = m
+	} else {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		objectMeta = metav1.ObjectMeta{
+			Name:              accessor.GetName(),
+			Namespace:         accessor.GetNamespace(),
+			UID:               accessor.GetUID(),
+			ResourceVersion:   accessor.GetResourceVersion(),
+			DeletionTimestamp: accessor.GetDeletionTimestamp(),
+			Labels:            accessor.GetLabels(),
+			Annotations:       accessor.GetAnnotations(),
+			OwnerReferences:   accessor.GetOwnerReferences(),
+		}
+	}
+
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	return &Workload{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiVersion,
+			Kind:       kind,
+		},
+		ObjectMeta: objectMeta,
+		Object:     obj,
+	}, nil
+}
+
+// MergeNamespaceDefaults merges the default hpa annotations of the namespace
+// under the workload's own annotations, so that the workload's take precedence.
+func (w *Workload) MergeNamespaceDefaults(ns *v1.Namespace) {
+	if !w.InheritsNamespaceDefaults() {
+		return
+	}
+	defaults := NamespaceDefaults(ns)
+	if len(defaults) == 0 {
+		return
+	}
+
+	merged := make(map[string]string, len(defaults)+len(w.Annotations))
+	for k, v := range defaults {
+		merged[k] = v
+	}
+	for k, v := range w.Annotations {
+		merged[k] = v
+	}
+	w.Annotations = merged
+}
+
+// InheritsNamespaceDefaults returns true unless the workload opts out of the
+// namespace defaults.
+func (w *Workload) InheritsNamespaceDefaults() bool {
+	return w.Annotations[InheritNamespaceDefaults] != "false"
+}
+
+// NamespaceDefaults returns the default hpa annotations carried by the namespace.
+func NamespaceDefaults(ns *v1.Namespace) map[string]string {
+	if ns == nil {
+		return nil
+	}
+
+	var defaults map[string]string
+	for k, v := range ns.Annotations {
+		if k == InheritNamespaceDefaults || !IsPixiuAnnotation(k) {
+			continue
+		}
+		if defaults == nil {
+			defaults = make(map[string]string)
+		}
+		defaults[k] = v
+	}
+	return defaults
+}
+
+// IsPixiuAnnotation returns true if the annotation is in the format of
+// hpa.caoyingjunz.io/<name> or <metric>.hpa.caoyingjunz.io/<name>.
+func IsPixiuAnnotation(annotation string) bool {
+	parts := strings.SplitN(annotation, PixiuSeparator, 2)
+	if len(parts) != 2 {
+		return false
+	}
+	return parts[0] == PixiuRootPrefix || strings.HasSuffix(parts[0], PixiuDot+PixiuRootPrefix)
+}
+
+// WorkloadKeyFunc builds the queue key for a workload, the key is in the
+// format of <kind>.<group>/<namespace>/<name>.
+func WorkloadKeyFunc(gk schema.GroupKind, obj interface{}) (string, error) {
+	key, err := KeyFunc(obj)
+	if err != nil {
+		return "", err
+	}
+
+	return gk.String() + PixiuSeparator + key, nil
+}
+
+// SplitWorkloadKey returns the group kind, namespace and name that
+// WorkloadKeyFunc encoded into key.
+func SplitWorkloadKey(key string) (schema.GroupKind, string, string, error) {
+	parts := strings.SplitN(key, PixiuSeparator, 2)
+	if len(parts) != 2 {
+		return schema.GroupKind{}, "", "", fmt.Errorf("unexpected workload key format: %q", key)
+	}
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(parts[1])
+	if err != nil {
+		return schema.GroupKind{}, "", "", err
+	}
+
+	return schema.ParseGroupKind(parts[0]), namespace, name, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMergeNamespaceDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		// nsAnnotations 为 nil 时命名空间不存在
+		nsAnnotations map[string]string
+		annotations   map[string]string
+		expected      map[string]string
+	}{
+		{
+			name: "inherit defaults",
+			nsAnnotations: map[string]string{
+				"hpa.caoyingjunz.io/maxReplicas":                  "10",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			annotations: map[string]string{"app": "nginx"},
+			expected: map[string]string{
+				"app":                            "nginx",
+				"hpa.caoyingjunz.io/maxReplicas": "10",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+		},
+		{
+			name: "workload overrides namespace",
+			nsAnnotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas":                  "2",
+				"hpa.caoyingjunz.io/maxReplicas":                  "10",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/maxReplicas":                  "20",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "50",
+			},
+			expected: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas":                  "2",
+				"hpa.caoyingjunz.io/maxReplicas":                  "20",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "50",
+			},
+		},
+		{
+			name: "opt out of defaults",
+			nsAnnotations: map[string]string{
+				"hpa.caoyingjunz.io/maxReplicas":                  "10",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			annotations: map[string]string{InheritNamespaceDefaults: "false"},
+			expected:    map[string]string{InheritNamespaceDefaults: "false"},
+		},
+		{
+			name: "explicitly inherit defaults",
+			nsAnnotations: map[string]string{
+				"hpa.caoyingjunz.io/maxReplicas": "10",
+			},
+			annotations: map[string]string{InheritNamespaceDefaults: "true"},
+			expected: map[string]string{
+				InheritNamespaceDefaults:         "true",
+				"hpa.caoyingjunz.io/maxReplicas": "10",
+			},
+		},
+		{
+			name: "namespace opt-out and foreign annotations are not inherited",
+			nsAnnotations: map[string]string{
+				InheritNamespaceDefaults:                      "false",
+				"hpa.caoyingjunz.io/maxReplicas":              "10",
+				"scheduler.alpha.kubernetes.io/node-selector": "env=prod",
+			},
+			expected: map[string]string{
+				"hpa.caoyingjunz.io/maxReplicas": "10",
+			},
+		},
+		{
+			name:          "namespace without defaults",
+			nsAnnotations: map[string]string{"owner": "team-a"},
+			annotations:   map[string]string{"app": "nginx"},
+			expected:      map[string]string{"app": "nginx"},
+		},
+		{
+			name:        "namespace not found",
+			annotations: map[string]string{"app": "nginx"},
+			expected:    map[string]string{"app": "nginx"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ns *v1.Namespace
+			if test.nsAnnotations != nil {
+				ns = &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Annotations: test.nsAnnotations}}
+			}
+			w := newTestWorkload(test.annotations, "nginx")
+
+			w.MergeNamespaceDefaults(ns)
+			if !reflect.DeepEqual(w.Annotations, test.expected) {
+				t.Errorf("expected annotations %v, got %v", test.expected, w.Annotations)
+			}
+			// 合并不影响工作负载对象本身的注释
+			if object := w.Object.(metav1.Object).GetAnnotations(); !reflect.DeepEqual(object, test.annotations) {
+				t.Errorf("expected object annotations %v unchanged, got %v", test.annotations, object)
+			}
+		})
+	}
+}
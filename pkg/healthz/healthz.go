@@ -0,0 +1,137 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package healthz aggregates named health checks and serves them on the
+// /healthz, /readyz and /livez style endpoints.
+package healthz
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// HealthChecker is a named health check.
+type HealthChecker interface {
+	Name() string
+	Check(req *http.Request) error
+}
+
+// PingHealthz returns true automatically when checked.
+var PingHealthz HealthChecker = ping{}
+
+type ping struct{}
+
+func (ping) Name() string {
+	return "ping"
+}
+
+func (ping) Check(_ *http.Request) error {
+	return nil
+}
+
+// NamedCheck returns a health checker for the given name and function.
+func NamedCheck(name string, check func(r *http.Request) error) HealthChecker {
+	return &healthzCheck{name: name, check: check}
+}
+
+type healthzCheck struct {
+	name  string
+	check func(r *http.Request) error
+}
+
+func (c *healthzCheck) Name() string {
+	return c.name
+}
+
+func (c *healthzCheck) Check(r *http.Request) error {
+	return c.check(r)
+}
+
+// InstallPathHandler registers the handler of the checks on the path, and a
+// handler for each check on path/<name>.
+func InstallPathHandler(mux *http.ServeMux, path string, checks ...HealthChecker) {
+	klog.V(1).Infof("Installing health checkers for (%v): %v", path, checkerNames(checks...))
+
+	mux.Handle(path, handleRootHealth(path, checks...))
+	for _, check := range checks {
+		mux.Handle(fmt.Sprintf("%s/%s", path, check.Name()), adaptCheckToHandler(check.Check))
+	}
+}
+
+// handleRootHealth returns a handler which runs all the checks, the detail of
+// each check is reported with ?verbose, the checks are skipped by ?exclude=<name>.
+func handleRootHealth(path string, checks ...HealthChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		excluded := make(map[string]bool)
+		for _, name := range r.URL.Query()["exclude"] {
+			excluded[strings.TrimSpace(name)] = true
+		}
+
+		var failed []string
+		var verboseOut bytes.Buffer
+		for _, check := range checks {
+			if excluded[check.Name()] {
+				fmt.Fprintf(&verboseOut, "[+]%s excluded: ok\n", check.Name())
+				continue
+			}
+			if err := check.Check(r); err != nil {
+				klog.V(2).Infof("%s check %q failed: %v", strings.TrimPrefix(path, "/"), check.Name(), err)
+				fmt.Fprintf(&verboseOut, "[-]%s failed: %v\n", check.Name(), err)
+				failed = append(failed, check.Name())
+				continue
+			}
+			fmt.Fprintf(&verboseOut, "[+]%s ok\n", check.Name())
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if len(failed) != 0 {
+			klog.V(2).Infof("%s check failed: %v", strings.TrimPrefix(path, "/"), failed)
+			http.Error(w, fmt.Sprintf("%s%s check failed", verboseOut.String(), path), http.StatusInternalServerError)
+			return
+		}
+
+		if _, verbose := r.URL.Query()["verbose"]; !verbose {
+			fmt.Fprint(w, "ok")
+			return
+		}
+		verboseOut.WriteTo(w)
+		fmt.Fprintf(w, "%s check passed\n", path)
+	}
+}
+
+// adaptCheckToHandler returns a handler of the single check.
+func adaptCheckToHandler(c func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := c(r); err != nil {
+			http.Error(w, fmt.Sprintf("internal server error: %v", err), http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	}
+}
+
+func checkerNames(checks ...HealthChecker) []string {
+	names := make([]string, 0, len(checks))
+	for _, check := range checks {
+		names = append(names, check.Name())
+	}
+	return names
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package healthz
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInstallPathHandler(t *testing.T) {
+	failing := NamedCheck("bad", func(_ *http.Request) error {
+		return errors.New("this will fail")
+	})
+	passing := NamedCheck("good", func(_ *http.Request) error {
+		return nil
+	})
+
+	mux := http.NewServeMux()
+	InstallPathHandler(mux, "/livez", PingHealthz, passing)
+	InstallPathHandler(mux, "/readyz", PingHealthz, passing, failing)
+
+	tests := []struct {
+		path       string
+		expectCode int
+		expectBody string
+	}{
+		{"/livez", http.StatusOK, "ok"},
+		{"/livez?verbose", http.StatusOK, "[+]ping ok\n[+]good ok\n/livez check passed\n"},
+		{"/livez/good", http.StatusOK, "ok"},
+		{"/readyz", http.StatusInternalServerError, "[+]ping ok\n[+]good ok\n[-]bad failed: this will fail\n/readyz check failed\n"},
+		{"/readyz?exclude=bad", http.StatusOK, "ok"},
+		{"/readyz?exclude=bad&verbose", http.StatusOK, "[+]ping ok\n[+]good ok\n[+]bad excluded: ok\n/readyz check passed\n"},
+		{"/readyz/bad", http.StatusInternalServerError, "internal server error: this will fail\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if w.Code != test.expectCode {
+				t.Errorf("expected code %d, got %d", test.expectCode, w.Code)
+			}
+			if w.Body.String() != test.expectBody {
+				t.Errorf("expected body %q, got %q", test.expectBody, w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+	"k8s.io/klog/v2"
+
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller"
+)
+
+const (
+	// ValidatePath is the path of the validating webhook.
+	ValidatePath = "/validate"
+
+	// CertFile and KeyFile are the file names of the serving certificate in the cert dir.
+	CertFile = "tls.crt"
+	KeyFile  = "tls.key"
+
+	// maxRequestBytes 限制请求体的大小，避免异常请求占用过多内存
+	maxRequestBytes = 3 * 1024 * 1024
+)
+
+var (
+	deploymentKind  = appsv1.SchemeGroupVersion.WithKind(controller.Deployment)
+	statefulSetKind = appsv1.SchemeGroupVersion.WithKind(controller.StatefulSet)
+)
+
+// Config is the configuration of the webhook server.
+type Config struct {
+	// BindAddress is the IP address to serve on.
+	BindAddress string
+	// Port is the port to serve on.
+	Port int
+	// CertDir contains tls.crt and tls.key, they are generated if not exist.
+	CertDir string
+	// CertHosts are the DNS names and IPs of the generated certificate, the first
+	// one is used as the common name.
+	CertHosts []string
+	// Namespaces gets the namespace of the workload, whose default hpa annotations
+	// are validated together with the workload's own. It is optional.
+	Namespaces corev1client.NamespaceInterface
+	// Scope limits the validated workloads to those reconciled by the controller,
+	// the workloads out of scope are allowed unchanged. It is optional.
+	Scope *controller.Scope
+}
+
+// Server is the validating admission webhook server for the hpa annotations.
+type Server struct {
+	config Config
+	server *http.Server
+}
+
+// NewServer creates a webhook server, the serving certificate is loaded from
+// the cert dir, or generated if not exist. The certificate is reloaded once
+// the files are changed, for example renewed by cert-manager.
+func NewServer(c Config) (*Server, error) {
+	certificate, err := LoadOrGenerateCertificate(c.CertDir, c.CertHosts)
+	if err != nil {
+		return nil, err
+	}
+	loader, err := newCertificateLoader(c.CertDir, certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{config: c}
+	s.server = &http.Server{
+		Addr:    net.JoinHostPort(c.BindAddress, strconv.Itoa(c.Port)),
+		Handler: s.Handler(),
+		TLSConfig: &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: loader.GetCertificate,
+		},
+	}
+	return s, nil
+}
+
+// Handler returns the http handler of the webhook.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ValidatePath, s.serveValidate)
+	return mux
+}
+
+// Run starts the webhook server until stopCh is closed.
+func (s *Server) Run(stopCh <-chan struct{}) error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener, stopCh)
+}
+
+// Serve serves the webhook on the listener until stopCh is closed.
+func (s *Server) Serve(listener net.Listener, stopCh <-chan struct{}) error {
+	go func() {
+		<-stopCh
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.server.Shutdown(ctx); err != nil {
+			klog.Errorf("failed to shutdown webhook server: %v", err)
+		}
+	}()
+
+	klog.Infof("Starting webhook server on %s", listener.Addr().String())
+	if err := s.server.ServeTLS(listener, "", ""); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) serveValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, fmt.Sprintf("method %s is not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	if contentType := r.Header.Get("Content-Type"); contentType != "application/json" {
+		http.Error(w, fmt.Sprintf("unsupported content type %q, expected application/json", contentType), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to read request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	review := &admissionv1.AdmissionReview{}
+	if err = json.Unmarshal(body, review); err != nil {
+		http.Error(w, fmt.Sprintf("failed to decode admission review: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "admission review has no request", http.StatusBadRequest)
+		return
+	}
+
+	response := Validate(r.Context(), review.Request, s.config.Namespaces, s.config.Scope)
+	response.UID = review.Request.UID
+	review.Response = response
+	review.Request = nil
+
+	data, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode admission review: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(data); err != nil {
+		klog.Errorf("failed to write admission response: %v", err)
+	}
+}
+
+// Validate validates the hpa annotations of the workload in the admission request,
+// the default annotations of its namespace are merged if namespaces is not nil.
+// The workloads out of the scope are allowed, a nil scope contains everything.
+// An update is validated only if the hpa annotations are changed, and the invalid
+// namespace defaults are returned as warnings.
+func Validate(ctx context.Context, request *admissionv1.AdmissionRequest, namespaces corev1client.NamespaceInterface, scope *controller.Scope) *admissionv1.AdmissionResponse {
+	if request.Operation != admissionv1.Create && request.Operation != admissionv1.Update {
+		return allowed()
+	}
+	if scope == nil {
+		scope = &controller.Scope{}
+	}
+
+	w, err := decodeWorkload(request, request.Object)
+	if err != nil {
+		return badRequest(err)
+	}
+	if w == nil {
+		return allowed()
+	}
+	// 与控制器一致，不在范围内的工作负载不会被同步，因此不校验
+	if !scope.ContainsNamespace(w.Namespace) || !scope.MatchesWorkload(w.Labels) {
+		return allowed()
+	}
+
+	// 更新时 hpa 注释未变化则不校验，已有的错误注释不阻塞无关的变更（例如更新镜像）
+	if request.Operation == admissionv1.Update {
+		old, err := decodeWorkload(request, request.OldObject)
+		if err != nil {
+			return badRequest(err)
+		}
+		if reflect.DeepEqual(pixiuAnnotations(old.Annotations), pixiuAnnotations(w.Annotations)) {
+			return allowed()
+		}
+	}
+
+	// 命名空间的标签用于 namespaceSelector，与控制器一致，命名空间的默认注释视为工作负载自身的注释
+	var ns *v1.Namespace
+	if namespaces != nil && (w.InheritsNamespaceDefaults() || scope.SelectsNamespaces()) {
+		ns, err = namespaces.Get(ctx, w.Namespace, metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			// 无法获取命名空间时不拒绝请求，由控制器在同步时校验
+			klog.Warningf("Failed to get namespace %s of %s %s: %v", w.Namespace, w.Kind, w.Name, err)
+			response := allowed()
+			response.Warnings = []string{fmt.Sprintf("hpa annotations of %s %s are not validated: failed to get namespace %s", w.Kind, w.Name, w.Namespace)}
+			return response
+		}
+		if err != nil {
+			ns = nil
+		}
+	}
+	// 命名空间不存在时无法判断其标签，仍然校验
+	if ns != nil && !scope.MatchesNamespace(ns.Labels) {
+		return allowed()
+	}
+	// 工作负载不继承时不合并命名空间的默认注释
+	if !w.InheritsNamespaceDefaults() {
+		ns = nil
+	}
+
+	merged := *w
+	merged.MergeNamespaceDefaults(ns)
+	if err = controller.ValidateWorkload(&merged); err == nil {
+		return allowed()
+	}
+
+	// 命名空间的默认注释本身错误时仅给出警告，避免一个错误的默认注释阻塞命名空间中所有的变更
+	defaults := *w
+	defaults.Annotations = controller.NamespaceDefaults(ns)
+	if defaultsErr := controller.ValidateWorkload(&defaults); defaultsErr != nil {
+		if err = controller.ValidateWorkload(w); err == nil {
+			response := allowed()
+			response.Warnings = []string{fmt.Sprintf("invalid hpa annotations inherited from namespace %s: %v", w.Namespace, defaultsErr)}
+			return response
+		}
+	}
+
+	klog.V(2).Infof("Rejected %s %s/%s: %v", w.Kind, request.Namespace, w.Name, err)
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusUnprocessableEntity,
+			Reason:  metav1.StatusReasonInvalid,
+			Message: fmt.Sprintf("invalid hpa annotations of %s %s: %v", w.Kind, w.Name, err),
+		},
+	}
+}
+
+// pixiuAnnotations returns the hpa annotations in the annotations.
+func pixiuAnnotations(annotations map[string]string) map[string]string {
+	pixiu := make(map[string]string)
+	for k, v := range annotations {
+		if controller.IsPixiuAnnotation(k) {
+			pixiu[k] = v
+		}
+	}
+	return pixiu
+}
+
+// decodeWorkload decodes the workload from the object of the request, nil is
+// returned if the kind is not supported.
+func decodeWorkload(request *admissionv1.AdmissionRequest, object runtime.RawExtension) (*controller.Workload, error) {
+	gvk := schema.GroupVersionKind{Group: request.Kind.Group, Version: request.Kind.Version, Kind: request.Kind.Kind}
+
+	var w *controller.Workload
+	switch gvk {
+	case deploymentKind:
+		d := &appsv1.Deployment{}
+		if err := json.Unmarshal(object.Raw, d); err != nil {
+			return nil, fmt.Errorf("failed to decode deployment: %v", err)
+		}
+		w = controller.NewWorkloadFromDeployment(d)
+	case statefulSetKind:
+		s := &appsv1.StatefulSet{}
+		if err := json.Unmarshal(object.Raw, s); err != nil {
+			return nil, fmt.Errorf("failed to decode statefulset: %v", err)
+		}
+		w = controller.NewWorkloadFromStatefulSet(s)
+	default:
+		return nil, nil
+	}
+
+	// 创建时对象的 namespace 可能为空，以请求中的为准
+	if w.Namespace == "" {
+		w.Namespace = request.Namespace
+	}
+	return w, nil
+}
+
+func badRequest(err error) *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusBadRequest,
+			Reason:  metav1.StatusReasonBadRequest,
+			Message: err.Error(),
+		},
+	}
+}
+
+func allowed() *admissionv1.AdmissionResponse {
+	return &admissionv1.AdmissionResponse{Allowed: true}
+}
+
+// LoadOrGenerateCertificate loads the serving certificate from the cert dir, a
+// self-signed certificate for the hosts is generated and written to the cert dir
+// if not exist.
+func LoadOrGenerateCertificate(certDir string, hosts []string) (tls.Certificate, error) {
+	certPath := filepath.Join(certDir, CertFile)
+	keyPath := filepath.Join(certDir, KeyFile)
+
+	ok, err := certutil.CanReadCertAndKey(certPath, keyPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if !ok {
+		if len(hosts) == 0 {
+			return tls.Certificate{}, fmt.Errorf("no host is given to generate the serving certificate")
+		}
+		var (
+			ips []net.IP
+			dns []string
+		)
+		for _, host := range hosts[1:] {
+			if ip := net.ParseIP(host); ip != nil {
+				ips = append(ips, ip)
+			} else {
+				dns = append(dns, host)
+			}
+		}
+
+		certData, keyData, err := certutil.GenerateSelfSignedCertKey(hosts[0], ips, dns)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("failed to generate serving certificate: %v", err)
+		}
+		if err = os.MkdirAll(certDir, 0755); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err = certutil.WriteCert(certPath, certData); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err = keyutil.WriteKey(keyPath, keyData); err != nil {
+			return tls.Certificate{}, err
+		}
+		// 每个副本生成的证书不同，caBundle 只能信任其中一个，多副本时需挂载同一证书
+		klog.Warningf("Generated self-signed serving certificate %s for %v, it is only trusted by the caBundle of this replica, "+
+			"mount a shared certificate into the cert dir if there are more replicas", certPath, hosts)
+	}
+
+	return tls.LoadX509KeyPair(certPath, keyPath)
+}
+
+// certificateLoader reloads the serving certificate from the cert dir once the
+// certificate file is modified.
+type certificateLoader struct {
+	certPath string
+	keyPath  string
+
+	lock        sync.Mutex
+	certificate *tls.Certificate
+	modTime     time.Time
+}
+
+func newCertificateLoader(certDir string, certificate tls.Certificate) (*certificateLoader, error) {
+	l := &certificateLoader{
+		certPath:    filepath.Join(certDir, CertFile),
+		keyPath:     filepath.Join(certDir, KeyFile),
+		certificate: &certificate,
+	}
+	info, err := os.Stat(l.certPath)
+	if err != nil {
+		return nil, err
+	}
+	l.modTime = info.ModTime()
+	return l, nil
+}
+
+// GetCertificate returns the latest serving certificate, the loaded one is
+// kept if the new files can not be loaded, for example written partially.
+func (l *certificateLoader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	info, err := os.Stat(l.certPath)
+	if err != nil || info.ModTime().Equal(l.modTime) {
+		return l.certificate, nil
+	}
+	certificate, err := tls.LoadX509KeyPair(l.certPath, l.keyPath)
+	if err != nil {
+		klog.Warningf("Failed to reload serving certificate %s: %v", l.certPath, err)
+		return l.certificate, nil
+	}
+	klog.Infof("Reloaded serving certificate %s", l.certPath)
+	l.certificate, l.modTime = &certificate, info.ModTime()
+	return l.certificate, nil
+}
@@ -0,0 +1,574 @@
+/*
+Copyright 2021 The Pixiu Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+	certutil "k8s.io/client-go/util/cert"
+
+	"github.com/caoyingjunz/pixiu-autoscaler/pkg/controller"
+)
+
+func newDeployment(annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test1",
+			Namespace:   "default",
+			Annotations: annotations,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				Spec: v1.PodSpec{
+					Containers: []v1.Container{{Name: "nginx", Image: "nginx"}},
+				},
+			},
+		},
+	}
+}
+
+func newRequest(t *testing.T, operation admissionv1.Operation, kind metav1.GroupVersionKind, obj interface{}) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		t.Fatalf("failed to marshal object: %v", err)
+	}
+	return &admissionv1.AdmissionRequest{
+		UID:       types.UID("a4e5ee3c-5d7e-4b8f-9a8e-1d7f2c3b4a5e"),
+		Kind:      kind,
+		Namespace: "default",
+		Operation: operation,
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+var deploymentGVK = metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		operation   admissionv1.Operation
+		kind        metav1.GroupVersionKind
+		annotations map[string]string
+		// oldAnnotations 为更新前工作负载的注释
+		oldAnnotations map[string]string
+		// nsAnnotations 为 nil 时命名空间不存在
+		nsAnnotations map[string]string
+		// nsErr 为获取命名空间时返回的错误
+		nsErr    error
+		nsLabels map[string]string
+		labels   map[string]string
+		scope    *controller.Scope
+		allowed  bool
+		message  string
+		warned   bool
+	}{
+		{
+			name:      "no hpa annotations",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			allowed:   true,
+		},
+		{
+			name:      "valid annotations",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas":                  "2",
+				"hpa.caoyingjunz.io/maxReplicas":                  "10",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			allowed: true,
+		},
+		{
+			name:      "maxReplicas below minReplicas",
+			operation: admissionv1.Update,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas":                  "5",
+				"hpa.caoyingjunz.io/maxReplicas":                  "2",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			message: `metadata.annotations[hpa.caoyingjunz.io/maxReplicas]: Invalid value: "2": must be greater than or equal to hpa.caoyingjunz.io/minReplicas(5)`,
+		},
+		{
+			name:      "non-numeric utilization",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			message: "metadata.annotations[cpu.hpa.caoyingjunz.io/targetAverageUtilization]",
+		},
+		{
+			name:      "prometheus metric without targetCustomMetric",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"prometheus.hpa.caoyingjunz.io/targetAverageValue": "100",
+			},
+			message: "failed to get targetCustomMetric from annotations",
+		},
+		{
+			name:      "unknown container",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/container.redis.targetAverageUtilization": "60",
+			},
+			message: "redis",
+		},
+		{
+			name:      "delete is always allowed",
+			operation: admissionv1.Delete,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			allowed: true,
+		},
+		{
+			name:      "unsupported kind is allowed",
+			operation: admissionv1.Create,
+			kind:      metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "DaemonSet"},
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			allowed: true,
+		},
+		{
+			name:      "namespace defaults complete the workload",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas": "2",
+			},
+			nsAnnotations: map[string]string{
+				"hpa.caoyingjunz.io/maxReplicas":                  "10",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			allowed: true,
+		},
+		{
+			name:      "workload conflicts with namespace defaults",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas": "20",
+			},
+			nsAnnotations: map[string]string{
+				"hpa.caoyingjunz.io/maxReplicas":                  "10",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			message: `metadata.annotations[hpa.caoyingjunz.io/maxReplicas]: Invalid value: "10": must be greater than or equal to hpa.caoyingjunz.io/minReplicas(20)`,
+		},
+		{
+			// 错误的默认注释不阻塞命名空间中的变更
+			name:      "invalid namespace defaults are warned",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/maxReplicas": "10",
+			},
+			nsAnnotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			allowed: true,
+			warned:  true,
+		},
+		{
+			name:      "invalid annotations with invalid namespace defaults",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas":                  "5",
+				"hpa.caoyingjunz.io/maxReplicas":                  "2",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			nsAnnotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+				"hpa.caoyingjunz.io/minReplicas":                  "ten",
+			},
+			message: `metadata.annotations[hpa.caoyingjunz.io/maxReplicas]: Invalid value: "2"`,
+		},
+		{
+			// 例如仅更新镜像或副本数
+			name:      "update without changing invalid annotations",
+			operation: admissionv1.Update,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+				"app": "nginx",
+			},
+			oldAnnotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			allowed: true,
+		},
+		{
+			name:      "update changing annotations to invalid ones",
+			operation: admissionv1.Update,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			oldAnnotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+			},
+			message: "metadata.annotations[cpu.hpa.caoyingjunz.io/targetAverageUtilization]",
+		},
+		{
+			name:      "workload opts out of namespace defaults",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/inheritNamespaceDefaults": "false",
+			},
+			nsAnnotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			allowed: true,
+		},
+		{
+			name:      "namespace not included",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			scope:   &controller.Scope{IncludeNamespaces: sets.NewString("team-a")},
+			allowed: true,
+		},
+		{
+			name:      "namespace excluded",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			scope:   &controller.Scope{ExcludeNamespaces: sets.NewString("default")},
+			allowed: true,
+		},
+		{
+			name:      "workload not selected",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			labels:  map[string]string{"app": "redis"},
+			scope:   &controller.Scope{WorkloadSelector: labels.SelectorFromSet(labels.Set{"app": "nginx"})},
+			allowed: true,
+		},
+		{
+			name:      "workload selected",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			labels:  map[string]string{"app": "nginx"},
+			scope:   &controller.Scope{WorkloadSelector: labels.SelectorFromSet(labels.Set{"app": "nginx"})},
+			message: "metadata.annotations[cpu.hpa.caoyingjunz.io/targetAverageUtilization]",
+		},
+		{
+			name:      "namespace not selected",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			nsLabels: map[string]string{"tenant": "other"},
+			scope:    &controller.Scope{NamespaceSelector: labels.SelectorFromSet(labels.Set{"tenant": "pixiu"})},
+			allowed:  true,
+		},
+		{
+			name:      "namespace selected",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/inheritNamespaceDefaults":     "false",
+				"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "seventy",
+			},
+			nsLabels: map[string]string{"tenant": "pixiu"},
+			scope:    &controller.Scope{NamespaceSelector: labels.SelectorFromSet(labels.Set{"tenant": "pixiu"})},
+			message:  "metadata.annotations[cpu.hpa.caoyingjunz.io/targetAverageUtilization]",
+		},
+		{
+			name:      "failed to get namespace",
+			operation: admissionv1.Create,
+			kind:      deploymentGVK,
+			annotations: map[string]string{
+				"hpa.caoyingjunz.io/minReplicas": "20",
+			},
+			nsErr:   fmt.Errorf("the server is currently unable to handle the request"),
+			allowed: true,
+			warned:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			if test.nsAnnotations != nil || test.nsLabels != nil {
+				client = fake.NewSimpleClientset(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: test.nsLabels, Annotations: test.nsAnnotations}})
+			}
+			if test.nsErr != nil {
+				client.PrependReactor("get", "namespaces", func(action clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, test.nsErr
+				})
+			}
+
+			d := newDeployment(test.annotations)
+			d.Labels = test.labels
+			request := newRequest(t, test.operation, test.kind, d)
+			if test.operation == admissionv1.Update {
+				request.OldObject = newRequest(t, test.operation, test.kind, newDeployment(test.oldAnnotations)).Object
+			}
+			response := Validate(context.TODO(), request, client.CoreV1().Namespaces(), test.scope)
+			if response.Allowed != test.allowed {
+				t.Fatalf("expected allowed %v, got %v: %v", test.allowed, response.Allowed, response.Result)
+			}
+			if warned := len(response.Warnings) != 0; warned != test.warned {
+				t.Errorf("expected warned %v, got %v", test.warned, response.Warnings)
+			}
+			if test.allowed {
+				return
+			}
+			if response.Result == nil || !strings.Contains(response.Result.Message, test.message) {
+				t.Errorf("expected message containing %q, got %v", test.message, response.Result)
+			}
+		})
+	}
+}
+
+func TestServerWithGeneratedCertificate(t *testing.T) {
+	certDir := t.TempDir()
+	server, err := NewServer(Config{
+		BindAddress: "127.0.0.1",
+		CertDir:     certDir,
+		CertHosts:   []string{"127.0.0.1", "localhost"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go func() {
+		if err := server.Serve(listener, stopCh); err != nil {
+			t.Errorf("failed to serve: %v", err)
+		}
+	}()
+
+	// 使用生成的自签名证书校验服务端
+	certData, err := ioutil.ReadFile(filepath.Join(certDir, CertFile))
+	if err != nil {
+		t.Fatalf("failed to read generated certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(certData) {
+		t.Fatalf("failed to parse generated certificate")
+	}
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+
+	request := newRequest(t, admissionv1.Create, deploymentGVK, newDeployment(map[string]string{
+		"hpa.caoyingjunz.io/maxReplicas":                  "0",
+		"cpu.hpa.caoyingjunz.io/targetAverageUtilization": "70",
+	}))
+	body, err := json.Marshal(&admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request:  request,
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal admission review: %v", err)
+	}
+
+	resp, err := client.Post("https://"+listener.Addr().String()+ValidatePath, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to post admission review: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	review := &admissionv1.AdmissionReview{}
+	if err = json.NewDecoder(resp.Body).Decode(review); err != nil {
+		t.Fatalf("failed to decode admission review: %v", err)
+	}
+	if review.Response == nil {
+		t.Fatalf("expected response in admission review")
+	}
+	if review.Response.UID != request.UID {
+		t.Errorf("expected uid %s, got %s", request.UID, review.Response.UID)
+	}
+	if review.Response.Allowed {
+		t.Errorf("expected the request to be rejected")
+	}
+	if review.APIVersion != "admission.k8s.io/v1" || review.Kind != "AdmissionReview" {
+		t.Errorf("unexpected type meta %v", review.TypeMeta)
+	}
+
+	// 再次创建时复用已生成的证书
+	certificate, err := LoadOrGenerateCertificate(certDir, nil)
+	if err != nil {
+		t.Fatalf("failed to load generated certificate: %v", err)
+	}
+	if len(certificate.Certificate) == 0 {
+		t.Errorf("expected certificate to be loaded")
+	}
+}
+
+func TestServeValidateRejectsBadRequests(t *testing.T) {
+	server := &Server{}
+	handler := server.Handler()
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		status      int
+	}{
+		{name: "get", method: http.MethodGet, contentType: "application/json", status: http.StatusMethodNotAllowed},
+		{name: "content type", method: http.MethodPost, contentType: "text/plain", body: "{}", status: http.StatusUnsupportedMediaType},
+		{name: "malformed body", method: http.MethodPost, contentType: "application/json", body: "{", status: http.StatusBadRequest},
+		{name: "no request", method: http.MethodPost, contentType: "application/json", body: "{}", status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, ValidatePath, strings.NewReader(test.body))
+			if err != nil {
+				t.Fatalf("failed to create request: %v", err)
+			}
+			req.Header.Set("Content-Type", test.contentType)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+			if recorder.Code != test.status {
+				t.Errorf("expected status %d, got %d", test.status, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestCertificateLoader(t *testing.T) {
+	certDir := t.TempDir()
+	certificate, err := LoadOrGenerateCertificate(certDir, []string{"pixiu-autoscaler-webhook.pixiu-system.svc"})
+	if err != nil {
+		t.Fatalf("failed to generate certificate: %v", err)
+	}
+	loader, err := newCertificateLoader(certDir, certificate)
+	if err != nil {
+		t.Fatalf("failed to create certificate loader: %v", err)
+	}
+
+	// write 写入证书并推迟其修改时间，模拟 cert-manager 更新 secret
+	certPath, keyPath := filepath.Join(certDir, CertFile), filepath.Join(certDir, KeyFile)
+	modTime := time.Now()
+	write := func(certData, keyData []byte) {
+		if err := ioutil.WriteFile(certPath, certData, 0644); err != nil {
+			t.Fatalf("failed to write certificate: %v", err)
+		}
+		if err := ioutil.WriteFile(keyPath, keyData, 0600); err != nil {
+			t.Fatalf("failed to write key: %v", err)
+		}
+		modTime = modTime.Add(time.Minute)
+		if err := os.Chtimes(certPath, modTime, modTime); err != nil {
+			t.Fatalf("failed to change modification time: %v", err)
+		}
+	}
+	renewedCert, renewedKey, err := certutil.GenerateSelfSignedCertKey("pixiu-autoscaler-webhook.pixiu-system.svc", nil, nil)
+	if err != nil {
+		t.Fatalf("failed to generate certificate: %v", err)
+	}
+	renewed, err := tls.X509KeyPair(renewedCert, renewedKey)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		certData []byte
+		keyData  []byte
+		expected tls.Certificate
+	}{
+		{
+			name:     "unchanged",
+			expected: certificate,
+		},
+		{
+			name:     "renewed",
+			certData: renewedCert,
+			keyData:  renewedKey,
+			expected: renewed,
+		},
+		{
+			// 写入不完整时继续使用已加载的证书
+			name:     "partially written",
+			certData: renewedCert[:len(renewedCert)/2],
+			keyData:  renewedKey,
+			expected: renewed,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.certData != nil {
+				write(test.certData, test.keyData)
+			}
+			got, err := loader.GetCertificate(nil)
+			if err != nil {
+				t.Fatalf("failed to get certificate: %v", err)
+			}
+			if !bytes.Equal(got.Certificate[0], test.expected.Certificate[0]) {
+				t.Errorf("expected the %s certificate", test.name)
+			}
+		})
+	}
+}